@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rsrini7/godiff/diff"
+	"github.com/rsrini7/godiff/utils"
+)
+
+// command line arguments of the apply sub-command
+var (
+	flag_apply_strip   int  = -1
+	flag_apply_reverse bool = false
+	flag_apply_fuzz    int  = diff.PATCH_FUZZ
+	flag_apply_dry_run bool = false
+)
+
+// Number of path components stripped from the last file found, use for new files
+var apply_strip_found = -1
+
+func apply_usage(fs *flag.FlagSet, msg string) {
+	if msg != "" {
+		fmt.Fprintf(os.Stderr, "%s\n", msg)
+	}
+	fmt.Fprint(os.Stderr, "Apply a unified diff, written by godiff -txt -u, diff -u or git diff\n\n")
+	fmt.Fprint(os.Stderr, "usage: godiff apply <options> <patch> [<file|dir>]\n")
+	fs.PrintDefaults()
+	os.Exit(2)
+}
+
+//
+// The apply sub-command: godiff apply <options> <patch> [<file|dir>]
+//
+func apply_main(args []string) {
+
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	fs.Usage = func() { apply_usage(fs, "") }
+	fs.IntVar(&flag_apply_strip, "p", flag_apply_strip, "Strip N leading path components from file names, -1 to find out from the files that exist")
+	fs.BoolVar(&flag_apply_reverse, "reverse", flag_apply_reverse, "Undo the patch")
+	fs.IntVar(&flag_apply_fuzz, "fuzz", flag_apply_fuzz, "Ignore up to N lines of context at the start and end of a hunk that does not match")
+	fs.BoolVar(&flag_apply_dry_run, "dry-run", flag_apply_dry_run, "Report on the result, without changing any file")
+	fs.Parse(args)
+
+	args = fs.Args()
+	if len(args) < 1 {
+		apply_usage(fs, "Missing patch file")
+	}
+	if len(args) > 2 {
+		apply_usage(fs, "Too many files")
+	}
+
+	target := "."
+	if len(args) == 2 {
+		target = args[1]
+	}
+
+	finfo, err := os.Stat(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	patches, err := diff.ParsePatch(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err.Error())
+		os.Exit(1)
+	}
+
+	opts := &diff.ApplyOptions{Reverse: flag_apply_reverse, Fuzz: flag_apply_fuzz}
+
+	failed := false
+	for _, fp := range patches {
+		if len(fp.Hunks) == 0 {
+			continue
+		}
+		fname := target
+		if finfo.IsDir() {
+			fname = patch_target_file(target, fp)
+		}
+		if !apply_file(fname, fp, opts) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+//
+// Find the file to patch in directory dir, from the names in the patch.
+//
+func patch_target_file(dir string, fp *diff.FilePatch) string {
+
+	name1, name2 := fp.Name1, fp.Name2
+	if flag_apply_reverse {
+		name1, name2 = name2, name1
+	}
+
+	name := name1
+	if name == diff.DEV_NULL {
+		name = name2
+	}
+	parts := strings.Split(filepath.ToSlash(name), "/")
+
+	if flag_apply_strip >= 0 {
+		return filepath.Join(dir, filepath.Join(parts[utils.MinInt(flag_apply_strip, len(parts)-1):]...))
+	}
+
+	// strip as many components as needed to find an existing file
+	for i := range parts {
+		fname := filepath.Join(dir, filepath.Join(parts[i:]...))
+		if _, err := os.Stat(fname); err == nil {
+			apply_strip_found = i
+			return fname
+		}
+	}
+
+	// new file, strip the same as the other files, git uses a/ and b/ prefixes
+	if apply_strip_found >= 0 {
+		parts = parts[utils.MinInt(apply_strip_found, len(parts)-1):]
+	} else if len(parts) > 1 && (parts[0] == "a" || parts[0] == "b") {
+		parts = parts[1:]
+	}
+	return filepath.Join(dir, filepath.Join(parts...))
+}
+
+//
+// Apply the changes to a file, and save the hunks that failed in a .rej file
+// Return false if any hunk failed.
+//
+func apply_file(fname string, fp *diff.FilePatch, opts *diff.ApplyOptions) bool {
+
+	fmt.Printf("patching file %s\n", fname)
+
+	var data []byte
+	mode := os.FileMode(0644)
+	if finfo, err := os.Stat(fname); err == nil {
+		mode = finfo.Mode()
+		data, err = ioutil.ReadFile(fname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return false
+		}
+	}
+
+	result, hunks := diff.ApplyPatch(data, fp, opts)
+
+	var rejects []*diff.PatchHunk
+	for i, h := range hunks {
+		switch {
+		case !h.Applied:
+			fmt.Printf("Hunk #%d FAILED at %d.\n", i+1, h.Line)
+			rejects = append(rejects, fp.Hunks[i])
+		case h.Fuzz > 0:
+			fmt.Printf("Hunk #%d succeeded at %d with fuzz %d (offset %d lines).\n", i+1, h.Line, h.Fuzz, h.Offset)
+		case h.Offset != 0:
+			fmt.Printf("Hunk #%d succeeded at %d (offset %d lines).\n", i+1, h.Line, h.Offset)
+		}
+	}
+
+	if flag_apply_dry_run {
+		return len(rejects) == 0
+	}
+
+	// file is deleted if the patch removes all the lines
+	deleted := true
+	for _, h := range fp.Hunks {
+		if (!opts.Reverse && h.Count2 > 0) || (opts.Reverse && h.Count1 > 0) {
+			deleted = false
+		}
+	}
+
+	if len(result) == 0 && deleted {
+		// file deleted by the patch
+		os.Remove(fname)
+	} else if len(rejects) < len(hunks) {
+		CreateDirIfNotExist(filepath.Dir(fname))
+		if err := ioutil.WriteFile(fname, result, mode); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return false
+		}
+	}
+
+	if len(rejects) > 0 {
+		rej := fname + ".rej"
+		fmt.Printf("%d out of %d hunks FAILED -- saving rejects to file %s\n", len(rejects), len(hunks), rej)
+
+		var buf strings.Builder
+		fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fp.Name1, fp.Name2)
+		for _, h := range rejects {
+			buf.WriteString(h.String())
+		}
+		if err := ioutil.WriteFile(rej, []byte(buf.String()), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		}
+		return false
+	}
+	return true
+}
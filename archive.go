@@ -0,0 +1,406 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Separate the name of an archive from the path of a file in it, ie. "release.zip!/conf/app.properties"
+const ARCHIVE_SEPARATOR = "!"
+
+// Files with these suffixes are compared as directories
+var archive_suffixes = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+const (
+	ARCHIVE_MAX_ENTRY_SIZE = 1e8     // larger files are not uncompressed, and reported as too big like in open_file()
+	ARCHIVE_MAX_SIZE       = 1 << 30 // total size of the files of a tar archive kept in memory
+)
+
+// A file or directory in an archive
+type ArchiveEntry struct {
+	name    string // base name
+	mode    os.FileMode
+	modtime time.Time
+	size    int64         // uncompressed size
+	data    []byte        // files in tar archives, nil if too big
+	zfile   *zip.File     // files in zip archives, uncompressed when read
+	entries []os.FileInfo // directories only
+}
+
+func (e *ArchiveEntry) Name() string {
+	return e.name
+}
+
+func (e *ArchiveEntry) Size() int64        { return e.size }
+func (e *ArchiveEntry) Mode() os.FileMode  { return e.mode }
+func (e *ArchiveEntry) ModTime() time.Time { return e.modtime }
+func (e *ArchiveEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *ArchiveEntry) Sys() interface{}   { return nil }
+
+// An archive file shown as a directory, named "release.zip!"
+type ArchiveDirInfo struct {
+	os.FileInfo
+}
+
+func (a *ArchiveDirInfo) Name() string { return a.FileInfo.Name() + ARCHIVE_SEPARATOR }
+func (a *ArchiveDirInfo) IsDir() bool  { return true }
+
+// The files and directories of an archive, by path. The root directory is "".
+type Archive map[string]*ArchiveEntry
+
+// An archive in the cache, with the directory comparisons and queued jobs using it
+type OpenArchive struct {
+	entries Archive
+	file    *os.File // zip archive read from the disk, closed when released
+	refs    int
+}
+
+// Archives in use, by name
+var (
+	archive_cache = make(map[string]*OpenArchive)
+	archive_lock  sync.Mutex
+)
+
+// Report if a file is compared as a directory
+func is_archive(name string) bool {
+	for _, suffix := range archive_suffixes {
+		if strings.HasSuffix(strings.ToLower(name), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Split the name of a file in an archive: "release.zip!/conf/app.properties" into "release.zip" and "conf/app.properties".
+// An archive in an archive is split at the last separator: "a.zip!/b.tar!/c" into "a.zip!/b.tar" and "c".
+// Return false if the name is not in an archive.
+//
+func split_archive_path(name string) (string, string, bool) {
+	for i := strings.LastIndex(name, ARCHIVE_SEPARATOR); i > 0; i = strings.LastIndex(name[:i], ARCHIVE_SEPARATOR) {
+		rest := name[i+len(ARCHIVE_SEPARATOR):]
+		if (rest == "" || rest[0] == '/') && is_archive(name[:i]) {
+			return name[:i], strings.Trim(rest, "/"), true
+		}
+	}
+	return "", "", false
+}
+
+//
+// Join a directory and a file name, the names in archives are always separated by '/'
+//
+func join_path(dirname, name string) string {
+	if _, _, ok := split_archive_path(dirname); ok {
+		return dirname + "/" + name
+	}
+	return dirname + PATH_SEPARATOR + name
+}
+
+//
+// Show the archive files as directories, named "release.zip!"
+//
+func archives_as_dirs(all []os.FileInfo) []os.FileInfo {
+	for i, f := range all {
+		if !f.IsDir() && is_archive(f.Name()) {
+			all[i] = &ArchiveDirInfo{f}
+		}
+	}
+	return all
+}
+
+//
+// The archives containing a file, the outermost first: "a.zip" and "a.zip!/b.tar" for "a.zip!/b.tar!/c".
+//
+func enclosing_archives(name string) []string {
+	var names []string
+	for archive, _, ok := split_archive_path(name); ok; archive, _, ok = split_archive_path(archive) {
+		names = append([]string{archive}, names...)
+	}
+	return names
+}
+
+//
+// Keep the archives containing these files in memory, until release_archives() is called for the same files.
+// An archive is read once for a directory comparison and its queued jobs, and dropped afterwards.
+//
+func hold_archives(names ...string) {
+	for _, name := range names {
+		for _, archive := range enclosing_archives(name) {
+			archive_lock.Lock()
+			oa, ok := archive_cache[archive]
+			if ok {
+				oa.refs++
+			}
+			archive_lock.Unlock()
+			if ok {
+				continue
+			}
+
+			// read without the lock, an archive in an archive reads its parent; errors are reported when listed
+			oa, err := load_archive(archive)
+			if err != nil {
+				continue
+			}
+			archive_lock.Lock()
+			if old, ok := archive_cache[archive]; ok {
+				old.refs++
+				oa.close()
+			} else {
+				oa.refs = 1
+				archive_cache[archive] = oa
+			}
+			archive_lock.Unlock()
+		}
+	}
+}
+
+// Drop the archives from memory when no longer used, see hold_archives()
+func release_archives(names ...string) {
+	for _, name := range names {
+		for _, archive := range enclosing_archives(name) {
+			archive_lock.Lock()
+			if oa, ok := archive_cache[archive]; ok {
+				if oa.refs--; oa.refs <= 0 {
+					delete(archive_cache, archive)
+					oa.close()
+				}
+			}
+			archive_lock.Unlock()
+		}
+	}
+}
+
+func (oa *OpenArchive) close() {
+	if oa.file != nil {
+		oa.file.Close()
+	}
+}
+
+//
+// The entries of an archive, read by hold_archives(). An archive not held is read, and kept until released.
+//
+func open_archive(name string) (Archive, error) {
+
+	archive_lock.Lock()
+	oa, ok := archive_cache[name]
+	archive_lock.Unlock()
+	if ok {
+		return oa.entries, nil
+	}
+
+	oa, err := load_archive(name)
+	if err != nil {
+		return nil, err
+	}
+	archive_lock.Lock()
+	defer archive_lock.Unlock()
+	if old, ok := archive_cache[name]; ok {
+		oa.close()
+		return old.entries, nil
+	}
+	archive_cache[name] = oa
+	return oa.entries, nil
+}
+
+//
+// Read and index all the entries of an archive. Zip archives on the disk are not read in memory, the files
+// are uncompressed when read. The files of tar archives are kept in memory, up to ARCHIVE_MAX_SIZE.
+//
+func load_archive(name string) (*OpenArchive, error) {
+
+	var r io.ReaderAt
+	var size int64
+	oa := &OpenArchive{entries: Archive{"": &ArchiveEntry{name: path.Base(name), mode: os.ModeDir | 0755}}}
+
+	if _, _, ok := split_archive_path(name); ok {
+		data, err := read_archive_file(name)
+		if err != nil {
+			return nil, err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		finfo, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		oa.file, r, size = f, f, finfo.Size()
+	}
+
+	var err error
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		err = oa.entries.read_zip(r, size)
+	} else {
+		err = oa.entries.read_tar(io.NewSectionReader(r, 0, size), !strings.HasSuffix(strings.ToLower(name), ".tar"))
+		// all the files are in memory
+		oa.close()
+		oa.file = nil
+	}
+	if err != nil {
+		oa.close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return oa, nil
+}
+
+func (a Archive) read_zip(r io.ReaderAt, size int64) error {
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			a.add(zf.Name, &ArchiveEntry{mode: os.ModeDir | 0755, modtime: zf.Modified})
+		} else {
+			a.add(zf.Name, &ArchiveEntry{mode: zf.Mode(), modtime: zf.Modified, size: int64(zf.UncompressedSize64), zfile: zf})
+		}
+	}
+	return nil
+}
+
+func (a Archive) read_tar(r io.Reader, compressed bool) error {
+
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var total int64
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			a.add(hdr.Name, &ArchiveEntry{mode: os.ModeDir | 0755, modtime: hdr.ModTime})
+		case tar.TypeReg, tar.TypeRegA:
+			e := &ArchiveEntry{mode: os.FileMode(hdr.Mode).Perm(), modtime: hdr.ModTime, size: hdr.Size}
+			// too big files are skipped, and reported when read
+			if hdr.Size < ARCHIVE_MAX_ENTRY_SIZE && total+hdr.Size <= ARCHIVE_MAX_SIZE {
+				if e.data, err = ioutil.ReadAll(tr); err != nil {
+					return err
+				}
+				total += hdr.Size
+			}
+			a.add(hdr.Name, e)
+		}
+	}
+}
+
+//
+// Add a file or directory to the archive, and the parent directories if not already there.
+//
+func (a Archive) add(name string, e *ArchiveEntry) {
+
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return
+	}
+	if old, ok := a[name]; ok {
+		// directory already added as a parent
+		if old.IsDir() && e.IsDir() {
+			old.modtime = e.modtime
+		}
+		return
+	}
+
+	e.name = path.Base(name)
+	a[name] = e
+
+	parent := path.Dir(name)
+	if parent == "." {
+		parent = ""
+	}
+	if _, ok := a[parent]; !ok {
+		a.add(parent, &ArchiveEntry{mode: os.ModeDir | 0755, modtime: e.modtime})
+	}
+	a[parent].entries = append(a[parent].entries, e)
+}
+
+//
+// Find a file or directory in an archive, name as returned by split_archive_path()
+//
+func find_archive_entry(name string) (*ArchiveEntry, error) {
+
+	archive, entry, ok := split_archive_path(name)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+	a, err := open_archive(archive)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := a[entry]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return e, nil
+}
+
+// List a directory in an archive
+func read_archive_dir(name string) ([]os.FileInfo, error) {
+
+	e, err := find_archive_entry(name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrInvalid}
+	}
+	return append([]os.FileInfo(nil), e.entries...), nil
+}
+
+// Read a file in an archive
+func read_archive_file(name string) ([]byte, error) {
+
+	e, err := find_archive_entry(name)
+	if err != nil {
+		return nil, err
+	}
+	if e.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrInvalid}
+	}
+	if e.size >= ARCHIVE_MAX_ENTRY_SIZE || (e.zfile == nil && int64(len(e.data)) != e.size) {
+		return nil, &os.PathError{Op: "read", Path: name, Err: errors.New(MSG_FILE_TOO_BIG)}
+	}
+	if e.zfile == nil {
+		return e.data, nil
+	}
+
+	r, err := e.zfile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// the declared size can be wrong
+	data, err := ioutil.ReadAll(io.LimitReader(r, ARCHIVE_MAX_ENTRY_SIZE))
+	if err == nil && len(data) >= ARCHIVE_MAX_ENTRY_SIZE {
+		return nil, &os.PathError{Op: "read", Path: name, Err: errors.New(MSG_FILE_TOO_BIG)}
+	}
+	return data, err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func zip_data(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tgz_data(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestDiffArchives(t *testing.T) {
+
+	defer func(w *bufio.Writer, text bool) { out, flag_output_as_text = w, text }(out, flag_output_as_text)
+
+	inner := zip_data(t, map[string]string{"conf/app.properties": "port=80\n"})
+	dir, err := ioutil.TempDir("", "godiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name1, name2 := filepath.Join(dir, "a.zip"), filepath.Join(dir, "b.tgz")
+	ioutil.WriteFile(name1, zip_data(t, map[string]string{"x/f.txt": "one\ntwo\n", "inner.zip": string(inner)}), 0644)
+	ioutil.WriteFile(name2, tgz_data(t, map[string]string{"x/f.txt": "one\nthree\n", "inner.zip": string(inner)}), 0644)
+
+	var buf bytes.Buffer
+	out = bufio.NewWriter(&buf)
+	flag_output_as_text = true
+	finfo1, _ := os.Stat(name1)
+	finfo2, _ := os.Stat(name2)
+	diff_dirs(name1+ARCHIVE_SEPARATOR, name2+ARCHIVE_SEPARATOR, &ArchiveDirInfo{finfo1}, &ArchiveDirInfo{finfo2})
+	out.Flush()
+
+	got := buf.String()
+	if !strings.Contains(got, "two") || !strings.Contains(got, "three") || strings.Contains(got, "app.properties") {
+		t.Errorf("got %q", got)
+	}
+	// the archives are not kept after the comparison
+	if len(archive_cache) != 0 {
+		t.Errorf("archives still in memory: %v", archive_cache)
+	}
+}
+
+func TestArchiveTooBig(t *testing.T) {
+
+	// a file skipped when reading the archive, over ARCHIVE_MAX_SIZE
+	archive_cache["big.tar"] = &OpenArchive{entries: Archive{"f": {name: "f", size: 10}}, refs: 1}
+	defer release_archives("big.tar!/f")
+
+	if _, err := read_archive_file("big.tar!/f"); err == nil || !strings.Contains(err.Error(), MSG_FILE_TOO_BIG) {
+		t.Errorf("got %v, want %s", err, MSG_FILE_TOO_BIG)
+	}
+}
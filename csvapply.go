@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rsrini7/godiff/utils"
+)
+
+// command line arguments of the csv-apply sub-command
+var (
+	flag_csv_apply_output string
+)
+
+func csv_apply_usage(fs *flag.FlagSet, msg string) {
+	if msg != "" {
+		fmt.Fprintf(os.Stderr, "%s\n", msg)
+	}
+	fmt.Fprint(os.Stderr, "Apply a delta csv file, written by godiff -key, to the csv file it was computed from\n\n")
+	fmt.Fprint(os.Stderr, "usage: godiff csv-apply <options> -key <columns> <base.csv> <delta.csv>\n")
+	fs.PrintDefaults()
+	os.Exit(2)
+}
+
+//
+// The csv-apply sub-command: godiff csv-apply <options> -key <columns> <base.csv> <delta.csv>
+//
+func csv_apply_main(args []string) {
+
+	fs := flag.NewFlagSet("csv-apply", flag.ExitOnError)
+	fs.Usage = func() { csv_apply_usage(fs, "") }
+	fs.StringVar(&flag_p_keys, "key", flag_p_keys, "The Primary Key Columns, as given to compare the files")
+	fs.StringVar(&flag_csv_apply_output, "o", flag_csv_apply_output, "Write the result to this file instead of the standard output")
+	fs.Parse(args)
+
+	args = fs.Args()
+	if len(args) < 2 {
+		csv_apply_usage(fs, "Missing files")
+	}
+	if len(args) > 2 {
+		csv_apply_usage(fs, "Too many files")
+	}
+	if flag_p_keys == "" {
+		csv_apply_usage(fs, "-key is must for csv files - primary key column/s")
+	}
+
+	if err := csv_apply(args[0], args[1], flag_csv_apply_output); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+//
+// Apply a delta to a base csv file, and write the result. Nothing is written if the result
+// does not match the checksum of the delta.
+//
+func csv_apply(base_name, delta_name, output string) error {
+
+	header, rows, _, err := read_csv_file(base_name)
+	if err != nil {
+		return err
+	}
+	delta_header, delta_rows, comma, err := read_csv_file(delta_name)
+	if err != nil {
+		return err
+	}
+
+	delta, err := utils.ParseCsvDelta(append([][]string{delta_header}, delta_rows...))
+	if err != nil {
+		return fmt.Errorf("%s: %s", delta_name, err.Error())
+	}
+	if delta.Rows < 0 {
+		fmt.Fprintf(os.Stderr, "%s: no checksum, the result is not checked\n", delta_name)
+	}
+	result, err := delta.Apply(header, rows, csv_keys())
+	if err != nil {
+		return fmt.Errorf("%s: %s", delta_name, err.Error())
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	outCSV := bufio.NewWriter(w)
+	cw := csv.NewWriter(outCSV)
+	cw.Comma = comma
+	cw.Write(delta.Header)
+	cw.WriteAll(result)
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return outCSV.Flush()
+}
+
+//
+// Read the header and the records of a csv file, uncompressed and converted to utf-8 like the compared files.
+// Also return the delimiter.
+//
+func read_csv_file(fname string) ([]string, [][]string, rune, error) {
+
+	finfo, err := os.Stat(fname)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	file := open_file(fname, finfo)
+	defer file.close_file()
+	if file.errormsg != "" {
+		return nil, nil, 0, errors.New(fname + ": " + file.errormsg)
+	}
+
+	comma := csv_comma(file)
+	header, rows, err := read_csv(file, comma)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%s: %s", fname, err.Error())
+	}
+	return header, rows, comma, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/rsrini7/godiff/utils"
+)
+
+// Shown in place of a line break in a field, to show each record on one line
+const CSV_LINE_BREAK = "\u21b5"
+
+// Detect the delimiter of a csv file, once decoded by open_file()
+func csv_comma(file *Filedata) rune {
+	r, _ := utf8.DecodeRuneInString(utils.DetectCsvDelimiterReader(bytes.NewReader(file.data)))
+	return r
+}
+
+// The key columns: -key
+func csv_keys() []string {
+	return csv_column_names(flag_p_keys)
+}
+
+// Column names separated by ','
+func csv_column_names(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// The columns compared: -csv-ignore and -csv-only
+func csv_compare_options() *utils.CsvCompareOptions {
+	return &utils.CsvCompareOptions{
+		Ignore: csv_column_names(flag_csv_ignore_columns),
+		Only:   csv_column_names(flag_csv_only_columns),
+	}
+}
+
+//
+// Parse a csv file, once decoded by open_file(). Return the header and the records.
+// Fields may be quoted, with delimiters, line breaks and quotes ("") in them.
+//
+func read_csv(file *Filedata, comma rune) ([]string, [][]string, error) {
+
+	r := csv.NewReader(bytes.NewReader(file.data))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, nil
+	}
+	return records[0], records[1:], nil
+}
+
+// Line breaks in fields, shown as CSV_LINE_BREAK
+var csv_line_breaks = strings.NewReplacer("\r\n", CSV_LINE_BREAK, "\n", CSV_LINE_BREAK, "\r", CSV_LINE_BREAK)
+
+//
+// Quote a field if needed, as done by csv.Writer. The line breaks are shown as CSV_LINE_BREAK.
+//
+func format_csv_field(field string, comma rune) string {
+	if field == "" || !strings.ContainsRune(field, comma) && !strings.ContainsAny(field, "\"\r\n") && field[0] != ' ' && field[0] != '\t' {
+		return field
+	}
+	return "\"" + csv_line_breaks.Replace(strings.Replace(field, "\"", "\"\"", -1)) + "\""
+}
+
+//
+// A record shown as one line, even with line breaks in fields. Also return the position of each field
+// and delimiter in the line, field i is line[pos[2*i]:pos[2*i+1]], see write_html_csv_row().
+//
+func format_csv_record(row []string, comma rune) ([]byte, []int) {
+
+	var line []byte
+	pos := make([]int, 0, 2*len(row))
+	for i, field := range row {
+		if i > 0 {
+			pos = append(pos, len(line))
+			line = append(line, string(comma)...)
+		}
+		pos = append(pos, len(line))
+		line = append(line, format_csv_field(field, comma)...)
+	}
+	return line, append(pos, len(line))
+}
+
+// Html class of the cells of a row, by column: "chg" for the changed cells, "ign" for the ignored columns.
+// r is nil for the header.
+func csv_cell_classes(res *utils.CsvDiff, r *utils.CsvRowDiff) []string {
+	classes := make([]string, len(res.Header))
+	for c := range res.Header {
+		if res.Ignored[c] {
+			classes[c] = "ign"
+		}
+	}
+	if r != nil {
+		for _, cell := range r.Cells {
+			classes[cell.Column] = "chg"
+		}
+	}
+	return classes
+}
+
+// Changes to the columns, and the number of rows that changed
+func csv_messages(res *utils.CsvDiff) []string {
+
+	var msgs []string
+	var added, removed, ignored []string
+	for c, name := range res.Header {
+		switch {
+		case res.Ignored[c]:
+			ignored = append(ignored, name)
+		case res.Columns1[c] < 0:
+			added = append(added, name)
+		case res.Columns2[c] < 0:
+			removed = append(removed, name)
+		}
+	}
+	if len(added) > 0 {
+		msgs = append(msgs, fmt.Sprintf(MSG_CSV_COLS_ADDED, strings.Join(added, ", ")))
+	}
+	if len(removed) > 0 {
+		msgs = append(msgs, fmt.Sprintf(MSG_CSV_COLS_REMOVED, strings.Join(removed, ", ")))
+	}
+	if len(ignored) > 0 {
+		msgs = append(msgs, fmt.Sprintf(MSG_CSV_COLS_IGNORED, strings.Join(ignored, ", ")))
+	}
+	return append(msgs, fmt.Sprintf(MSG_CSV_ROWS, res.Added, res.Removed, res.Modified))
+}
+
+// Notes about the changed cells of a row, ie. `qty: "7" -> "8"`
+func csv_cell_notes(res *utils.CsvDiff, r *utils.CsvRowDiff) []string {
+	var notes []string
+	for _, cell := range r.Cells {
+		notes = append(notes, fmt.Sprintf(MSG_CSV_CELL, res.Header[cell.Column], cell.Old, cell.New))
+	}
+	return notes
+}
+
+//
+// Compare two csv files by key: the rows with the same values in the key columns are compared cell by cell,
+// except the columns ignored with -csv-ignore or -csv-only.
+//
+func diff_csv_file(filename1, filename2 string, finfo1, finfo2 os.FileInfo) {
+
+	file1 := open_file(filename1, finfo1)
+	file2 := open_file(filename2, finfo2)
+
+	defer file1.close_file()
+	defer file2.close_file()
+
+	if file1.errormsg != "" || file2.errormsg != "" {
+		output_diff_message(filename1, filename2, finfo1, finfo2, file1.errormsg, file2.errormsg, true)
+		return
+	}
+
+	comma1, comma2 := csv_comma(file1), csv_comma(file2)
+	header1, rows1, err1 := read_csv(file1, comma1)
+	header2, rows2, err2 := read_csv(file2, comma2)
+	if err1 != nil || err2 != nil {
+		var msg1, msg2 string
+		if err1 != nil {
+			msg1 = err1.Error()
+		}
+		if err2 != nil {
+			msg2 = err2.Error()
+		}
+		output_diff_message(filename1, filename2, finfo1, finfo2, msg1, msg2, true)
+		return
+	}
+
+	res, err := utils.CompareCsv(header1, rows1, header2, rows2, csv_keys(), csv_compare_options())
+	if err != nil {
+		output_diff_message(filename1, filename2, finfo1, finfo2, err.Error(), err.Error(), true)
+		return
+	}
+
+	// also for identical files, the delta has the checksum of the result for csv-apply
+	if flag_csv_delta != "" {
+		if err := write_csv_delta(csv_delta_name(filename2), res, comma2); err != nil {
+			// reported with the files, the comparison goes on
+			output_diff_message(filename1, filename2, finfo1, finfo2, "", fmt.Sprintf(MSG_CSV_DELTA_ERROR, err.Error()), true)
+		}
+	}
+
+	if res.Equal() && comma1 == comma2 {
+		if flag_show_identical_files {
+			output_diff_message(filename1, filename2, finfo1, finfo2, MSG_FILE_IDENTICAL, MSG_FILE_IDENTICAL, false)
+		}
+		return
+	}
+
+	chg_data := DiffChangerData{
+		OutputFormat: &OutputFormat{
+			name1:        filename1,
+			name2:        filename2,
+			fileinfo1:    finfo1,
+			fileinfo2:    finfo2,
+			lineno_width: len(fmt.Sprintf("%d", utils.MaxInt(len(rows1), len(rows2))+1)),
+		},
+		seq:   int(atomic.AddInt32(&file_seq, 1)),
+		comma: comma2,
+	}
+	chg_data.label1, chg_data.label2 = file_labels(file1, file2)
+
+	chg := new_diff_changer(chg_data)
+	if comma1 != comma2 {
+		chg.diff_message(fmt.Sprintf(MSG_CSV_DELIMITER, comma1, comma2))
+	}
+	for _, msg := range csv_messages(res) {
+		chg.diff_message(msg)
+	}
+	chg.diff_rows(res)
+
+	if chg_data.header_printed {
+		if !flag_output_as_text {
+			out.WriteString("</table><br>\n")
+		}
+		chg_data.header_printed = false
+		out_release_lock()
+	}
+}
+
+//
+// Name of the delta csv file: -csv, or when comparing directories, the path of the file in a folder named after -csv,
+// ie. "output-diff/delta/sub/x.csv".
+//
+func csv_delta_name(filename2 string) string {
+	if csvDeltaRoot == "" {
+		return flag_csv_delta
+	}
+	_, name := find_decoders(strings.TrimPrefix(filename2, csvDeltaRoot))
+	return path.Join(strings.TrimSuffix(flag_csv_delta, path.Ext(flag_csv_delta)), filepath.ToSlash(name))
+}
+
+//
+// Write the delta csv file, once for each pair of files: the header and a record for each row that changed
+//
+func write_csv_delta(fname string, res *utils.CsvDiff, comma rune) error {
+
+	if err := os.MkdirAll(path.Dir(fname), 0755); err != nil {
+		return err
+	}
+
+	output_csv_file, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer output_csv_file.Close()
+
+	outCSV := bufio.NewWriter(output_csv_file)
+	w := csv.NewWriter(outCSV)
+	w.Comma = comma
+
+	opts := &utils.CsvDeltaOptions{OldValues: flag_csv_old_values, ChangedColumns: flag_csv_changed_columns}
+	if err := utils.WriteCsvDelta(w, res, opts); err != nil {
+		return err
+	}
+	if err := outCSV.Flush(); err != nil {
+		return err
+	}
+	return output_csv_file.Close()
+}
+
+//
+// The lines of a file shown when the other file does not exist. A csv file is shown record by record.
+//
+func (file *Filedata) preview_lines() [][]byte {
+
+	if file.data == nil || !is_csv_file(file.name) {
+		return file.split_lines()
+	}
+
+	comma := csv_comma(file)
+	header, rows, err := read_csv(file, comma)
+	if err != nil {
+		return file.split_lines()
+	}
+
+	lines := make([][]byte, 0, NUM_PREVIEW_LINES)
+	for _, row := range append([][]string{header}, rows...) {
+		if len(lines) == NUM_PREVIEW_LINES {
+			break
+		}
+		line, _ := format_csv_record(row, comma)
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffCsvColumnOrder(t *testing.T) {
+
+	defer func(keys, delta string) { flag_p_keys, flag_csv_delta = keys, delta }(flag_p_keys, flag_csv_delta)
+	flag_p_keys, flag_csv_delta = "id", ""
+
+	// the columns of file2 in another order, and one more
+	dir, names := write_test_files(t, ".csv", []byte("id,a,b\n1,x,y\n2,p,q\n"), []byte("id,b,a,c\n1,y,X,z\n3,r,s,t\n"))
+	defer os.RemoveAll(dir)
+
+	got := diff_file_text(t, names[0], names[1])
+	for _, want := range []string{"\\ Rows of both files in the columns: id,a,b,c\n", "< 1,x,y,\n---\n> 1,X,y,z\n", "> 3,s,r,t\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDiffCsvIdenticalDelta(t *testing.T) {
+
+	defer func(keys, delta string) { flag_p_keys, flag_csv_delta = keys, delta }(flag_p_keys, flag_csv_delta)
+
+	// the same rows, in another order
+	base := "id;name\n1;gear\n2;\"nut; bolt\"\n"
+	dir, names := write_test_files(t, ".csv", []byte(base), []byte("id;name\n2;\"nut; bolt\"\n1;gear\n"))
+	defer os.RemoveAll(dir)
+	flag_p_keys, flag_csv_delta = "id", filepath.Join(dir, "delta.csv")
+
+	if got := diff_file_text(t, names[0], names[1]); got != "" {
+		t.Errorf("got %q, want no differences", got)
+	}
+
+	// the delta of identical files: the header and the checksum only
+	delta, err := ioutil.ReadFile(flag_csv_delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSuffix(string(delta), "\n"), "\n"); len(lines) != 2 {
+		t.Errorf("delta %q, want 2 lines", delta)
+	}
+
+	output := filepath.Join(dir, "result.csv")
+	if err := csv_apply(names[0], flag_csv_delta, output); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(output); string(got) != base {
+		t.Errorf("csv-apply: got %q, want %q", got, base)
+	}
+}
+
+func TestDiffCsvDeltaError(t *testing.T) {
+
+	defer func(keys, delta string) { flag_p_keys, flag_csv_delta = keys, delta }(flag_p_keys, flag_csv_delta)
+
+	dir, names := write_test_files(t, ".csv", []byte("id,name\n1,gear\n"), []byte("id,name\n1,nut\n"))
+	defer os.RemoveAll(dir)
+	// in a directory that cannot be created, under a file
+	flag_p_keys, flag_csv_delta = "id", filepath.Join(names[0], "delta.csv")
+
+	got := diff_file_text(t, names[0], names[1])
+	for _, want := range []string{"Delta csv file not written: ", "> 1,nut\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
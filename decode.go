@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/rsrini7/godiff/utils"
+)
+
+// Stop after this many decoders, ie. "x.gz.gz.gz"
+const MAX_DECODERS = 4
+
+//
+// Convert the content of a file before comparing it: uncompress, or run a textconv command.
+//
+type Decoder struct {
+	name   string // shown in the file header, ie. "gzip", "jq ."
+	glob   string // for file names matching this pattern, see match_glob()
+	suffix string // removed from the file name after decoding, to find the next decoder: "x.json.gz" is uncompressed, then converted as "x.json"
+	decode func(data []byte) ([]byte, error)
+}
+
+// Decoders for the built-in codecs, the textconv commands are added first: -textconv
+var decoders = []*Decoder{
+	{"gzip", "*.gz", ".gz", func(data []byte) ([]byte, error) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}},
+	{"bzip2", "*.bz2", ".bz2", func(data []byte) ([]byte, error) {
+		return ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(data)))
+	}},
+	{"zlib", "*.zz", ".zz", decode_zlib},
+	{"zlib", "*.zlib", ".zlib", decode_zlib},
+	{"lzw", "*.Z", ".Z", utils.DecodeLZW},
+}
+
+func decode_zlib(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+//
+// A decoder running an external command, ie. "pdftotext - -" or "jq '.items | sort'".
+// The file content is written to the standard input of the command, the output is compared.
+//
+func textconv_decoder(glob, command string) (*Decoder, error) {
+	args, err := split_command(command)
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{name: command, glob: glob, decode: func(data []byte) ([]byte, error) {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("%v: %s", err, msg)
+			}
+			return nil, err
+		}
+		return out, nil
+	}}, nil
+}
+
+//
+// Split a command into arguments like the shell does, without expanding anything:
+// single quotes keep the text as it is, double quotes and backslashes escape the next character.
+//
+func split_command(command string) ([]string, error) {
+
+	var args []string
+	var arg []rune
+	in_arg := false
+	quote := rune(0)
+	escaped := false
+
+	for _, c := range command {
+		switch {
+		case escaped:
+			// in double quotes, the backslash is kept unless it escapes a special character
+			if quote == '"' && !strings.ContainsRune("\\\"$`", c) {
+				arg = append(arg, '\\')
+			}
+			arg, escaped = append(arg, c), false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				arg = append(arg, c)
+			}
+		case c == '\\':
+			escaped, in_arg = true, true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				arg = append(arg, c)
+			}
+		case c == '\'' || c == '"':
+			quote, in_arg = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if in_arg {
+				args, arg, in_arg = append(args, string(arg)), nil, false
+			}
+		default:
+			arg, in_arg = append(arg, c), true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", command)
+	}
+	if in_arg {
+		args = append(args, string(arg))
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+// Find the decoder for a file, nil if the file is compared as it is
+func find_decoder(name string) *Decoder {
+	for _, d := range decoders {
+		if match_glob(d.glob, name) {
+			return d
+		}
+	}
+	return nil
+}
+
+//
+// The decoders for a file, in the order they are applied, and the name of the decoded file:
+// the suffixes of the compressed formats are removed, ie. "x.csv" for "x.csv.gz".
+//
+func find_decoders(name string) ([]*Decoder, string) {
+
+	var chain []*Decoder
+	for len(chain) < MAX_DECODERS {
+		d := find_decoder(name)
+		if d == nil {
+			break
+		}
+		chain = append(chain, d)
+		if d.suffix == "" || !strings.HasSuffix(name, d.suffix) {
+			break
+		}
+		name = strings.TrimSuffix(name, d.suffix)
+	}
+	return chain, name
+}
+
+// Names of the decoders for a file, shown in the file header
+func decoder_names(name string) []string {
+	var names []string
+	chain, _ := find_decoders(name)
+	for _, d := range chain {
+		names = append(names, d.name)
+	}
+	return names
+}
+
+//
+// Apply the decoders for a file name, in turn. Return the decoded data, and the names of the decoders.
+//
+func decode_file(name string, data []byte) ([]byte, []string, error) {
+
+	chain, _ := find_decoders(name)
+	var names []string
+	for _, d := range chain {
+		var err error
+		if data, err = d.decode(data); err != nil {
+			return nil, names, fmt.Errorf("%s: %s", d.name, err.Error())
+		}
+		names = append(names, d.name)
+	}
+	return data, names, nil
+}
+
+// Report if a file is compared as csv, also when compressed
+func is_csv_file(name string) bool {
+	_, name = find_decoders(name)
+	return strings.HasSuffix(name, ".csv")
+}
+
+//
+// Decode the file content, and remember the decoders used
+//
+func (file *Filedata) decode() {
+
+	if file.data == nil {
+		return
+	}
+
+	data, names, err := decode_file(file.name, file.data)
+	if err != nil {
+		file.errormsg = err.Error()
+		return
+	}
+	if len(names) > 0 {
+		file.replace_data(data)
+		file.conversions = names
+	}
+}
+
+//
+// Shown after the file names in the header: the decoders, and the encodings if not both plain utf-8
+//
+func file_labels(file1, file2 *Filedata) (string, string) {
+	label := func(file *Filedata) string {
+		parts := file.conversions
+		if file1.encoding != ENCODING_UTF8 || file2.encoding != ENCODING_UTF8 {
+			parts = append(parts[:len(parts):len(parts)], file.encoding)
+		}
+		return strings.Join(parts, ", ")
+	}
+	return label(file1), label(file2)
+}
+
+// The label after the file name in a text diff header, separated by a tab like the time stamp of diff -u
+func text_file_label(label string) string {
+	if label == "" {
+		return ""
+	}
+	return "\t" + label
+}
+
+// The label after the file name in a html diff header
+func write_html_file_label(label string) {
+	if label != "" {
+		fmt.Fprintf(out, " <span class=\"inf\">%s</span>", html.EscapeString(label))
+	}
+}
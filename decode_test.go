@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"jq .", []string{"jq", "."}},
+		{"  pdftotext   - -  ", []string{"pdftotext", "-", "-"}},
+		{"jq '.items | sort_by(.id)'", []string{"jq", ".items | sort_by(.id)"}},
+		{`sed -e "s/a b/c/" -e 's/"//g'`, []string{"sed", "-e", "s/a b/c/", "-e", `s/"//g`}},
+		{`printf "%s\n" a\ b ''`, []string{"printf", `%s\n`, "a b", ""}},
+		{`echo "say \"hi\" \$HOME"`, []string{"echo", `say "hi" $HOME`}},
+		{`x"y z"'w'`, []string{"xy zw"}},
+	}
+	for _, test := range tests {
+		got, err := split_command(test.command)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q %v, want %q", test.command, got, err, test.want)
+		}
+	}
+
+	for _, command := range []string{"", "   ", "jq '.", `echo "a`, `echo a\`} {
+		if got, err := split_command(command); err == nil {
+			t.Errorf("%q: got %q, want an error", command, got)
+		}
+	}
+}
+
+func TestTextconvDecoder(t *testing.T) {
+
+	d, err := textconv_decoder("*.txt", `tr "a b" 'x_y'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.decode([]byte("a b c\n"))
+	if err != nil {
+		t.Skip("tr is not available: ", err)
+	}
+	if string(got) != "x_y_c\n" {
+		t.Errorf("got %q, want %q", got, "x_y_c\n")
+	}
+}
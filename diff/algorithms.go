@@ -0,0 +1,391 @@
+package diff
+
+import (
+	"sort"
+
+	"github.com/rsrini7/godiff/utils"
+)
+
+// Working storage for the diff algorithms
+type diff_context struct {
+	v             []int // furthest reaching paths for algorithm_sms()
+	too_expensive int   // give up searching for the middle snake after this many steps, 0 for no limit
+}
+
+//
+// An O(ND) Difference Algorithm: Find middle snake
+//
+func algorithm_sms(data1, data2 []int, ctx *diff_context) (int, int, int, int) {
+
+	v := ctx.v
+	end1, end2 := len(data1), len(data2)
+	max := end1 + end2 + 1
+	up_k := end1 - end2
+	odd := (up_k & 1) != 0
+	down_off, up_off := max, max-up_k+max+max+2
+
+	v[down_off+1] = 0
+	v[down_off] = 0
+	v[up_off+up_k-1] = end1
+	v[up_off+up_k] = end1
+
+	var k, x, u, z int
+
+	for d := 1; true; d++ {
+		up_k_plus_d := up_k + d
+		up_k_minus_d := up_k - d
+		for k = -d; k <= d; k += 2 {
+			x = v[down_off+k+1]
+			if k > -d && (k == d || z >= x) {
+				x, z = z+1, x
+			} else {
+				z = x
+			}
+			for u = x; x < end1 && x-k < end2 && data1[x] == data2[x-k]; x++ {
+			}
+			if odd && (up_k_minus_d < k) && (k < up_k_plus_d) && v[up_off+k] <= x {
+				return u, u - k, x, x - k
+			}
+			v[down_off+k] = x
+		}
+		z = v[up_off+up_k_minus_d-1]
+		for k = up_k_minus_d; k <= up_k_plus_d; k += 2 {
+			x = z
+			if k < up_k_plus_d {
+				z = v[up_off+k+1]
+				if k == up_k_minus_d || z <= x {
+					x = z - 1
+				}
+			}
+			for u = x; x > 0 && x > k && data1[x-1] == data2[x-k-1]; x-- {
+			}
+			if !odd && (-d <= k) && (k <= d) && x <= v[down_off+k] {
+				return x, x - k, u, u - k
+			}
+			v[up_off+k] = x
+		}
+
+		// Too expensive, settle for a split point that is good enough.
+		if ctx.too_expensive > 0 && d >= ctx.too_expensive {
+			if x, y, ok := find_expensive_split(v, d, down_off, up_off, up_k, end1, end2); ok {
+				return x, y, x, y
+			}
+		}
+	}
+	return 0, 0, 0, 0 // should not reach here
+}
+
+//
+// Heuristic for algorithm_sms(), same as GNU diff.
+// Use the forward path that has gone furthest, or the backward path that has gone furthest,
+// whichever is better. Return false if neither can be used to split the problem.
+//
+func find_expensive_split(v []int, d, down_off, up_off, up_k, end1, end2 int) (int, int, bool) {
+
+	// forward diagonal that maximize x+y
+	fxy, fx := -1, 0
+	for k := -d; k <= d; k += 2 {
+		x := utils.MinInt(v[down_off+k], end1)
+		y := x - k
+		if y > end2 {
+			x, y = end2+k, end2
+		}
+		if y >= 0 && fxy < x+y {
+			fxy, fx = x+y, x
+		}
+	}
+
+	// backward diagonal that minimize x+y
+	bxy, bx := end1+end2+1, 0
+	for k := up_k - d; k <= up_k+d; k += 2 {
+		x := utils.MaxInt(v[up_off+k], 0)
+		y := x - k
+		if y < 0 {
+			x, y = k, 0
+		}
+		if x <= end1 && y <= end2 && x+y < bxy {
+			bxy, bx = x+y, x
+		}
+	}
+
+	// use the one that has covered more lines
+	x, xy := bx, bxy
+	if end1+end2-bxy < fxy {
+		x, xy = fx, fxy
+	}
+
+	// the split point must leave some lines on both sides
+	if xy <= 0 || xy >= end1+end2 {
+		return 0, 0, false
+	}
+	return x, xy - x, true
+}
+
+//
+// Special case for algorithm_sms() with only 1 item.
+//
+func find_one_sms(value int, list []int) (int, int) {
+	for i, v := range list {
+		if v == value {
+			return 0, i
+		}
+	}
+	return 1, 0
+}
+
+//
+// An O(ND) Difference Algorithm: Find LCS
+//
+func algorithm_lcs(data1, data2 []int, change1, change2 []bool, ctx *diff_context) {
+
+	start1, start2 := 0, 0
+	end1, end2 := len(data1), len(data2)
+
+	// matches found at start and end of list
+	for start1 < end1 && start2 < end2 && data1[start1] == data2[start2] {
+		start1++
+		start2++
+	}
+	for start1 < end1 && start2 < end2 && data1[end1-1] == data2[end2-1] {
+		end1--
+		end2--
+	}
+
+	len1, len2 := end1-start1, end2-start2
+
+	switch {
+	case len1 == 0:
+		for start2 < end2 {
+			change2[start2] = true
+			start2++
+		}
+
+	case len2 == 0:
+		for start1 < end1 {
+			change1[start1] = true
+			start1++
+		}
+
+	case len1 == 1 && len2 == 1:
+		change1[start1] = true
+		change2[start2] = true
+
+	default:
+		data1, change1 = data1[start1:end1], change1[start1:end1]
+		data2, change2 = data2[start2:end2], change2[start2:end2]
+
+		var x0, y0, x1, y1 int
+
+		if len(data1) == 1 {
+			// match one item, use simple search function
+			x0, y0 = find_one_sms(data1[0], data2)
+			x1, y1 = x0, y0
+		} else if len(data2) == 1 {
+			// match one item, use simple search function
+			y0, x0 = find_one_sms(data2[0], data1)
+			x1, y1 = x0, y0
+		} else {
+			// Find a point with the longest common sequence
+			x0, y0, x1, y1 = algorithm_sms(data1, data2, ctx)
+		}
+
+		// Use the partitions to split this problem into subproblems.
+		algorithm_lcs(data1[:x0], data2[:y0], change1[:x0], change2[:y0], ctx)
+		algorithm_lcs(data1[x1:], data2[y1:], change1[x1:], change2[y1:], ctx)
+	}
+}
+
+// a line that appears exactly once in both lists
+type patience_anchor struct {
+	pos1, pos2 int
+}
+
+//
+// Find the lines that are unique in both lists, and return the longest
+// sequence of them that appears in the same order in both lists.
+//
+func patience_anchors(data1, data2 []int) []patience_anchor {
+
+	// count occurrences of each id, remember the position of the last one.
+	type occurrence struct {
+		count1, count2 int
+		pos1, pos2     int
+	}
+	occur := make(map[int]*occurrence, len(data1))
+	for i, id := range data1 {
+		o := occur[id]
+		if o == nil {
+			o = &occurrence{}
+			occur[id] = o
+		}
+		o.count1++
+		o.pos1 = i
+	}
+	for i, id := range data2 {
+		if o := occur[id]; o != nil {
+			o.count2++
+			o.pos2 = i
+		}
+	}
+
+	// unique lines, in the order they appear in data1
+	uniq := make([]patience_anchor, 0, 16)
+	for i, id := range data1 {
+		if o := occur[id]; o.count1 == 1 && o.count2 == 1 {
+			uniq = append(uniq, patience_anchor{i, o.pos2})
+		}
+	}
+	if len(uniq) == 0 {
+		return nil
+	}
+
+	// patience sorting on pos2, to find the longest increasing subsequence.
+	// tops[] is the index of the top card of each pile, prev[] links each card to the pile on its left.
+	tops := make([]int, 0, len(uniq))
+	prev := make([]int, len(uniq))
+	for i, a := range uniq {
+		pile := sort.Search(len(tops), func(n int) bool { return uniq[tops[n]].pos2 > a.pos2 })
+		if pile > 0 {
+			prev[i] = tops[pile-1]
+		} else {
+			prev[i] = -1
+		}
+		if pile == len(tops) {
+			tops = append(tops, i)
+		} else {
+			tops[pile] = i
+		}
+	}
+
+	// walk back from the top card of the last pile
+	anchors := make([]patience_anchor, len(tops))
+	for i, n := len(tops)-1, tops[len(tops)-1]; i >= 0; i, n = i-1, prev[n] {
+		anchors[i] = uniq[n]
+	}
+	return anchors
+}
+
+//
+// Patience Diff: Use lines that are unique in both lists as anchors,
+// and use the O(ND) algorithm on the lines between the anchors.
+//
+func algorithm_patience(data1, data2 []int, change1, change2 []bool, ctx *diff_context) {
+
+	start1, start2 := 0, 0
+	end1, end2 := len(data1), len(data2)
+
+	// matches found at start and end of list
+	for start1 < end1 && start2 < end2 && data1[start1] == data2[start2] {
+		start1++
+		start2++
+	}
+	for start1 < end1 && start2 < end2 && data1[end1-1] == data2[end2-1] {
+		end1--
+		end2--
+	}
+
+	data1, change1 = data1[start1:end1], change1[start1:end1]
+	data2, change2 = data2[start2:end2], change2[start2:end2]
+
+	var anchors []patience_anchor
+	if len(data1) > 0 && len(data2) > 0 {
+		anchors = patience_anchors(data1, data2)
+	}
+
+	// no anchors, fall back to the O(ND) algorithm
+	if len(anchors) == 0 {
+		algorithm_lcs(data1, data2, change1, change2, ctx)
+		return
+	}
+
+	// compare the lines between each anchor
+	p1, p2 := 0, 0
+	for _, a := range anchors {
+		algorithm_patience(data1[p1:a.pos1], data2[p2:a.pos2], change1[p1:a.pos1], change2[p2:a.pos2], ctx)
+		p1, p2 = a.pos1+1, a.pos2+1
+	}
+	algorithm_patience(data1[p1:], data2[p2:], change1[p1:], change2[p2:], ctx)
+}
+
+// Lines that appear more than this number of times are not used as split point by algorithm_histogram()
+const HISTOGRAM_MAX_CHAIN = 64
+
+//
+// Histogram Diff: Find the longest common region that contains the lowest occurring line,
+// use it to split the lists, and repeat on both sides.
+// Fall back to the O(ND) algorithm when there are no suitable lines.
+//
+func algorithm_histogram(data1, data2 []int, change1, change2 []bool, ctx *diff_context) {
+
+	start1, start2 := 0, 0
+	end1, end2 := len(data1), len(data2)
+
+	// matches found at start and end of list
+	for start1 < end1 && start2 < end2 && data1[start1] == data2[start2] {
+		start1++
+		start2++
+	}
+	for start1 < end1 && start2 < end2 && data1[end1-1] == data2[end2-1] {
+		end1--
+		end2--
+	}
+
+	data1, change1 = data1[start1:end1], change1[start1:end1]
+	data2, change2 = data2[start2:end2], change2[start2:end2]
+	len1, len2 := len(data1), len(data2)
+
+	if len1 == 0 || len2 == 0 {
+		algorithm_lcs(data1, data2, change1, change2, ctx)
+		return
+	}
+
+	// histogram of the lines in data1
+	occur := make(map[int][]int, len1)
+	for i, id := range data1 {
+		occur[id] = append(occur[id], i)
+	}
+
+	// find the longest common region, with the lowest number of occurrences
+	best_count := HISTOGRAM_MAX_CHAIN + 1
+	var best_s1, best_e1, best_s2, best_e2 int
+
+	for i2 := 0; i2 < len2; {
+		next := i2 + 1
+		pos := occur[data2[i2]]
+		if len(pos) == 0 || len(pos) > best_count {
+			i2 = next
+			continue
+		}
+
+		for _, i1 := range pos {
+			s1, s2, e1, e2 := i1, i2, i1+1, i2+1
+			count := len(pos)
+			for s1 > 0 && s2 > 0 && data1[s1-1] == data2[s2-1] {
+				s1, s2 = s1-1, s2-1
+				count = utils.MinInt(count, len(occur[data1[s1]]))
+			}
+			for e1 < len1 && e2 < len2 && data1[e1] == data2[e2] {
+				count = utils.MinInt(count, len(occur[data1[e1]]))
+				e1, e2 = e1+1, e2+1
+			}
+			if e2 > next {
+				next = e2
+			}
+			if best_e1-best_s1 < e1-s1 || count < best_count {
+				best_s1, best_e1, best_s2, best_e2 = s1, e1, s2, e2
+				best_count = count
+			}
+		}
+		i2 = next
+	}
+
+	// no suitable common lines, fall back to the O(ND) algorithm
+	if best_count > HISTOGRAM_MAX_CHAIN {
+		algorithm_lcs(data1, data2, change1, change2, ctx)
+		return
+	}
+
+	// Use the common region to split this problem into subproblems.
+	algorithm_histogram(data1[:best_s1], data2[:best_s2], change1[:best_s1], change2[:best_s2], ctx)
+	algorithm_histogram(data1[best_e1:], data2[best_e2:], change1[best_e1:], change2[best_e2:], ctx)
+}
@@ -0,0 +1,773 @@
+package diff
+
+import (
+	"bytes"
+	"hash/crc32"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rsrini7/godiff/utils"
+)
+
+var blank_line = make([]byte, 0)
+
+//
+// Choose which compare and hash function to use, based on the options: -b -w -i etc.
+//
+func (opts *Options) line_funcs() (func([]byte, []byte) bool, func([]byte) uint32) {
+	if opts.IgnoreCase || opts.IgnoreSpaceChange || opts.IgnoreAllSpace || opts.IgnoreTrailingSpace || opts.IgnoreTabExpansion {
+		if opts.Unicode {
+			return opts.norm_line_funcs(opts.compare_line_unicode, opts.compute_hash_unicode)
+		}
+		return opts.norm_line_funcs(opts.compare_line_bytes, opts.compute_hash_bytes)
+	}
+	return opts.norm_line_funcs(bytes.Equal, compute_hash_exact)
+}
+
+// Report if a line matches any of the ignore regexps
+func (opts *Options) ignore_line(line []byte) bool {
+	for _, re := range opts.IgnoreRegexps {
+		if re.Match(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func skip_space_rune(line []byte, i int) int {
+	for i < len(line) {
+		b, size := utf8.DecodeRune(line[i:])
+		if !unicode.IsSpace(b) {
+			return i
+		}
+		i += size
+	}
+	return i
+}
+
+//
+// Get the next rune, and skip spaces after it
+//
+func get_next_rune_nonspace(line []byte, i int) (rune, int) {
+	b, size := utf8.DecodeRune(line[i:])
+	return b, skip_space_rune(line, i+size)
+}
+
+//
+// Get the next rune, and determine if there is a space after it.
+// Also ignore trailing spaces at end-of-line
+//
+func get_next_rune_xspace(line []byte, i int) (rune, bool, int) {
+	b, size := utf8.DecodeRune(line[i:])
+	i += size
+	space_after := false
+	for i < len(line) {
+		s, size := utf8.DecodeRune(line[i:])
+		if !unicode.IsSpace(s) {
+			break
+		}
+		space_after = true
+		i += size
+	}
+	if space_after && i >= len(line) {
+		space_after = false
+	}
+	return b, space_after, i
+}
+
+func skip_space_byte(line []byte, i int) int {
+	for i < len(line) {
+		if !utils.IsSpace(line[i]) {
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+func get_next_byte_nonspace(line []byte, i int) (byte, int) {
+	return line[i], skip_space_byte(line, i+1)
+}
+
+func get_next_byte_xspace(line []byte, i int) (byte, bool, int) {
+	b, i := line[i], i+1
+	space_after := false
+	for i < len(line) {
+		if !utils.IsSpace(line[i]) {
+			break
+		}
+		space_after = true
+		i++
+	}
+	if space_after && i >= len(line) {
+		space_after = false
+	}
+	return b, space_after, i
+}
+
+//
+// Read a line one rune (or byte) at a time, for -ignore-trailing-space and -ignore-tab-expansion.
+// Tabs are returned as spaces up to the next tab stop, and white space at the end of line is dropped.
+//
+type space_reader struct {
+	line      []byte
+	i, end    int  // position in line, and end of line without the trailing white space
+	col       int  // column, counted in runes
+	spaces    int  // number of spaces left from the last tab
+	tab_width int  // 0 to keep the tabs
+	unicode   bool // read runes instead of bytes, see Options.Unicode
+}
+
+func (opts *Options) new_space_reader(line []byte) space_reader {
+	r := space_reader{line: line, end: len(line), unicode: opts.Unicode}
+	if opts.IgnoreTrailingSpace {
+		r.end = opts.trailing_space(line)
+	}
+	if opts.IgnoreTabExpansion {
+		r.tab_width = opts.tab_width()
+	}
+	return r
+}
+
+// Tab width used for IgnoreTabExpansion
+func (opts *Options) tab_width() int {
+	if opts.TabWidth > 0 {
+		return opts.TabWidth
+	}
+	return TAB_WIDTH
+}
+
+// Position of the white space at the end of line
+func (opts *Options) trailing_space(line []byte) int {
+	if opts.Unicode {
+		return len(bytes.TrimRightFunc(line, unicode.IsSpace))
+	}
+	end := len(line)
+	for end > 0 && utils.IsSpace(line[end-1]) {
+		end--
+	}
+	return end
+}
+
+// Get the next rune, or -1 at end of line
+func (r *space_reader) next() rune {
+	if r.spaces > 0 {
+		r.spaces--
+		r.col++
+		return ' '
+	}
+	if r.i >= r.end {
+		return -1
+	}
+
+	var v rune
+	if r.unicode {
+		var size int
+		v, size = utf8.DecodeRune(r.line[r.i:])
+		r.i += size
+	} else {
+		v = rune(r.line[r.i])
+		r.i++
+		if !utf8.RuneStart(byte(v)) {
+			// same column as the start of this utf8 sequence
+			return v
+		}
+	}
+
+	if v == '\t' && r.tab_width > 0 {
+		r.spaces = r.tab_width - r.col%r.tab_width - 1
+		v = ' '
+	}
+	r.col++
+	return v
+}
+
+func (opts *Options) compare_line_bytes(line1, line2 []byte) bool {
+	len1, len2 := len(line1), len(line2)
+	var i, j int
+	var v1, v2 byte
+	switch {
+	case opts.IgnoreAllSpace:
+		i = skip_space_byte(line1, 0)
+		j = skip_space_byte(line2, 0)
+		for i < len1 && j < len2 {
+			v1, i = get_next_byte_nonspace(line1, i)
+			v2, j = get_next_byte_nonspace(line2, j)
+			if opts.IgnoreCase && v1 != v2 {
+				v1, v2 = utils.ToLowerByte(v1), utils.ToLowerByte(v2)
+			}
+			if v1 != v2 {
+				return false
+			}
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+
+	case opts.IgnoreSpaceChange:
+		var space_after1, space_after2 bool
+		i = skip_space_byte(line1, 0)
+		j = skip_space_byte(line2, 0)
+		for i < len1 && j < len2 {
+			v1, space_after1, i = get_next_byte_xspace(line1, i)
+			v2, space_after2, j = get_next_byte_xspace(line2, j)
+			if opts.IgnoreCase && v1 != v2 {
+				v1, v2 = utils.ToLowerByte(v1), utils.ToLowerByte(v2)
+			}
+			if v1 != v2 || space_after1 != space_after2 {
+				return false
+			}
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+
+	case opts.IgnoreTrailingSpace || opts.IgnoreTabExpansion:
+		r1, r2 := opts.new_space_reader(line1), opts.new_space_reader(line2)
+		for {
+			v1, v2 := r1.next(), r2.next()
+			if opts.IgnoreCase && v1 != v2 && v1 >= 0 && v2 >= 0 {
+				v1, v2 = rune(utils.ToLowerByte(byte(v1))), rune(utils.ToLowerByte(byte(v2)))
+			}
+			if v1 != v2 {
+				return false
+			}
+			if v1 < 0 {
+				break
+			}
+		}
+
+	case opts.IgnoreCase:
+		if len1 != len2 {
+			return false
+		}
+		for i < len1 && j < len2 {
+			if utils.ToLowerByte(line1[i]) != utils.ToLowerByte(line2[j]) {
+				return false
+			}
+			i, j = i+1, j+1
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+	}
+	return true
+}
+
+func (opts *Options) compare_line_unicode(line1, line2 []byte) bool {
+	len1, len2 := len(line1), len(line2)
+	var i, j int
+	var v1, v2 rune
+	var size1, size2 int
+	switch {
+	case opts.IgnoreAllSpace:
+		i = skip_space_rune(line1, 0)
+		j = skip_space_rune(line2, 0)
+		for i < len1 && j < len2 {
+			v1, i = get_next_rune_nonspace(line1, i)
+			v2, j = get_next_rune_nonspace(line2, j)
+			if opts.IgnoreCase && v1 != v2 {
+				v1, v2 = unicode.ToLower(v1), unicode.ToLower(v2)
+			}
+			if v1 != v2 {
+				return false
+			}
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+
+	case opts.IgnoreSpaceChange:
+		i = skip_space_rune(line1, 0)
+		j = skip_space_rune(line2, 0)
+		var space_after1, space_after2 bool
+		for i < len1 && j < len2 {
+			v1, space_after1, i = get_next_rune_xspace(line1, i)
+			v2, space_after2, j = get_next_rune_xspace(line2, j)
+			if opts.IgnoreCase && v1 != v2 {
+				v1, v2 = unicode.ToLower(v1), unicode.ToLower(v2)
+			}
+			if v1 != v2 || space_after1 != space_after2 {
+				return false
+			}
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+
+	case opts.IgnoreTrailingSpace || opts.IgnoreTabExpansion:
+		r1, r2 := opts.new_space_reader(line1), opts.new_space_reader(line2)
+		for {
+			v1, v2 := r1.next(), r2.next()
+			if opts.IgnoreCase && v1 != v2 {
+				v1, v2 = unicode.ToLower(v1), unicode.ToLower(v2)
+			}
+			if v1 != v2 {
+				return false
+			}
+			if v1 < 0 {
+				break
+			}
+		}
+
+	case opts.IgnoreCase:
+		if len1 != len2 {
+			return false
+		}
+		for i < len1 && j < len2 {
+			v1, size1 = utf8.DecodeRune(line1[i:])
+			v2, size2 = utf8.DecodeRune(line2[j:])
+			if v1 != v2 && unicode.ToLower(v1) != unicode.ToLower(v2) {
+				return false
+			}
+			i, j = i+size1, j+size2
+		}
+		if i < len1 || j < len2 {
+			return false
+		}
+	}
+	return true
+}
+
+var crc_table = crc32.MakeTable(crc32.Castagnoli)
+
+func hash32(h uint32, b byte) uint32 {
+	return crc_table[byte(h)^b] ^ (h >> 8)
+}
+
+func hash32_unicode(h uint32, r rune) uint32 {
+	for r != 0 {
+		h = hash32(h, byte(r))
+		r = r >> 8
+	}
+	return h
+}
+
+func compute_hash_exact(data []byte) uint32 {
+	// On amd64, this will be using the SSE4.2 hardware instructions, much faster!
+	return crc32.Update(0, crc_table, data)
+}
+
+func (opts *Options) compute_hash_bytes(line1 []byte) uint32 {
+	var hash uint32
+	switch {
+	case opts.IgnoreAllSpace:
+		for _, v1 := range line1 {
+			if !utils.IsSpace(v1) {
+				if opts.IgnoreCase {
+					v1 = utils.ToLowerByte(v1)
+				}
+				hash = hash32(hash, v1)
+			}
+		}
+
+	case opts.IgnoreSpaceChange:
+		last_hash := hash
+		last_space := true
+		for _, v1 := range line1 {
+			if utils.IsSpace(v1) {
+				if !last_space {
+					last_hash = hash
+					hash = hash32(hash, ' ')
+				}
+				last_space = true
+			} else {
+				if opts.IgnoreCase {
+					v1 = utils.ToLowerByte(v1)
+				}
+				hash = hash32(hash, v1)
+				last_space = false
+			}
+		}
+		if last_space {
+			hash = last_hash
+		}
+
+	case opts.IgnoreTrailingSpace || opts.IgnoreTabExpansion:
+		r := opts.new_space_reader(line1)
+		for v1 := r.next(); v1 >= 0; v1 = r.next() {
+			b := byte(v1)
+			if opts.IgnoreCase {
+				b = utils.ToLowerByte(b)
+			}
+			hash = hash32(hash, b)
+		}
+
+	case opts.IgnoreCase:
+		for _, v1 := range line1 {
+			v1 = utils.ToLowerByte(v1)
+			hash = hash32(hash, v1)
+		}
+
+	}
+	return hash
+}
+
+func (opts *Options) compute_hash_unicode(line1 []byte) uint32 {
+	var hash uint32
+	i, len1 := 0, len(line1)
+
+	switch {
+	case opts.IgnoreAllSpace:
+		for i < len1 {
+			v1, size := utf8.DecodeRune(line1[i:])
+			i = i + size
+			if !unicode.IsSpace(v1) {
+				if opts.IgnoreCase {
+					v1 = unicode.ToLower(v1)
+				}
+				hash = hash32_unicode(hash, v1)
+			}
+		}
+
+	case opts.IgnoreSpaceChange:
+		last_hash := hash
+		last_space := true
+		for i < len1 {
+			v1, size := utf8.DecodeRune(line1[i:])
+			i += size
+			if unicode.IsSpace(v1) {
+				if !last_space {
+					last_hash = hash
+					hash = hash32(hash, ' ')
+				}
+				last_space = true
+			} else {
+				if opts.IgnoreCase {
+					v1 = unicode.ToLower(v1)
+				}
+				hash = hash32_unicode(hash, v1)
+				last_space = false
+			}
+		}
+		if last_space {
+			hash = last_hash
+		}
+
+	case opts.IgnoreTrailingSpace || opts.IgnoreTabExpansion:
+		r := opts.new_space_reader(line1)
+		for v1 := r.next(); v1 >= 0; v1 = r.next() {
+			if opts.IgnoreCase {
+				v1 = unicode.ToLower(v1)
+			}
+			hash = hash32_unicode(hash, v1)
+		}
+
+	case opts.IgnoreCase:
+		for i < len1 {
+			v1, size := utf8.DecodeRune(line1[i:])
+			i = i + size
+			v1 = unicode.ToLower(v1)
+			hash = hash32_unicode(hash, v1)
+		}
+	}
+	return hash
+}
+
+type EquivClass struct {
+	id   int
+	hash uint32
+	line *[]byte
+	eol  int
+	next *EquivClass
+}
+
+type LinesData struct {
+	ids        []int // Id's for each line,
+	zids       []int // list of ids with unmatched lines replaced by a single entry (and blank lines removed)
+	zcount     []int // Number of lines that represent each zids entry
+	change     []bool
+	zids_start int
+	zids_end   int
+}
+
+//
+// Compute id's that represent the original lines, these numeric id's are use for faster line comparison.
+// If eols1 and eols2 are given, lines with different line endings are different.
+//
+func find_equiv_lines(lines1, lines2 [][]byte, eols1, eols2 []int, opts *Options) (*LinesData, *LinesData) {
+
+	compare_line, compute_hash := opts.line_funcs()
+
+	// compare the normalized lines, ie. with time stamps masked out
+	lines1, lines2 = opts.normalize_lines(lines1), opts.normalize_lines(lines2)
+
+	info1 := LinesData{
+		ids:    make([]int, len(lines1)),
+		change: make([]bool, len(lines1)),
+	}
+
+	info2 := LinesData{
+		ids:    make([]int, len(lines2)),
+		change: make([]bool, len(lines2)),
+	}
+
+	// since we already have a hashing function, it's faster to use arrays than to use go's builtin map
+	// Use bucket size that is power of 2
+	buckets := 1 << 9
+	for buckets < (len(lines1)+len(lines2))*2 {
+		buckets = buckets << 1
+	}
+
+	// create the slice we are using for hash tables
+	eqhash := make([]*EquivClass, buckets)
+
+	// Use id=0 for blank lines, and lines matching the ignore regexps.
+	// Later in report_diff(), do not report changes on chunks of lines with id=0
+	if opts.IgnoreBlankLines {
+		hashcode := compute_hash(blank_line)
+		ihash := int(hashcode) & (buckets - 1)
+		eqhash[ihash] = &EquivClass{id: 0, line: &blank_line, hash: hashcode}
+	}
+
+	// the unique id for identical lines, start with 1.
+	var max_id_f1, max_id_f2 int
+	next_id := 1
+
+	// process both sets of lines
+	for findex := 0; findex < 2; findex++ {
+		var lines [][]byte
+		var ids, eols []int
+
+		if findex == 0 {
+			lines = lines1
+			ids = info1.ids
+			eols = eols1
+		} else {
+			lines = lines2
+			ids = info2.ids
+			eols = eols2
+		}
+
+		for i := 0; i < len(lines); i++ {
+			lptr := &lines[i]
+			if opts.ignore_line(*lptr) {
+				ids[i] = 0
+				continue
+			}
+			// find current line in eqhash
+			hashcode := compute_hash(*lptr)
+			eol := 0
+			if eols != nil {
+				if opts.IgnoreBlankLines && compare_line(*lptr, blank_line) {
+					// blank line, whatever the line ending
+					ids[i] = 0
+					continue
+				}
+				eol = eols[i]
+				hashcode = hash32(hashcode, byte(eol))
+			}
+			ihash := int(hashcode) & (buckets - 1)
+			eq := eqhash[ihash]
+			if eq == nil {
+				// not found in eqhash, create new entry
+				ids[i] = next_id
+				eqhash[ihash] = &EquivClass{id: next_id, line: lptr, eol: eol, hash: hashcode}
+				next_id++
+			} else if eq.hash == hashcode && eq.eol == eol && compare_line(*lptr, *eq.line) {
+				// found, and line is the same. reuse same id
+				ids[i] = eq.id
+			} else {
+				// hash-collision. look through link-list for same match
+				n := eq.next
+				for n != nil {
+					if n.hash == hashcode && n.eol == eol && compare_line(*lptr, *n.line) {
+						ids[i] = n.id
+						break
+					}
+					n = n.next
+				}
+				// new entry, link to start of linked-list
+				if n == nil {
+					ids[i] = next_id
+					eq.next = &EquivClass{id: next_id, line: lptr, eol: eol, hash: hashcode, next: eq.next}
+					next_id++
+				}
+			}
+		}
+
+		if findex == 0 {
+			max_id_f1 = next_id - 1
+		} else {
+			max_id_f2 = next_id - 1
+		}
+	}
+
+	compress_equiv_ids(&info1, &info2, max_id_f1, max_id_f2)
+
+	return &info1, &info2
+}
+
+// Count the occurrances of each unique ids in both sets of lines, we will then know which lines are only present in one file, but not the other.
+// Remove chunks of lines that do not appear in the other files, and replace with a single entry
+// Return compressed lists of ids and a list indicating where are the chunk of lines being replaced
+func compress_equiv_ids(lines1, lines2 *LinesData, max_id1, max_id2 int) {
+
+	len1, len2 := len(lines1.ids), len(lines2.ids)
+	has_ids1, has_ids2 := make([]bool, max_id1+1), make([]bool, max_id2+1)
+
+	// Determine which id's are in the each file
+	for _, v := range lines1.ids {
+		has_ids1[v] = true
+	}
+	for _, v := range lines2.ids {
+		has_ids2[v] = true
+	}
+
+	// exclude lines from the begining that are identical in both files
+	// if line in file1 but not in file2, exclude it and marked as changed
+	// if line in file2 but not in file1, exclude it and marked as changed
+	i1, i2 := 0, 0
+	for i1 < len1 && i2 < len2 {
+		v1, v2 := lines1.ids[i1], lines2.ids[i2]
+		if v1 > max_id2 || !has_ids2[v1] {
+			lines1.change[i1] = true
+			i1++
+		} else if v2 > max_id1 || !has_ids1[v2] {
+			lines2.change[i2] = true
+			i2++
+		} else if v1 == v2 {
+			i1++
+			i2++
+		} else {
+			break
+		}
+	}
+
+	// exclude lines from the end that are identical in both files
+	// if line in file1 but not in file2, exclude it and marked as changed
+	// if line in file2 but not in file1, exclude it and marked as changed
+	j1, j2 := len1, len2
+	for i1 < j1 && i2 < j2 {
+		v1, v2 := lines1.ids[j1-1], lines2.ids[j2-1]
+		if v1 > max_id2 || !has_ids2[v1] {
+			j1--
+			lines1.change[j1] = true
+		} else if v2 > max_id1 || !has_ids1[v2] {
+			j2--
+			lines2.change[j2] = true
+		} else if v1 == v2 {
+			j1--
+			j2--
+		} else {
+			break
+		}
+	}
+
+	// One of the list is now empty, no need to run diff algorithm for comparison.
+	// Just mark the remaining lines other list as changed.
+	if i1 == j1 {
+		for i2 < j2 {
+			lines2.change[i2] = true
+			i2++
+		}
+		return
+	}
+	if i2 == j2 {
+		for i1 < j1 {
+			lines1.change[i1] = true
+			i1++
+		}
+		return
+	}
+
+	// store excluded lines from begining and end of file
+	lines1.zids_start, lines1.zids_end = i1, j1
+	lines2.zids_start, lines2.zids_end = i2, j2
+
+	// Go through all lines, replace chunk of lines that does not exists in the
+	// other set with a single entry and a negative new id).
+	next_id := utils.MaxInt(max_id1, max_id2) + 1
+	for findex := 0; findex < 2; findex++ {
+		var ids []int
+		var has_ids []bool
+		var max_id int
+
+		if findex == 0 {
+			ids = lines1.ids[lines1.zids_start:lines1.zids_end]
+			has_ids = has_ids2
+			max_id = max_id2
+		} else {
+			ids = lines2.ids[lines2.zids_start:lines2.zids_end]
+			has_ids = has_ids1
+			max_id = max_id1
+		}
+
+		// new slices for compressed ids and the number of lines each entry replaced
+		// use a new negative id for those merged lines
+		zcount := make([]int, len(ids))
+		zids := make([]int, len(ids))
+
+		lastexclude := false
+		n := 0
+		for _, v := range ids {
+			exclude := (v > max_id || !has_ids[v])
+			if exclude && lastexclude {
+				zcount[n-1]++
+				zids[n-1] = -next_id
+				next_id++
+			} else if exclude {
+				zcount[n]++
+				zids[n] = -v
+				n++
+			} else {
+				zcount[n]++
+				zids[n] = v
+				n++
+			}
+			lastexclude = exclude
+		}
+
+		// shrink the slice
+		zids = zids[:n]
+		zcount = zcount[:n]
+
+		if findex == 0 {
+			lines1.zids = zids
+			lines1.zcount = zcount
+		} else {
+			lines2.zids = zids
+			lines2.zcount = zcount
+		}
+	}
+}
+
+//
+// Do the reverse of the compress_equiv_ids.
+// zllines1 and zlines2 contains the 'extra' lines each entry represents.
+//
+func expand_change_list(info1, info2 *LinesData, zchange1, zchange2 []bool) {
+
+	for findex := 0; findex < 2; findex++ {
+		var info *LinesData
+		var change, zchange []bool
+
+		// expand the changes into the range between zids_start and zids_end
+		if findex == 0 {
+			info = info1
+			change = info1.change[info1.zids_start:]
+			zchange = zchange1
+		} else {
+			info = info2
+			change = info2.change[info2.zids_start:]
+			zchange = zchange2
+		}
+
+		// no change
+		if zchange == nil {
+			continue
+		}
+
+		// expand each entry by the number of lines in zcount[]
+		n := 0
+		for i, m := range info.zcount {
+			if zchange[i] {
+				for end := n + m; n < end; n++ {
+					change[n] = true
+				}
+			} else {
+				n += m
+			}
+		}
+	}
+}
@@ -0,0 +1,428 @@
+//
+//  File/Directory diff tool with HTML output
+//  Copyright (C) 2012   Siu Pin Chao
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// Package diff is the comparison engine used by godiff.
+//
+// It uses the algorithm from "An O(ND) Difference Algorithm and its Variations"
+// by Eugene Myers Algorithmica Vol. 1 No. 2, 1986, p 251.
+//
+// The package has no dependency on command line flags or output streams,
+// two sets of lines are compared with Compare() and the result is returned
+// as a list of hunks, each hunk is a list of DiffOp.
+package diff
+
+import (
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sync"
+
+	"github.com/rsrini7/godiff/utils"
+)
+
+// default number of context lines to display
+const CONTEXT_LINES = 3
+
+// Kind of change in a DiffOp
+const (
+	DIFF_OP_SAME   = 1
+	DIFF_OP_MODIFY = 2
+	DIFF_OP_INSERT = 3
+	DIFF_OP_REMOVE = 4
+	DIFF_OP_MOVE   = 5 // lines moved from Start1:End1, or moved to Start2:End2
+)
+
+// Diff algorithm used by Compare()
+const (
+	ALGORITHM_MYERS     = 0 // Myers O(ND), produce the minimal differences
+	ALGORITHM_PATIENCE  = 1 // Patience diff, anchor on lines that are unique in both files
+	ALGORITHM_HISTOGRAM = 2 // Histogram diff, split on the lines with the lowest number of occurrences
+)
+
+// A range of lines in file1 (Start1 to End1) and file2 (Start2 to End2).
+// Line numbers start from 0, the End is exclusive.
+type DiffOp struct {
+	Op           int
+	Start1, End1 int
+	Start2, End2 int
+	Move         int // DIFF_OP_MOVE only: id of the moved block, index into Result.Moves plus 1
+}
+
+// A group of changes, together with the context lines before and after.
+type Hunk struct {
+	Ops []DiffOp
+}
+
+// Options to control how lines are compared
+type Options struct {
+	IgnoreCase          bool             // Ignore case differences
+	IgnoreSpaceChange   bool             // Ignore changes in the amount of white space
+	IgnoreAllSpace      bool             // Ignore all white space
+	IgnoreTrailingSpace bool             // Ignore white space at the end of lines
+	IgnoreTabExpansion  bool             // Ignore changes due to tab expansion, ie. a tab and the spaces up to the same tab stop
+	TabWidth            int              // Tab stops every N columns for IgnoreTabExpansion, 0 for TAB_WIDTH
+	IgnoreBlankLines    bool             // Ignore changes whose lines are all blank
+	Unicode             bool             // Apply unicode rules for white space and upper/lower case
+	UnicodeNorm         int              // Compare the lines after unicode normalization, one of the NORM_xxx values
+	ContextLines        int              // Include N lines of context before and after changes
+	Algorithm           int              // One of the ALGORITHM_xxx values
+	Minimal             bool             // Always find the minimal differences, no matter how long it takes
+	CostLimit           int              // Settle for a non-minimal result after this many steps, 0 to compute from the size of input
+	DetectMoves         bool             // Report blocks of lines that have been moved as DIFF_OP_MOVE
+	Tokenizer           int              // How to split lines for changes within a line, one of the TOKENIZE_xxx values
+	Similarity          float64          // Lines in a modified block are paired up only if they are at least this similar, from 0 to 1
+	IgnoreRegexps       []*regexp.Regexp // Ignore changes whose lines all match any of these, in the same way as IgnoreBlankLines
+	Normalizers         []Normalizer     // Rewrite the lines before comparing them, the original lines are still in Result
+	IgnoreEOL           bool             // CompareEOL() only: ignore changes in line endings and a missing newline at end of file
+}
+
+// Result of comparing two sets of lines
+type Result struct {
+	Lines1, Lines2   [][]byte // the lines being compared
+	Ids1, Ids2       []int    // equivalence id of each line, identical lines have the same id
+	Change1, Change2 []bool   // lines that have been removed from Lines1 or inserted into Lines2
+	Hunks            []Hunk   // groups of changes with context lines
+	Moves            []DiffOp // blocks of lines moved from Start1:End1 to Start2:End2, when Options.DetectMoves is set
+	Eols1, Eols2     []int    // CompareEOL() only: line ending of each line, one of the EOL_xxx values
+	EolChanged       bool     // CompareEOL() only: each file consistently use a different line ending, see EolStyle()
+}
+
+// Return the default options, same as running godiff without any flags.
+func NewOptions() *Options {
+	return &Options{ContextLines: CONTEXT_LINES, Similarity: SIMILARITY_THRESHOLD}
+}
+
+// Report if there are any differences.
+func (res *Result) Changed() bool {
+	return len(res.Hunks) > 0 || res.EolChanged
+}
+
+//
+// Compare two sets of lines. A nil opts use the default options.
+//
+func Compare(lines1, lines2 [][]byte, opts *Options) *Result {
+	if opts == nil {
+		opts = NewOptions()
+	}
+	return compare(lines1, lines2, nil, nil, opts)
+}
+
+//
+// Compare two sets of lines, together with their line endings from SplitLinesEOL().
+// A line is changed if its line ending has changed, unless opts.IgnoreEOL is set.
+// When each file consistently use a different line ending, this is reported once
+// in Result.EolChanged, only a missing newline at end of file changes the last line.
+//
+func CompareEOL(lines1, lines2 [][]byte, eols1, eols2 []int, opts *Options) *Result {
+
+	if opts == nil {
+		opts = NewOptions()
+	}
+	if opts.IgnoreEOL {
+		return compare(lines1, lines2, nil, nil, opts)
+	}
+
+	cmp1, cmp2, eol_changed := compare_eols(eols1, eols2)
+	res := compare(lines1, lines2, cmp1, cmp2, opts)
+	res.Eols1, res.Eols2, res.EolChanged = eols1, eols2, eol_changed
+	return res
+}
+
+func compare(lines1, lines2 [][]byte, eols1, eols2 []int, opts *Options) *Result {
+
+	// Compute equiv ids for each line.
+	info1, info2 := find_equiv_lines(lines1, lines2, eols1, eols2, opts)
+
+	// No zids avaiable, no need to run diff comparision algorithm
+	// The find_equiv_lines() function may have perform the comparison already.
+	if info1.zids != nil && info2.zids != nil {
+		// run the diff algorithm
+		zchange1, zchange2 := do_diff(info1.zids, info2.zids, opts.Algorithm, cost_limit(len(info1.zids), len(info2.zids), opts))
+
+		// expand the change list, so that change array contains changes to actual lines
+		expand_change_list(info1, info2, zchange1, zchange2)
+	}
+
+	// perform shift boundary
+	shift_boundaries(info1.ids, info1.change, nil)
+	shift_boundaries(info2.ids, info2.change, nil)
+
+	res := &Result{
+		Lines1:  lines1,
+		Lines2:  lines2,
+		Ids1:    info1.ids,
+		Ids2:    info2.ids,
+		Change1: info1.change,
+		Change2: info2.change,
+	}
+
+	// find blocks of lines that have been moved
+	var moved1, moved2 []int
+	if opts.DetectMoves {
+		res.Moves, moved1, moved2 = find_moves(info1.ids, info2.ids, info1.change, info2.change, MOVE_MIN_LINES)
+	}
+
+	report_diff(func(ops []DiffOp) {
+		res.Hunks = append(res.Hunks, Hunk{Ops: append([]DiffOp(nil), ops...)})
+	}, info1.ids, info2.ids, info1.change, info2.change, moved1, moved2, opts.ContextLines)
+
+	return res
+}
+
+//
+// Compare two byte arrays, line by line.
+//
+func CompareBytes(data1, data2 []byte, opts *Options) *Result {
+	return Compare(SplitLines(data1), SplitLines(data2), opts)
+}
+
+//
+// Read and compare the content of two readers, line by line.
+//
+func CompareReaders(r1, r2 io.Reader, opts *Options) (*Result, error) {
+	data1, err := ioutil.ReadAll(r1)
+	if err != nil {
+		return nil, err
+	}
+	data2, err := ioutil.ReadAll(r2)
+	if err != nil {
+		return nil, err
+	}
+	return CompareBytes(data1, data2, opts), nil
+}
+
+//
+// split up data into text lines, accept dos, unix and mac newline.
+//
+func SplitLines(data []byte) [][]byte {
+
+	lines := make([][]byte, 0, utils.MinInt(len(data)/32, 500))
+	var i, previ int
+	var b, lastb byte
+
+	for i, b = range data {
+		if b == '\n' && lastb == '\r' {
+			previ = i + 1
+		} else if b == '\n' || b == '\r' {
+			lines = append(lines, data[previ:i])
+			previ = i + 1
+		}
+		lastb = b
+	}
+
+	// add last incomplete line (if required)
+	if len(data) > previ {
+		lines = append(lines, data[previ:len(data)])
+	}
+
+	return lines
+}
+
+// Reuse the working storage of the diff algorithm, do_diff() is called for every modified line.
+var diff_context_pool = sync.Pool{
+	New: func() interface{} { return &diff_context{} },
+}
+
+//
+// Number of steps before algorithm_sms() gives up on finding the minimal differences.
+// Roughly the square root of the number of lines, but at least 4096, same as GNU diff.
+//
+func cost_limit(len1, len2 int, opts *Options) int {
+	if opts.Minimal {
+		return 0
+	}
+	if opts.CostLimit > 0 {
+		return opts.CostLimit
+	}
+	limit := 1
+	for diags := len1 + len2 + 3; diags != 0; diags >>= 2 {
+		limit <<= 1
+	}
+	return utils.MaxInt(4096, limit)
+}
+
+//
+// Call the diff algorithm.
+//
+func do_diff(data1, data2 []int, algorithm, too_expensive int) ([]bool, []bool) {
+	len1, len2 := len(data1), len(data2)
+	change1, change2 := make([]bool, len1), make([]bool, len2)
+
+	size := ((len1+len2+1)*2 + 2) * 2
+
+	ctx := diff_context_pool.Get().(*diff_context)
+	defer diff_context_pool.Put(ctx)
+
+	if cap(ctx.v) < size {
+		ctx.v = make([]int, size)
+	}
+	ctx.v = ctx.v[:size]
+	ctx.too_expensive = too_expensive
+
+	// Run diff compare algorithm.
+	switch algorithm {
+	case ALGORITHM_PATIENCE:
+		algorithm_patience(data1, data2, change1, change2, ctx)
+	case ALGORITHM_HISTOGRAM:
+		algorithm_histogram(data1, data2, change1, change2, ctx)
+	default:
+		algorithm_lcs(data1, data2, change1, change2, ctx)
+	}
+
+	return change1, change2
+}
+
+// the id of the moved block that line i belongs to, 0 if it has not moved
+func move_id(moved []int, i int) int {
+	if moved == nil {
+		return 0
+	}
+	return moved[i]
+}
+
+//
+// Find the begin/end of this 'changed' segment, a moved block is a segment on its own
+//
+func next_change_segment(start int, change []bool, data []int, moved []int) (int, int, int) {
+
+	// find the end of this changes segment
+	end, id := start+1, move_id(moved, start)
+	for end < len(change) && change[end] && move_id(moved, end) == id {
+		end++
+	}
+
+	// skip blank lines (and lines matching Options.IgnoreRegexps) in the begining and end of the changes
+	i, j := start, end
+	for i < end && data[i] == 0 {
+		i++
+	}
+	for j > i && data[j-1] == 0 {
+		j--
+	}
+
+	return end, i, j
+}
+
+//
+// Add segment to the group of changes. Add context lines before and after if necessary
+//
+func add_change_segment(diff_lines func([]DiffOp), ops []DiffOp, op DiffOp, context_lines int) []DiffOp {
+	last1, last2 := 0, 0
+	if len(ops) > 0 {
+		last_op := ops[len(ops)-1]
+		last1, last2 = last_op.End1, last_op.End2
+	}
+
+	gap1, gap2 := op.Start1-last1, op.Start2-last2
+	if len(ops) > 0 && (op.Op == 0 || (gap1 > context_lines*2 && gap2 > context_lines*2)) {
+		e1, e2 := utils.MinInt(op.Start1, last1+context_lines), utils.MinInt(op.Start2, last2+context_lines)
+		if e1 > last1 || e2 > last2 {
+			ops = append(ops, DiffOp{DIFF_OP_SAME, last1, e1, last2, e2, 0})
+		}
+		diff_lines(ops)
+		ops = ops[:0]
+	}
+
+	// context lines before the change, or all the lines since the previous change in the group
+	c1, c2 := utils.MaxInt(last1, op.Start1-context_lines), utils.MaxInt(last2, op.Start2-context_lines)
+	if len(ops) > 0 {
+		c1, c2 = last1, last2
+	}
+	if c1 < op.Start1 || c2 < op.Start2 {
+		ops = append(ops, DiffOp{DIFF_OP_SAME, c1, op.Start1, c2, op.Start2, 0})
+	}
+
+	if op.Op != 0 {
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+//
+// Report diff changes.
+// For each group of change, call the diff_lines() function
+//
+func report_diff(diff_lines func([]DiffOp), data1, data2 []int, change1, change2 []bool, moved1, moved2 []int, context_lines int) bool {
+	len1, len2 := len(change1), len(change2)
+	i1, i2 := 0, 0
+	ops := make([]DiffOp, 0, 16)
+	changed := false
+	var m1start, m1end, m2start, m2end int
+
+	// scan for changes
+	for i1 < len1 || i2 < len2 {
+		switch {
+		// no change, advance both i1 and i2 to to next set of changes
+		case i1 < len1 && i2 < len2 && !change1[i1] && !change2[i2]:
+			i1++
+			i2++
+
+		// lines moved from file1
+		case i1 < len1 && change1[i1] && move_id(moved1, i1) != 0:
+			i1, m1start, m1end = next_change_segment(i1, change1, data1, moved1)
+			ops = add_change_segment(diff_lines, ops, DiffOp{DIFF_OP_MOVE, m1start, m1end, i2, i2, moved1[m1start]}, context_lines)
+			changed = true
+
+		// lines moved to file2
+		case i2 < len2 && change2[i2] && move_id(moved2, i2) != 0:
+			i2, m2start, m2end = next_change_segment(i2, change2, data2, moved2)
+			ops = add_change_segment(diff_lines, ops, DiffOp{DIFF_OP_MOVE, i1, i1, m2start, m2end, moved2[m2start]}, context_lines)
+			changed = true
+
+		// change in both lists
+		case i1 < len1 && i2 < len2 && change1[i1] && change2[i2]:
+			i1, m1start, m1end = next_change_segment(i1, change1, data1, moved1)
+			i2, m2start, m2end = next_change_segment(i2, change2, data2, moved2)
+
+			op_mode := 0
+			switch {
+			case m1start < m1end && m2start < m2end:
+				op_mode = DIFF_OP_MODIFY
+			case m1start < m1end:
+				op_mode = DIFF_OP_REMOVE
+			case m2start < m2end:
+				op_mode = DIFF_OP_INSERT
+			}
+			if op_mode != 0 {
+				ops = add_change_segment(diff_lines, ops, DiffOp{op_mode, m1start, m1end, m2start, m2end, 0}, context_lines)
+				changed = true
+			}
+
+		case i1 < len1 && change1[i1]:
+			i1, m1start, m1end = next_change_segment(i1, change1, data1, moved1)
+			if m1start < m1end {
+				ops = add_change_segment(diff_lines, ops, DiffOp{DIFF_OP_REMOVE, m1start, m1end, i2, i2, 0}, context_lines)
+				changed = true
+			}
+
+		case i2 < len2 && change2[i2]:
+			i2, m2start, m2end = next_change_segment(i2, change2, data2, moved2)
+			if m2start < m2end {
+				ops = add_change_segment(diff_lines, ops, DiffOp{DIFF_OP_INSERT, i1, i1, m2start, m2end, 0}, context_lines)
+				changed = true
+			}
+
+		default: // should not reach here
+			return true
+		}
+	}
+	if len(ops) > 0 {
+		add_change_segment(diff_lines, ops, DiffOp{0, len1, len1, len2, len2, 0}, context_lines)
+	}
+	return changed
+}
@@ -0,0 +1,684 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func split(s string) [][]byte {
+	return SplitLines([]byte(s))
+}
+
+func TestCompare(t *testing.T) {
+	lines1 := split("a\nb\nc\nd\ne\nf\ng\n")
+	lines2 := split("a\nb\nX\nd\ne\nf\ng\nh\n")
+
+	opts := NewOptions()
+	opts.ContextLines = 1
+	res := Compare(lines1, lines2, opts)
+
+	want := []Hunk{
+		{Ops: []DiffOp{
+			{DIFF_OP_SAME, 1, 2, 1, 2, 0},
+			{DIFF_OP_MODIFY, 2, 3, 2, 3, 0},
+			{DIFF_OP_SAME, 3, 4, 3, 4, 0},
+		}},
+		{Ops: []DiffOp{
+			{DIFF_OP_SAME, 6, 7, 6, 7, 0},
+			{DIFF_OP_INSERT, 7, 7, 7, 8, 0},
+		}},
+	}
+
+	if len(res.Hunks) != len(want) {
+		t.Fatalf("got %d hunks, want %d: %v", len(res.Hunks), len(want), res.Hunks)
+	}
+	for i := range want {
+		if len(res.Hunks[i].Ops) != len(want[i].Ops) {
+			t.Fatalf("hunk %d: got %v, want %v", i, res.Hunks[i].Ops, want[i].Ops)
+		}
+		for j := range want[i].Ops {
+			if res.Hunks[i].Ops[j] != want[i].Ops[j] {
+				t.Errorf("hunk %d op %d: got %v, want %v", i, j, res.Hunks[i].Ops[j], want[i].Ops[j])
+			}
+		}
+	}
+}
+
+func TestCompareOptions(t *testing.T) {
+	lines1 := split("Hello  World\n\nfoo\n")
+	lines2 := split("hello world\nfoo\n")
+
+	if res := Compare(lines1, lines2, nil); !res.Changed() {
+		t.Errorf("expected changes with default options")
+	}
+
+	opts := NewOptions()
+	opts.IgnoreCase = true
+	opts.IgnoreSpaceChange = true
+	opts.IgnoreBlankLines = true
+	if res := Compare(lines1, lines2, opts); res.Changed() {
+		t.Errorf("expected no changes with -i -b -B, got %v", res.Hunks)
+	}
+}
+
+func TestCompareReaders(t *testing.T) {
+	res, err := CompareReaders(strings.NewReader("a\r\nb\r\n"), strings.NewReader("a\nb"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed() {
+		t.Errorf("expected no changes, got %v", res.Hunks)
+	}
+}
+
+func TestDiffLine(t *testing.T) {
+	line1, line2 := []byte("foo(bar)"), []byte("foo(baz)")
+	pos1, change1, pos2, change2 := DiffLine(line1, line2, nil)
+
+	changed := func(line []byte, pos []int, change []bool) string {
+		var s []byte
+		for i, c := range change {
+			if c {
+				s = append(s, line[pos[i]:pos[i+1]]...)
+			}
+		}
+		return string(s)
+	}
+
+	if s := changed(line1, pos1, change1); s != "r" {
+		t.Errorf("line1 changes: got %q, want %q", s, "r")
+	}
+	if s := changed(line2, pos2, change2); s != "z" {
+		t.Errorf("line2 changes: got %q, want %q", s, "z")
+	}
+}
+
+func TestCompareAlgorithms(t *testing.T) {
+	lines1 := split("func a() {\n\tfoo()\n}\n\nfunc b() {\n\tbar()\n}\n")
+	lines2 := split("func a() {\n\tfoo()\n}\n\nfunc c() {\n\tbaz()\n}\n\nfunc b() {\n\tbar()\n}\n")
+
+	for _, algorithm := range []int{ALGORITHM_MYERS, ALGORITHM_PATIENCE, ALGORITHM_HISTOGRAM} {
+		opts := NewOptions()
+		opts.Algorithm = algorithm
+		res := Compare(lines1, lines2, opts)
+
+		for i, c := range res.Change1 {
+			if c {
+				t.Errorf("algorithm %d: line %d of file1 should not change", algorithm, i+1)
+			}
+		}
+		inserted := 0
+		for _, c := range res.Change2 {
+			if c {
+				inserted++
+			}
+		}
+		if inserted != 4 {
+			t.Errorf("algorithm %d: got %d inserted lines, want 4", algorithm, inserted)
+		}
+	}
+}
+
+func TestCompareCostLimit(t *testing.T) {
+	// two files with very few common lines
+	var b1, b2 []byte
+	for i := 0; i < 400; i++ {
+		b1 = append(b1, fmt.Sprintf("a%d\n", i*7%400)...)
+		b2 = append(b2, fmt.Sprintf("a%d\n", i*13%400)...)
+	}
+
+	minimal := NewOptions()
+	minimal.Minimal = true
+	limited := NewOptions()
+	limited.CostLimit = 10
+
+	edits := make(map[*Options]int)
+	for _, opts := range []*Options{minimal, limited} {
+		res := CompareBytes(b1, b2, opts)
+
+		// unchanged lines must be the same in both files
+		var same1, same2 [][]byte
+		for i, c := range res.Change1 {
+			if !c {
+				same1 = append(same1, res.Lines1[i])
+			}
+		}
+		for i, c := range res.Change2 {
+			if !c {
+				same2 = append(same2, res.Lines2[i])
+			}
+		}
+		if len(same1) != len(same2) {
+			t.Fatalf("cost limit %d: %d unchanged lines in file1, %d in file2", opts.CostLimit, len(same1), len(same2))
+		}
+		for i := range same1 {
+			if string(same1[i]) != string(same2[i]) {
+				t.Fatalf("cost limit %d: unchanged line %d differs: %q %q", opts.CostLimit, i, same1[i], same2[i])
+			}
+		}
+		edits[opts] = len(res.Lines1) + len(res.Lines2) - 2*len(same1)
+	}
+
+	// the optimal number of edits, from the longest common subsequence
+	lines1, lines2 := SplitLines(b1), SplitLines(b2)
+	lcs := make([][]int, len(lines1)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lines2)+1)
+	}
+	for i := len(lines1) - 1; i >= 0; i-- {
+		for j := len(lines2) - 1; j >= 0; j-- {
+			if string(lines1[i]) == string(lines2[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] > lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	optimal := len(lines1) + len(lines2) - 2*lcs[0][0]
+
+	if edits[minimal] != optimal {
+		t.Errorf("Minimal: %d edits, want the optimal %d", edits[minimal], optimal)
+	}
+	if edits[limited] <= optimal {
+		t.Errorf("cost limit %d: %d edits, want a non-minimal result, more than %d", limited.CostLimit, edits[limited], optimal)
+	}
+}
+
+func TestCompareMoves(t *testing.T) {
+	lines1 := split("a\nb\nc\nd\ne\nf\ng\nh\n")
+	lines2 := split("a\nf\ng\nh\nb\nc\nd\ne\n")
+
+	opts := NewOptions()
+	opts.DetectMoves = true
+	res := Compare(lines1, lines2, opts)
+
+	if len(res.Moves) != 1 {
+		t.Fatalf("got %d moves, want 1: %v", len(res.Moves), res.Moves)
+	}
+	m := res.Moves[0]
+	for i := m.Start1; i < m.End1; i++ {
+		if string(res.Lines1[i]) != string(res.Lines2[m.Start2+i-m.Start1]) {
+			t.Errorf("moved line %d does not match", i+1)
+		}
+	}
+
+	var from, to bool
+	for _, hunk := range res.Hunks {
+		for _, op := range hunk.Ops {
+			switch {
+			case op.Op == DIFF_OP_MOVE && op.Start1 == m.Start1 && op.End1 == m.End1:
+				from = true
+			case op.Op == DIFF_OP_MOVE && op.Start2 == m.Start2 && op.End2 == m.End2:
+				to = true
+			case op.Op != DIFF_OP_SAME:
+				t.Errorf("unexpected op %v", op)
+			}
+		}
+	}
+	if !from || !to {
+		t.Errorf("missing DIFF_OP_MOVE in hunks: %v", res.Hunks)
+	}
+}
+
+func TestDiffLineTokenizer(t *testing.T) {
+	line1, line2 := []byte("total = count_items(list) + 10"), []byte("total = count_item(lists) + 12")
+
+	tests := []struct {
+		tokenizer    int
+		want1, want2 string
+	}{
+		{TOKENIZE_RUNE, "s|0", "s|2"},
+		{TOKENIZE_WORD, "count_items|list|10", "count_item|lists|12"},
+		{TOKENIZE_SPACE, "count_items(list)|10", "count_item(lists)|12"},
+	}
+
+	// changed tokens separated by '|'
+	changed := func(line []byte, pos []int, change []bool) string {
+		var s []string
+		for i := 0; i < len(change); i++ {
+			if change[i] {
+				j := i
+				for j < len(change) && change[j] {
+					j++
+				}
+				s = append(s, string(line[pos[i]:pos[j]]))
+				i = j
+			}
+		}
+		return strings.Join(s, "|")
+	}
+
+	for _, test := range tests {
+		opts := NewOptions()
+		opts.Tokenizer = test.tokenizer
+		pos1, change1, pos2, change2 := DiffLine(line1, line2, opts)
+		if s := changed(line1, pos1, change1); s != test.want1 {
+			t.Errorf("tokenizer %d, line1 changes: got %q, want %q", test.tokenizer, s, test.want1)
+		}
+		if s := changed(line2, pos2, change2); s != test.want2 {
+			t.Errorf("tokenizer %d, line2 changes: got %q, want %q", test.tokenizer, s, test.want2)
+		}
+	}
+}
+
+func TestPairLines(t *testing.T) {
+	lines1 := split("total := count(items)\nreturn total\n")
+	lines2 := split("log.Printf(\"counting\")\ntotal := count(items) + 1\nreturn total, nil\n")
+
+	want := []LinePair{{-1, 0}, {0, 1}, {1, 2}}
+	pairs := PairLines(lines1, lines2, nil)
+	if len(pairs) != len(want) {
+		t.Fatalf("got %v, want %v", pairs, want)
+	}
+	for i := range want {
+		if pairs[i] != want[i] {
+			t.Errorf("pair %d: got %v, want %v", i, pairs[i], want[i])
+		}
+	}
+
+	// nothing is similar enough with a threshold of 1
+	opts := NewOptions()
+	opts.Similarity = 1
+	for _, p := range PairLines(lines1, lines2, opts) {
+		if p.Line1 >= 0 && p.Line2 >= 0 {
+			t.Errorf("unexpected pair %v", p)
+		}
+	}
+}
+
+func TestMerge3(t *testing.T) {
+	base := split("a\nb\nc\nd\ne\n")
+
+	tests := []struct {
+		left, right string
+		conflicts   int
+		merged      string
+	}{
+		{"a\nB\nc\nd\ne\n", "a\nb\nc\nD\ne\n", 0, "a\nB\nc\nD\ne\n"},
+		{"a\nb\nc\nd\ne\nf\n", "a\nb\nc\nd\ne\nf\n", 0, "a\nb\nc\nd\ne\nf\n"},
+		{"a\nb\nX\nd\ne\n", "a\nb\nY\nd\ne\n", 1, "a\nb\n<<<<<<< left\nX\n||||||| base\nc\n=======\nY\n>>>>>>> right\nd\ne\n"},
+	}
+
+	for _, test := range tests {
+		res := Merge3(base, split(test.left), split(test.right), nil)
+		if res.Conflicts != test.conflicts {
+			t.Errorf("got %d conflicts, want %d: %v", res.Conflicts, test.conflicts, res.Ops)
+		}
+		var buf strings.Builder
+		if err := res.WriteMerged(&buf, "base", "left", "right"); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.merged {
+			t.Errorf("merged: got %q, want %q", buf.String(), test.merged)
+		}
+	}
+
+	if res := Merge3(base, base, base, nil); res.Changed() {
+		t.Errorf("expected no changes, got %v", res.Ops)
+	}
+}
+
+func TestMerge3EOL(t *testing.T) {
+
+	tests := []struct {
+		base, left, right string
+		merged            string
+	}{
+		// dos line endings are kept, also for the conflict markers
+		{"a\r\nb\r\nc\r\nd\r\n", "a\r\nB\r\nc\r\nd\r\n", "a\r\nb\r\nc\r\nD\r\n", "a\r\nB\r\nc\r\nD\r\n"},
+		{"a\r\nb\r\n", "a\r\nX\r\n", "a\r\nY\r\n", "a\r\n<<<<<<< left\r\nX\r\n||||||| base\r\nb\r\n=======\r\nY\r\n>>>>>>> right\r\n"},
+		// no newline at end of file
+		{"a\nb", "A\nb", "a\nb", "A\nb"},
+		{"a\nb", "a\nb", "a\nb\nc", "a\nb\nc"},
+		// a line ending changed on one side
+		{"a\nb\nc\nd\n", "a\nb\r\nc\nd\n", "a\nb\nc\nD\n", "a\nb\r\nc\nD\n"},
+	}
+
+	for _, test := range tests {
+		base, eols0 := SplitLinesEOL([]byte(test.base))
+		left, eols1 := SplitLinesEOL([]byte(test.left))
+		right, eols2 := SplitLinesEOL([]byte(test.right))
+		res := Merge3EOL(base, left, right, eols0, eols1, eols2, nil)
+		var buf strings.Builder
+		if err := res.WriteMerged(&buf, "base", "left", "right"); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.merged {
+			t.Errorf("%q %q %q: merged %q, want %q", test.base, test.left, test.right, buf.String(), test.merged)
+		}
+	}
+}
+
+func TestMerge3Hunks(t *testing.T) {
+	base := split("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n")
+	left := split("1\nX\n3\n4\n5\n6\n7\n8\n9\n10\n")
+	right := split("1\n2\n3\n4\n5\n6\n7\n8\nY\n10\n")
+
+	hunks := Merge3(base, left, right, nil).Hunks(1)
+	if len(hunks) != 2 {
+		t.Fatalf("got %d hunks, want 2: %v", len(hunks), hunks)
+	}
+	want := MergeOp{MERGE_OP_SAME, 0, 1, 0, 1, 0, 1}
+	if hunks[0][0] != want {
+		t.Errorf("context before: got %v, want %v", hunks[0][0], want)
+	}
+	want = MergeOp{MERGE_OP_RIGHT, 8, 9, 8, 9, 8, 9}
+	if hunks[1][1] != want {
+		t.Errorf("right change: got %v, want %v", hunks[1][1], want)
+	}
+}
+
+func TestCompareHunkGap(t *testing.T) {
+	// changes 5 lines apart are in the same hunk, with all the lines in between
+	lines1 := split("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	lines2 := split("a\nB\nc\nd\ne\nf\ng\nH\ni\nj\n")
+
+	res := Compare(lines1, lines2, nil)
+	if len(res.Hunks) != 1 {
+		t.Fatalf("got %d hunks, want 1: %v", len(res.Hunks), res.Hunks)
+	}
+	next := 0
+	for _, op := range res.Hunks[0].Ops {
+		if op.Start1 != next {
+			t.Errorf("op %v does not start at line %d", op, next)
+		}
+		next = op.End1
+	}
+	if next != len(lines1) {
+		t.Errorf("hunk ends at line %d, want %d", next, len(lines1))
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	orig := "a\nb\nc\nd\ne\n"
+	want := "a\nB\nc\nd\ne\nf"
+
+	patches := map[string]string{
+		"godiff": "--- x\n+++ y\n@@ -1,5 +1,6 @@\n  a\n- b\n+ B\n  c\n  d\n  e\n+ f\n\\ No newline at end of file\n",
+		"diff":   "--- x\t2012-09-20\n+++ y\t2012-09-20\n@@ -1,5 +1,6 @@\n a\n-b\n+B\n c\n d\n e\n+f\n\\ No newline at end of file\n",
+	}
+
+	for name, patch := range patches {
+		fps, err := ParsePatch([]byte(patch))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(fps) != 1 || fps[0].Name1 != "x" || fps[0].Name2 != "y" || len(fps[0].Hunks) != 1 {
+			t.Fatalf("%s: unexpected parse result %v", name, fps)
+		}
+
+		out, res := ApplyPatch([]byte(orig), fps[0], nil)
+		if string(out) != want || !res[0].Applied {
+			t.Errorf("%s: got %q, want %q", name, out, want)
+		}
+
+		// with some lines added at the start
+		out, res = ApplyPatch([]byte("0\n0\n"+orig), fps[0], nil)
+		if string(out) != "0\n0\n"+want || res[0].Offset != 2 {
+			t.Errorf("%s offset: got %q %v", name, out, res)
+		}
+
+		out, _ = ApplyPatch([]byte(want), fps[0], &ApplyOptions{Reverse: true})
+		if string(out) != orig {
+			t.Errorf("%s reverse: got %q, want %q", name, out, orig)
+		}
+
+		out, res = ApplyPatch([]byte("x\ny\n"), fps[0], nil)
+		if string(out) != "x\ny\n" || res[0].Applied {
+			t.Errorf("%s: hunk should fail, got %q", name, out)
+		}
+	}
+}
+
+func TestApplyPatchEOL(t *testing.T) {
+	patch := "--- x\n+++ y\n@@ -1,5 +1,6 @@\n a\n-b\n+B\n c\n d\n e\n+f\n"
+	fps, err := ParsePatch([]byte(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		orig, want string
+	}{
+		// each line keeps its line ending, the added lines use the first one of the file
+		{"a\r\nb\nc\r\nd\ne\r\n", "a\r\nB\r\nc\r\nd\ne\r\nf\r\n"},
+		{"a\nb\r\nc\nd\r\ne\n", "a\nB\nc\nd\r\ne\nf\n"},
+		// no newline at end of the file, the line is no longer the last one
+		{"a\nb\nc\nd\ne", "a\nB\nc\nd\ne\nf\n"},
+	}
+	for _, test := range tests {
+		out, res := ApplyPatch([]byte(test.orig), fps[0], nil)
+		if string(out) != test.want || !res[0].Applied {
+			t.Errorf("%q: got %q, want %q", test.orig, out, test.want)
+		}
+	}
+}
+
+func TestCompareIgnoreRegexps(t *testing.T) {
+	lines1 := split("// Generated on Monday\nfoo\nbar\n// Version 1.0\nbaz\n")
+	lines2 := split("// Generated on Tuesday\nfoo\nbar\n// Version 1.1\nBAZ\n")
+
+	opts := NewOptions()
+	opts.ContextLines = 0
+	opts.IgnoreRegexps = []*regexp.Regexp{regexp.MustCompile(`^// Generated`), regexp.MustCompile(`^// Version`)}
+	res := Compare(lines1, lines2, opts)
+
+	// only the change to the last line is reported
+	if len(res.Hunks) != 1 || len(res.Hunks[0].Ops) != 1 {
+		t.Fatalf("got %v, want 1 change", res.Hunks)
+	}
+	if op := res.Hunks[0].Ops[0]; op.Op != DIFF_OP_MODIFY || op.Start1 != 4 || op.Start2 != 4 {
+		t.Errorf("got %v, want modify of line 5", op)
+	}
+
+	opts.IgnoreRegexps = append(opts.IgnoreRegexps, regexp.MustCompile(`(?i)^baz$`))
+	if res := Compare(lines1, lines2, opts); res.Changed() {
+		t.Errorf("expected no changes, got %v", res.Hunks)
+	}
+}
+
+func TestCompareNormalizers(t *testing.T) {
+	lines1 := split("2012-09-20 10:00:01 start\n\tindent\nend  \n")
+	lines2 := split("2012-09-21 11:30:00 start\n        indent\nend\n")
+
+	if res := Compare(lines1, lines2, nil); !res.Changed() {
+		t.Errorf("expected changes without normalizers")
+	}
+
+	opts := NewOptions()
+	opts.Normalizers = []Normalizer{
+		ReplaceNormalizer(regexp.MustCompile(`^\S+ \S+`), []byte("TIME")),
+		ExpandTabsNormalizer(TAB_WIDTH),
+		TrimNormalizer(),
+	}
+	res := Compare(lines1, lines2, opts)
+	if res.Changed() {
+		t.Errorf("expected no changes, got %v", res.Hunks)
+	}
+	if string(res.Lines1[0]) != "2012-09-20 10:00:01 start" {
+		t.Errorf("original line not kept: %q", res.Lines1[0])
+	}
+
+	if s := string(expand_tabs([]byte("ab\tc\t\td"), 4)); s != "ab  c       d" {
+		t.Errorf("expand_tabs: got %q", s)
+	}
+}
+
+func TestCompareTrailingSpaceTabs(t *testing.T) {
+	lines1 := split("if x {\t\n\tfoo(1)\nab\tc\n")
+	lines2 := split("if x {\n        foo(1)  \nab      c\n")
+
+	for _, unicode := range []bool{false, true} {
+		opts := NewOptions()
+		opts.Unicode = unicode
+		opts.IgnoreTrailingSpace = true
+		if res := Compare(lines1, lines2, opts); fmt.Sprint(res.Change1) != "[false true true]" {
+			t.Errorf("unicode=%v: trailing space only: expected lines 2-3 changed, got %v", unicode, res.Change1)
+		}
+
+		opts.IgnoreTabExpansion = true
+		if res := Compare(lines1, lines2, opts); res.Changed() {
+			t.Errorf("unicode=%v: expected no changes, got %v", unicode, res.Hunks)
+		}
+
+		// hashes must agree with the comparison
+		compare_line, compute_hash := opts.line_funcs()
+		for i := range lines1 {
+			if !compare_line(lines1[i], lines2[i]) || compute_hash(lines1[i]) != compute_hash(lines2[i]) {
+				t.Errorf("unicode=%v: line %d: compare and hash do not agree", unicode, i+1)
+			}
+		}
+
+		opts.TabWidth = 4
+		if res := Compare(lines1, lines2, opts); !res.Changed() {
+			t.Errorf("unicode=%v: expected changes with a tab width of 4", unicode)
+		}
+	}
+
+	// changes within the line: only "1" to "2" is highlighted
+	opts := NewOptions()
+	opts.IgnoreTrailingSpace, opts.IgnoreTabExpansion = true, true
+	line1, line2 := []byte("\tfoo(1)\t"), []byte("        foo(2)")
+	pos1, change1, pos2, change2 := DiffLine(line1, line2, opts)
+	if pos1[len(pos1)-1] != len(line1) || pos2[len(pos2)-1] != len(line2) {
+		t.Errorf("DiffLine: expected positions up to the end of line, got %v %v", pos1, pos2)
+	}
+	for i, c := range change1 {
+		if c != (line1[pos1[i]] == '1') {
+			t.Errorf("DiffLine: line1 token %d (%q): change=%v", i, line1[pos1[i]:pos1[i+1]], c)
+		}
+	}
+	for i, c := range change2 {
+		if c != (line2[pos2[i]] == '2') {
+			t.Errorf("DiffLine: line2 token %d (%q): change=%v", i, line2[pos2[i]:pos2[i+1]], c)
+		}
+	}
+}
+
+func TestCompareUnicodeNorm(t *testing.T) {
+	// "café" composed (NFC) and decomposed (NFD, as written on macOS)
+	lines1 := split("café\nﬁle\nx²\n")
+	lines2 := split("café\nfile\nx2\n")
+
+	if res := Compare(lines1, lines2, nil); fmt.Sprint(res.Change1) != "[true true true]" {
+		t.Errorf("expected all lines changed, got %v", res.Change1)
+	}
+
+	opts := NewOptions()
+	opts.UnicodeNorm = NORM_NFC
+	if res := Compare(lines1, lines2, opts); fmt.Sprint(res.Change1) != "[false true true]" {
+		t.Errorf("NFC: expected lines 2-3 changed, got %v", res.Change1)
+	}
+
+	opts.UnicodeNorm = NORM_NFKC
+	opts.IgnoreCase, opts.Unicode = true, true
+	if res := Compare(lines1, split("CAFÉ\nFILE\nX2\n"), opts); res.Changed() {
+		t.Errorf("NFKC: expected no changes, got %v", res.Hunks)
+	}
+
+	// changes within the line are at the byte offsets of the original text
+	opts = NewOptions()
+	opts.UnicodeNorm = NORM_NFC
+	line1, line2 := []byte("cafés"), []byte("café!")
+	pos1, change1, pos2, change2 := DiffLine(line1, line2, opts)
+	if fmt.Sprint(pos1) != "[0 1 2 3 5 6]" || fmt.Sprint(change1) != "[false false false false true]" {
+		t.Errorf("line1: got %v %v", pos1, change1)
+	}
+	if fmt.Sprint(pos2) != "[0 1 2 3 6 7]" || fmt.Sprint(change2) != "[false false false false true]" {
+		t.Errorf("line2: got %v %v", pos2, change2)
+	}
+}
+
+func TestDiffLineGraphemes(t *testing.T) {
+
+	tests := []struct {
+		line1, line2 string
+		changed      string // the changed text in line1 and line2
+		words        bool   // same changes with TOKENIZE_WORD, otherwise the whole word is changed
+	}{
+		// skin tone modifier
+		{"ok \U0001F44D done", "ok \U0001F44D\U0001F3FD done", "\U0001F44D|\U0001F44D\U0001F3FD", true},
+		// regional indicators
+		{"flag \U0001F1EB\U0001F1F7!", "flag \U0001F1E9\U0001F1EA!", "\U0001F1EB\U0001F1F7|\U0001F1E9\U0001F1EA", true},
+		// combining accents
+		{"cafe\u0301s", "cafe\u0300s", "e\u0301|e\u0300", false},
+		// devanagari vowel signs
+		{"\u0928\u092E\u0938\u094D\u0924\u0947", "\u0928\u092E\u0938\u094D\u0924\u093E", "\u0924\u0947|\u0924\u093E", false},
+		// zero width joiner
+		{"\U0001F468\u200D\U0001F469\u200D\U0001F467 family", "\U0001F468\u200D\U0001F469\u200D\U0001F466 family", "\U0001F468\u200D\U0001F469\u200D\U0001F467|\U0001F468\u200D\U0001F469\u200D\U0001F466", true},
+	}
+
+	changed_text := func(line string, pos []int, change []bool) string {
+		var buf strings.Builder
+		for i, c := range change {
+			if c {
+				buf.WriteString(line[pos[i]:pos[i+1]])
+			}
+		}
+		return buf.String()
+	}
+
+	for _, tk := range []int{TOKENIZE_RUNE, TOKENIZE_WORD} {
+		opts := NewOptions()
+		opts.Tokenizer = tk
+		for _, test := range tests {
+			pos1, change1, pos2, change2 := DiffLine([]byte(test.line1), []byte(test.line2), opts)
+			got := changed_text(test.line1, pos1, change1) + "|" + changed_text(test.line2, pos2, change2)
+			if got != test.changed && (tk == TOKENIZE_RUNE || test.words) {
+				t.Errorf("tokenizer %d: %q %q: expected %q, got %q", tk, test.line1, test.line2, test.changed, got)
+			}
+		}
+	}
+}
+
+func TestCompareEOL(t *testing.T) {
+
+	compare := func(data1, data2 string, opts *Options) *Result {
+		lines1, eols1 := SplitLinesEOL([]byte(data1))
+		lines2, eols2 := SplitLinesEOL([]byte(data2))
+		return CompareEOL(lines1, lines2, eols1, eols2, opts)
+	}
+
+	lines, eols := SplitLinesEOL([]byte("a\r\nb\nc\rd"))
+	if fmt.Sprintf("%q %v", lines, eols) != `["a" "b" "c" "d"] [2 1 3 0]` {
+		t.Errorf("SplitLinesEOL: got %q %v", lines, eols)
+	}
+	if EolStyle(eols) != EOL_MIXED || EolStyle([]int{EOL_CRLF, EOL_CRLF, EOL_NONE}) != EOL_CRLF {
+		t.Errorf("EolStyle: got %d", EolStyle(eols))
+	}
+
+	// converted from dos to unix: reported once, the lines are the same
+	res := compare("a\r\nb\r\n", "a\nb\n", nil)
+	if !res.EolChanged || len(res.Hunks) != 0 || !res.Changed() {
+		t.Errorf("dos to unix: expected EolChanged only, got %v %v", res.EolChanged, res.Hunks)
+	}
+
+	// converted, and the final newline removed
+	res = compare("a\r\nb\r\n", "a\nb", nil)
+	if !res.EolChanged || fmt.Sprint(res.Change1) != "[false true]" {
+		t.Errorf("no newline: expected last line changed, got %v", res.Change1)
+	}
+
+	// mixed line endings are compared line by line
+	res = compare("a\nb\nc\n", "a\nb\r\nc\n", nil)
+	if res.EolChanged || fmt.Sprint(res.Change2) != "[false true false]" {
+		t.Errorf("mixed: expected line 2 changed, got %v", res.Change2)
+	}
+
+	opts := NewOptions()
+	opts.IgnoreEOL = true
+	if res = compare("a\r\nb\r\n", "a\nb", opts); res.Changed() {
+		t.Errorf("IgnoreEOL: expected no changes, got %v", res.Hunks)
+	}
+
+	// blank lines are still ignored with -B
+	opts = NewOptions()
+	opts.IgnoreBlankLines = true
+	if res = compare("a\nb\n", "a\n\r\nb\n\n", opts); res.Changed() {
+		t.Errorf("IgnoreBlankLines: expected no changes, got %v", res.Hunks)
+	}
+}
@@ -0,0 +1,98 @@
+package diff
+
+// Line endings, see SplitLinesEOL()
+const (
+	EOL_NONE  = 0 // last line of a file without a newline at the end
+	EOL_LF    = 1 // unix "\n"
+	EOL_CRLF  = 2 // dos "\r\n"
+	EOL_CR    = 3 // old mac "\r"
+	EOL_MIXED = 4 // returned by EolStyle() when the lines do not all use the same line ending
+)
+
+var eol_names = []string{"no newline", "LF", "CRLF", "CR", "mixed"}
+
+// The bytes of a line ending, by EOL_xxx
+var eol_bytes = [][]byte{nil, []byte("\n"), []byte("\r\n"), []byte("\r"), nil}
+
+// Name of a line ending: "LF", "CRLF" etc.
+func EolName(eol int) string {
+	if eol < 0 || eol >= len(eol_names) {
+		return "unknown"
+	}
+	return eol_names[eol]
+}
+
+//
+// Same as SplitLines(), also return the line ending of each line, one of the EOL_xxx values.
+//
+func SplitLinesEOL(data []byte) ([][]byte, []int) {
+
+	lines := make([][]byte, 0, len(data)/32+1)
+	eols := make([]int, 0, len(data)/32+1)
+	var previ int
+
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\n':
+			lines, eols = append(lines, data[previ:i]), append(eols, EOL_LF)
+			previ = i + 1
+		case '\r':
+			lines = append(lines, data[previ:i])
+			if i+1 < len(data) && data[i+1] == '\n' {
+				eols = append(eols, EOL_CRLF)
+				i++
+			} else {
+				eols = append(eols, EOL_CR)
+			}
+			previ = i + 1
+		}
+	}
+
+	// add last incomplete line (if required)
+	if len(data) > previ {
+		lines, eols = append(lines, data[previ:]), append(eols, EOL_NONE)
+	}
+
+	return lines, eols
+}
+
+//
+// The line ending used by all the lines, ignoring a missing newline on the last line.
+// Return EOL_MIXED if the lines use different line endings, EOL_NONE if there are no line endings.
+//
+func EolStyle(eols []int) int {
+	style := EOL_NONE
+	for _, eol := range eols {
+		if eol == EOL_NONE || eol == style {
+			continue
+		}
+		if style != EOL_NONE {
+			return EOL_MIXED
+		}
+		style = eol
+	}
+	return style
+}
+
+//
+// The line endings to compare. When each file consistently use a different line ending,
+// ie. a file converted from dos to unix, only a missing newline at end of file is compared.
+//
+func compare_eols(eols1, eols2 []int) ([]int, []int, bool) {
+
+	style1, style2 := EolStyle(eols1), EolStyle(eols2)
+	if style1 == style2 || style1 == EOL_MIXED || style2 == EOL_MIXED || style1 == EOL_NONE || style2 == EOL_NONE {
+		return eols1, eols2, false
+	}
+
+	final_newline := func(eols []int) []int {
+		cmp := make([]int, len(eols))
+		for i, eol := range eols {
+			if eol != EOL_NONE {
+				cmp[i] = EOL_LF
+			}
+		}
+		return cmp
+	}
+	return final_newline(eols1), final_newline(eols2), true
+}
@@ -0,0 +1,274 @@
+package diff
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"github.com/rsrini7/godiff/utils"
+)
+
+// Compare values in split_runes(), above the unicode runes
+const (
+	space_run    = utf8.MaxRune + 1  // a run of spaces and tabs, plus its width
+	cluster_hash = space_run + 1<<20 // a grapheme cluster of more than one rune, plus its hash
+)
+
+//
+// Compare the changes within a pair of lines.
+// The lines are split into runes or tokens, based on Options.Tokenizer.
+// Return the byte position of each token in the lines (plus the end of line), and which tokens have changed.
+//
+func DiffLine(line1, line2 []byte, opts *Options) ([]int, []bool, []int, []bool) {
+
+	if opts == nil {
+		opts = NewOptions()
+	}
+
+	var pos1, cmp1, pos2, cmp2 []int
+	boundary_score := rune_bouundary_score
+
+	if opts.Tokenizer == TOKENIZE_RUNE {
+		pos1, cmp1 = split_runes(line1, opts)
+		pos2, cmp2 = split_runes(line2, opts)
+	} else {
+		tk := new_tokenizer(opts)
+		pos1, cmp1 = tk.split(line1)
+		pos2, cmp2 = tk.split(line2)
+		boundary_score = tk.boundary_score
+	}
+
+	change1, change2 := do_diff(cmp1, cmp2, ALGORITHM_MYERS, cost_limit(len(cmp1), len(cmp2), opts))
+
+	// perform shift boundaries, to make the changes more readable
+	shift_boundaries(cmp1, change1, boundary_score)
+	shift_boundaries(cmp2, change2, boundary_score)
+
+	return pos1, change1, pos2, change2
+}
+
+//
+// split text into array of grapheme cluster (user-perceived character) position, and another array for comparison.
+// A change is never shown in the middle of a character made of several runes, ie. an accent
+// written as a combining mark, an emoji with a skin tone modifier or a flag.
+// With IgnoreTabExpansion, a run of spaces and tabs is compared by its width.
+// With IgnoreTrailingSpace, the white space at the end is part of the last character.
+// With UnicodeNorm, the characters are compared in their normalized form,
+// the positions are still the byte offsets in the original text.
+//
+func split_runes(s []byte, opts *Options) ([]int, []int) {
+
+	pos := make([]int, len(s)+1)
+	cmp := make([]int, len(s))
+
+	end := len(s)
+	if opts.IgnoreTrailingSpace {
+		end = opts.trailing_space(s)
+	}
+	tab_width := 0
+	if opts.IgnoreTabExpansion {
+		tab_width = opts.tab_width()
+	}
+
+	var h, i, n, col int
+
+	for i < end {
+		pos[n] = i
+		b := s[i]
+		if tab_width > 0 && (b == ' ' || b == '\t') {
+			j := i
+			for j < end && (s[j] == ' ' || s[j] == '\t') {
+				j++
+			}
+			next_col := tab_column(s[i:j], col, tab_width)
+			h, i, col = space_run+next_col-col, j, next_col
+		} else if b < utf8.RuneSelf && (i+1 >= end || s[i+1] < utf8.RuneSelf) {
+			// ascii, not followed by a combining mark
+			if opts.IgnoreCase {
+				if opts.Unicode {
+					h = int(unicode.ToLower(rune(b)))
+				} else {
+					h = int(utils.ToLowerByte(b))
+				}
+			} else {
+				h = int(b)
+			}
+			i++
+			col++
+		} else {
+			cluster, _, _, _ := uniseg.FirstGraphemeCluster(s[i:end], -1)
+			h = opts.cluster_id(cluster)
+			i += len(cluster)
+			col += utf8.RuneCount(cluster)
+		}
+		cmp[n] = h
+		n = n + 1
+	}
+	pos[n] = len(s)
+	return pos[:n+1], cmp[:n]
+}
+
+//
+// Compare value of a grapheme cluster, normalized if Options.UnicodeNorm is used.
+// It is compared as a rune if it is a single rune, or by the hash of its bytes.
+//
+func (opts *Options) cluster_id(cluster []byte) int {
+	if form, ok := opts.norm_form(); ok {
+		cluster = form.Bytes(cluster)
+	}
+	r, size := utf8.DecodeRune(cluster)
+	if size == len(cluster) {
+		if opts.IgnoreCase && opts.Unicode {
+			r = unicode.ToLower(r)
+		} else if opts.IgnoreCase && r < utf8.RuneSelf {
+			r = rune(utils.ToLowerByte(byte(r)))
+		}
+		return int(r)
+	}
+	if opts.IgnoreCase && opts.Unicode {
+		cluster = bytes.ToLower(cluster)
+	} else if opts.IgnoreCase {
+		cluster = bytes.Map(func(r rune) rune {
+			if r < utf8.RuneSelf {
+				return rune(utils.ToLowerByte(byte(r)))
+			}
+			return r
+		}, cluster)
+	}
+	return cluster_hash + int(compute_hash_exact(cluster)>>2)
+}
+
+// Perform the shift
+func do_shift_boundary(start, end, offset int, change []bool) {
+	if offset < 0 {
+		for offset != 0 {
+			start, end, offset = start-1, end-1, offset+1
+			change[start], change[end] = true, false
+		}
+	} else {
+		for offset != 0 {
+			change[start], change[end] = false, true
+			start, end, offset = start+1, end+1, offset-1
+		}
+	}
+}
+
+// Determine if the changes starting at 'pos' can be shifted 'up' or 'down'
+func find_shift_boundary(start int, data []int, change []bool) (int, int, int, bool, bool) {
+	end, dlen := start+1, len(data)
+	up, down := 0, 0
+
+	// Find the end of this chunk of changes
+	for end < dlen && change[end] {
+		end++
+	}
+
+	for start-up-1 >= 0 && !change[start-up-1] && data[start-up-1] == data[end-up-1] {
+		up = up + 1
+	}
+
+	for end+down < dlen && !change[end+down] && data[end+down] == data[start+down] {
+		down = down + 1
+	}
+
+	// has changes been shifted to start/end of list or merged with previous/next change
+	up_merge := (start-up == 0) || change[start-up-1]
+	down_merge := (end+down == dlen) || change[end+down]
+
+	return end, up, down, up_merge, down_merge
+}
+
+// scoring function for shifting characters in a line.
+func rune_edge_score(r rune) int {
+
+	if r >= space_run && r < cluster_hash {
+		return 100
+	}
+
+	switch r {
+	case ' ', '\t', '\v', '\f':
+		return 100
+
+	case '<', '>', '(', ')', '[', ']', '\'', '"':
+		return 40
+	}
+
+	return 0
+}
+
+// scoring character boundary, for finding a change chunk that is easier to read
+func rune_bouundary_score(r1, r2 int) int {
+
+	s1 := rune_edge_score(rune(r1))
+	s2 := rune_edge_score(rune(r2))
+
+	return s1 + s2
+}
+
+//
+// shift changes up or down to make it more readable.
+//
+func shift_boundaries(data []int, change []bool, boundary_score func(int, int) int) {
+
+	start, clen := 0, len(change)
+
+	for start < clen {
+		// find the next chunk of changes
+		for start < clen && !change[start] {
+			start++
+		}
+		if start >= clen {
+			break
+		}
+
+		// find the limit of where this set of changes can be shifted
+		end, up, down, up_merge, down_merge := find_shift_boundary(start, data, change)
+
+		// The chunk is already at the start, do not shift downwards
+		if start == 0 {
+			up, down = 0, 0
+		}
+
+		switch {
+		case up > 0 && up_merge:
+			// shift up, merged with previous chunk of changes
+			do_shift_boundary(start, end, -up, change)
+			// restart at the begining of this merged chunk
+			nstart := start
+			for nstart -= up; nstart-1 >= 0 && change[nstart-1]; nstart-- {
+			}
+			if nstart > 0 {
+				start = nstart
+			}
+
+		case down > 0 && down_merge:
+			// shift down, merged with next chunk of changes
+			do_shift_boundary(start, end, down, change)
+			start += down
+
+		case (up > 0 || down > 0) && boundary_score != nil:
+			// Only perform shifts when there is a boundary score function
+			offset, best_score := 0, boundary_score(data[start], data[end-1])
+			for i := -up; i <= down; i++ {
+				if i != 0 {
+					score := boundary_score(data[start+i], data[end+i-1])
+					if score > best_score {
+						offset, best_score = i, score
+					}
+				}
+			}
+			if offset != 0 {
+				do_shift_boundary(start, end, offset, change)
+			}
+			start = end
+			if offset > 0 {
+				start += offset
+			}
+
+		default:
+			// no shift
+			start = end
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package diff
+
+import (
+	"bytes"
+	"io"
+)
+
+// Kind of change in a MergeOp
+const (
+	MERGE_OP_SAME     = 1 // no change in left and right
+	MERGE_OP_LEFT     = 2 // changed in left only
+	MERGE_OP_RIGHT    = 3 // changed in right only
+	MERGE_OP_BOTH     = 4 // same change made in left and right
+	MERGE_OP_CONFLICT = 5 // different changes made in left and right
+)
+
+// A range of lines in base (Start0 to End0), left (Start1 to End1) and right (Start2 to End2).
+// Line numbers start from 0, the End is exclusive.
+type MergeOp struct {
+	Op           int
+	Start0, End0 int
+	Start1, End1 int
+	Start2, End2 int
+}
+
+// Result of a three-way comparison
+type MergeResult struct {
+	Base, Left, Right [][]byte  // the lines being compared
+	Ops               []MergeOp // all lines of the three files, in order
+	Conflicts         int       // number of MERGE_OP_CONFLICT in Ops
+	Eols0, Eols1      []int     // Merge3EOL() only: line ending of each line of base, left and right
+	Eols2             []int
+}
+
+// Report if left or right have any changes.
+func (res *MergeResult) Changed() bool {
+	return len(res.Ops) > 1 || (len(res.Ops) == 1 && res.Ops[0].Op != MERGE_OP_SAME)
+}
+
+//
+// Three-way compare of a common ancestor (base) with two edited copies (left and right).
+// Base is compared with left and with right, lines that are unchanged in both are used to
+// split the files into chunks, each chunk is then classified as a MergeOp.
+// A nil opts use the default options.
+//
+func Merge3(base, left, right [][]byte, opts *Options) *MergeResult {
+	return Merge3EOL(base, left, right, nil, nil, nil, opts)
+}
+
+//
+// Same as Merge3(), with the line endings of each file from SplitLinesEOL(). A line is changed
+// if its line ending has changed, as in CompareEOL(), and WriteMerged() keeps the line endings.
+//
+func Merge3EOL(base, left, right [][]byte, eols0, eols1, eols2 []int, opts *Options) *MergeResult {
+
+	if opts == nil {
+		opts = NewOptions()
+	}
+
+	// every line must be accounted for in the merged output
+	o := *opts
+	o.IgnoreBlankLines = false
+	o.IgnoreRegexps = nil
+	o.Normalizers = nil
+	o.DetectMoves = false
+
+	res1 := CompareEOL(base, left, eols0, eols1, &o)
+	res2 := CompareEOL(base, right, eols0, eols2, &o)
+	match1 := match_lines(res1.Change1, res1.Change2)
+	match2 := match_lines(res2.Change1, res2.Change2)
+	compare_line, _ := o.line_funcs()
+
+	// the line endings compared, as in CompareEOL()
+	cmp_eols := func(eols1, eols2 []int) ([]int, []int) {
+		if o.IgnoreEOL {
+			return nil, nil
+		}
+		cmp1, cmp2, _ := compare_eols(eols1, eols2)
+		return cmp1, cmp2
+	}
+	base1, left0 := cmp_eols(eols0, eols1)
+	base2, right0 := cmp_eols(eols0, eols2)
+	left2, right1 := cmp_eols(eols1, eols2)
+
+	res := &MergeResult{Base: base, Left: left, Right: right, Eols0: eols0, Eols1: eols1, Eols2: eols2}
+
+	i0, i1, i2 := 0, 0, 0
+	for i0 < len(base) || i1 < len(left) || i2 < len(right) {
+
+		// lines that are unchanged in both left and right
+		j0 := i0
+		for j0 < len(base) && match1[j0] == i1+j0-i0 && match2[j0] == i2+j0-i0 {
+			j0++
+		}
+		if j0 > i0 {
+			n := j0 - i0
+			res.Ops = append(res.Ops, MergeOp{MERGE_OP_SAME, i0, j0, i1, i1 + n, i2, i2 + n})
+			i0, i1, i2 = j0, i1+n, i2+n
+			continue
+		}
+
+		// changes up to the next line that is unchanged in both
+		for j0 < len(base) && (match1[j0] < 0 || match2[j0] < 0) {
+			j0++
+		}
+		j1, j2 := len(left), len(right)
+		if j0 < len(base) {
+			j1, j2 = match1[j0], match2[j0]
+		}
+
+		op := MergeOp{MERGE_OP_CONFLICT, i0, j0, i1, j1, i2, j2}
+		switch {
+		case equal_lines(base[i0:j0], left[i1:j1], eol_range(base1, i0, j0), eol_range(left0, i1, j1), compare_line):
+			op.Op = MERGE_OP_RIGHT
+		case equal_lines(base[i0:j0], right[i2:j2], eol_range(base2, i0, j0), eol_range(right0, i2, j2), compare_line):
+			op.Op = MERGE_OP_LEFT
+		case equal_lines(left[i1:j1], right[i2:j2], eol_range(left2, i1, j1), eol_range(right1, i2, j2), compare_line):
+			op.Op = MERGE_OP_BOTH
+		default:
+			res.Conflicts++
+		}
+		res.Ops = append(res.Ops, op)
+		i0, i1, i2 = j0, j1, j2
+	}
+
+	return res
+}
+
+//
+// For each line in file1, the line number of the matching line in file2, or -1 if changed.
+//
+func match_lines(change1, change2 []bool) []int {
+	match := make([]int, len(change1))
+	j := 0
+	for i, c := range change1 {
+		match[i] = -1
+		if c {
+			continue
+		}
+		for j < len(change2) && change2[j] {
+			j++
+		}
+		if j < len(change2) {
+			match[i] = j
+			j++
+		}
+	}
+	return match
+}
+
+// Compare the lines and their line endings, the line endings are not compared if nil
+func equal_lines(lines1, lines2 [][]byte, eols1, eols2 []int, compare_line func([]byte, []byte) bool) bool {
+	if len(lines1) != len(lines2) {
+		return false
+	}
+	for i := range lines1 {
+		if !compare_line(lines1[i], lines2[i]) {
+			return false
+		}
+		if eols1 != nil && eols2 != nil && eols1[i] != eols2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// The line endings of lines start to end, nil if there are no line endings
+func eol_range(eols []int, start, end int) []int {
+	if eols == nil {
+		return nil
+	}
+	return eols[start:end]
+}
+
+//
+// Group the changes, together with context_lines of unchanged lines before and after.
+//
+func (res *MergeResult) Hunks(context_lines int) [][]MergeOp {
+
+	var hunks [][]MergeOp
+	var hunk []MergeOp
+
+	same := func(op MergeOp, from, to int) MergeOp {
+		return MergeOp{MERGE_OP_SAME, op.Start0 + from, op.Start0 + to, op.Start1 + from, op.Start1 + to, op.Start2 + from, op.Start2 + to}
+	}
+
+	last := len(res.Ops) - 1
+	for i, op := range res.Ops {
+		if op.Op != MERGE_OP_SAME {
+			hunk = append(hunk, op)
+			continue
+		}
+
+		n := op.End0 - op.Start0
+		c := n
+		if c > context_lines {
+			c = context_lines
+		}
+
+		// context after the previous change
+		if len(hunk) > 0 {
+			if i < last && n <= 2*context_lines {
+				hunk = append(hunk, op)
+				continue
+			}
+			if c > 0 {
+				hunk = append(hunk, same(op, 0, c))
+			}
+			hunks = append(hunks, hunk)
+			hunk = nil
+		}
+
+		// context before the next change
+		if i < last && c > 0 {
+			hunk = append(hunk, same(op, n-c, n))
+		}
+	}
+
+	if len(hunk) > 0 {
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+//
+// Write the merged lines, conflicts are written with diff3 style markers:
+// "<<<<<<< name1", the left lines, "||||||| name0", the base lines,
+// "=======", the right lines and ">>>>>>> name2".
+// With Merge3EOL(), each line keeps its line ending, the markers use the line ending of left
+// (or base), and the last line has no newline if it had none. Otherwise lines end with "\n".
+//
+func (res *MergeResult) WriteMerged(w io.Writer, name0, name1, name2 string) error {
+
+	var buf bytes.Buffer
+
+	// line ending of the markers, and of a line without newline followed by other lines
+	eol := eol_bytes[EOL_LF]
+	for _, eols := range [][]int{res.Eols1, res.Eols0, res.Eols2} {
+		if style := EolStyle(eols); style != EOL_NONE && style != EOL_MIXED {
+			eol = eol_bytes[style]
+			break
+		}
+	}
+
+	missing := false // the last line written has no newline
+	write := func(line, line_eol []byte) {
+		if missing {
+			buf.Write(eol)
+		}
+		buf.Write(line)
+		buf.Write(line_eol)
+		missing = line_eol == nil
+	}
+	write_lines := func(lines [][]byte, eols []int, start int) {
+		for i, line := range lines {
+			if eols == nil {
+				write(line, eol_bytes[EOL_LF])
+			} else {
+				write(line, eol_bytes[eols[start+i]])
+			}
+		}
+	}
+
+	for _, op := range res.Ops {
+		switch op.Op {
+		case MERGE_OP_RIGHT:
+			write_lines(res.Right[op.Start2:op.End2], res.Eols2, op.Start2)
+
+		case MERGE_OP_CONFLICT:
+			write([]byte("<<<<<<< "+name1), eol)
+			write_lines(res.Left[op.Start1:op.End1], res.Eols1, op.Start1)
+			write([]byte("||||||| "+name0), eol)
+			write_lines(res.Base[op.Start0:op.End0], res.Eols0, op.Start0)
+			write([]byte("======="), eol)
+			write_lines(res.Right[op.Start2:op.End2], res.Eols2, op.Start2)
+			write([]byte(">>>>>>> "+name2), eol)
+
+		default:
+			write_lines(res.Left[op.Start1:op.End1], res.Eols1, op.Start1)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
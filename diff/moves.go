@@ -0,0 +1,67 @@
+package diff
+
+// Minimum number of non-blank lines for a block of changes to be reported as a move
+const MOVE_MIN_LINES = 3
+
+//
+// Find blocks of lines that have been removed from one place in file1, and inserted
+// into another place in file2. Each moved block is given an id starting from 1, which
+// is stored in moved1[] and moved2[] for every line of the block.
+//
+func find_moves(ids1, ids2 []int, change1, change2 []bool, min_lines int) ([]DiffOp, []int, []int) {
+
+	len1, len2 := len(ids1), len(ids2)
+	moved1, moved2 := make([]int, len1), make([]int, len2)
+	var moves []DiffOp
+
+	// where the inserted lines are in file2, indexed by the line id
+	inserted := make(map[int][]int)
+	for i, id := range ids2 {
+		if change2[i] && id != 0 {
+			inserted[id] = append(inserted[id], i)
+		}
+	}
+
+	for i1 := 0; i1 < len1; {
+		if !change1[i1] || ids1[i1] == 0 {
+			i1++
+			continue
+		}
+
+		// find the longest run of inserted lines matching the removed lines starting at i1
+		best_start, best_len, best_count := 0, 0, 0
+		for _, i2 := range inserted[ids1[i1]] {
+			n, count := 0, 0
+			for i1+n < len1 && i2+n < len2 && change1[i1+n] && change2[i2+n] &&
+				moved1[i1+n] == 0 && moved2[i2+n] == 0 && ids1[i1+n] == ids2[i2+n] {
+				if ids1[i1+n] != 0 {
+					count++
+				}
+				n++
+			}
+			if count > best_count {
+				best_start, best_len, best_count = i2, n, count
+			}
+		}
+
+		if best_count < min_lines {
+			i1++
+			continue
+		}
+
+		// drop blank lines at the end of the block
+		for best_len > 0 && ids1[i1+best_len-1] == 0 {
+			best_len--
+		}
+
+		id := len(moves) + 1
+		for n := 0; n < best_len; n++ {
+			moved1[i1+n] = id
+			moved2[best_start+n] = id
+		}
+		moves = append(moves, DiffOp{DIFF_OP_MOVE, i1, i1 + best_len, best_start, best_start + best_len, id})
+		i1 += best_len
+	}
+
+	return moves, moved1, moved2
+}
@@ -0,0 +1,136 @@
+package diff
+
+import (
+	"bytes"
+	"regexp"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Default tab width for ExpandTabsNormalizer
+const TAB_WIDTH = 8
+
+// Unicode normalization applied before comparing lines, see Options.UnicodeNorm
+const (
+	NORM_NONE = 0
+	NORM_NFC  = 1 // canonical composition, ie. "e" + U+0301 is the same as U+00E9
+	NORM_NFKC = 2 // compatibility composition, also U+FB01 is the same as "fi", U+00B2 the same as "2"
+)
+
+//
+// Rewrite a line before it is compared, see Options.Normalizers.
+// The line given must not be modified, return a new slice if it needs changing.
+//
+type Normalizer func(line []byte) []byte
+
+// Replace the text matching a regexp, ie. to mask out time stamps or ids. See regexp.ReplaceAll() for repl.
+func ReplaceNormalizer(re *regexp.Regexp, repl []byte) Normalizer {
+	return func(line []byte) []byte {
+		return re.ReplaceAll(line, repl)
+	}
+}
+
+// Remove white space at the start and end of a line
+func TrimNormalizer() Normalizer {
+	return bytes.TrimSpace
+}
+
+// Replace tabs with spaces, up to the next multiple of tab_width columns
+func ExpandTabsNormalizer(tab_width int) Normalizer {
+	return func(line []byte) []byte {
+		return expand_tabs(line, tab_width)
+	}
+}
+
+//
+// Replace tabs with spaces, a column is counted for each rune
+//
+func expand_tabs(line []byte, tab_width int) []byte {
+
+	if bytes.IndexByte(line, '\t') < 0 || tab_width <= 0 {
+		return line
+	}
+
+	buf := make([]byte, 0, len(line)+tab_width*4)
+	col := 0
+	for i := 0; i < len(line); {
+		if line[i] == '\t' {
+			n := tab_width - col%tab_width
+			buf = append(buf, bytes.Repeat([]byte{' '}, n)...)
+			col += n
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRune(line[i:])
+		buf = append(buf, line[i:i+size]...)
+		col++
+		i += size
+	}
+	return buf
+}
+
+//
+// Column reached at the end of s, starting from column col.
+// A column is counted for each rune, tabs move to the next multiple of tab_width.
+//
+func tab_column(s []byte, col, tab_width int) int {
+	for i := 0; i < len(s); {
+		if s[i] == '\t' && tab_width > 0 {
+			col += tab_width - col%tab_width
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRune(s[i:])
+		col++
+		i += size
+	}
+	return col
+}
+
+//
+// Apply Options.Normalizers to every line, in order.
+// Return the same lines if there are no normalizers.
+//
+func (opts *Options) normalize_lines(lines [][]byte) [][]byte {
+
+	if len(opts.Normalizers) == 0 {
+		return lines
+	}
+
+	norm := make([][]byte, len(lines))
+	for i, line := range lines {
+		for _, normalize := range opts.Normalizers {
+			line = normalize(line)
+		}
+		norm[i] = line
+	}
+	return norm
+}
+
+// The unicode normalization form, or false if not used
+func (opts *Options) norm_form() (norm.Form, bool) {
+	switch opts.UnicodeNorm {
+	case NORM_NFC:
+		return norm.NFC, true
+	case NORM_NFKC:
+		return norm.NFKC, true
+	}
+	return norm.NFC, false
+}
+
+//
+// Compare and hash the lines in their normalized form.
+// Lines that are already normalized, ie. plain ascii, are used as they are without copying.
+//
+func (opts *Options) norm_line_funcs(compare_line func([]byte, []byte) bool, compute_hash func([]byte) uint32) (func([]byte, []byte) bool, func([]byte) uint32) {
+	form, ok := opts.norm_form()
+	if !ok {
+		return compare_line, compute_hash
+	}
+	return func(line1, line2 []byte) bool {
+			return compare_line(form.Bytes(line1), form.Bytes(line2))
+		}, func(line []byte) uint32 {
+			return compute_hash(form.Bytes(line))
+		}
+}
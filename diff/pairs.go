@@ -0,0 +1,112 @@
+package diff
+
+// Default Options.Similarity, lines less similar than this are not paired up
+const SIMILARITY_THRESHOLD = 0.5
+
+// Pair up lines by position when the modified block is larger than this (lines1 x lines2)
+const MAX_PAIR_LINES = 10000
+
+// A line in a modified block, paired with the most similar line in the other file.
+// Line1 or Line2 is -1 when the line is not paired, ie. it is removed or inserted.
+type LinePair struct {
+	Line1, Line2 int
+}
+
+//
+// How similar are two lines, from 0 (nothing in common) to 1 (identical).
+// Ratio of the unchanged runes to the total number of runes.
+//
+func similarity(cmp1, cmp2 []int, opts *Options) float64 {
+	if len(cmp1)+len(cmp2) == 0 {
+		return 1
+	}
+	change1, change2 := do_diff(cmp1, cmp2, ALGORITHM_MYERS, cost_limit(len(cmp1), len(cmp2), opts))
+	same := 0
+	for _, c := range change1 {
+		if !c {
+			same++
+		}
+	}
+	for _, c := range change2 {
+		if !c {
+			same++
+		}
+	}
+	return float64(same) / float64(len(cmp1)+len(cmp2))
+}
+
+//
+// Pair up the lines of a modified block (see DIFF_OP_MODIFY), so that each line is paired
+// with the most similar line in the other file, keeping the order of the lines.
+// Lines that are less similar than Options.Similarity are not paired.
+//
+func PairLines(lines1, lines2 [][]byte, opts *Options) []LinePair {
+
+	if opts == nil {
+		opts = NewOptions()
+	}
+
+	len1, len2 := len(lines1), len(lines2)
+	pairs := make([]LinePair, 0, len1+len2)
+
+	// block too big, pair up by position
+	if len1*len2 > MAX_PAIR_LINES {
+		for i := 0; i < len1 || i < len2; i++ {
+			switch {
+			case i >= len1:
+				pairs = append(pairs, LinePair{-1, i})
+			case i >= len2:
+				pairs = append(pairs, LinePair{i, -1})
+			default:
+				pairs = append(pairs, LinePair{i, i})
+			}
+		}
+		return pairs
+	}
+
+	cmp1, cmp2 := make([][]int, len1), make([][]int, len2)
+	for i, line := range lines1 {
+		_, cmp1[i] = split_runes(line, opts)
+	}
+	for i, line := range lines2 {
+		_, cmp2[i] = split_runes(line, opts)
+	}
+
+	// score[i][j] is the best total similarity of lines1[i:] and lines2[j:]
+	score := make([][]float64, len1+1)
+	sim := make([][]float64, len1)
+	for i := range score {
+		score[i] = make([]float64, len2+1)
+	}
+	for i := len1 - 1; i >= 0; i-- {
+		sim[i] = make([]float64, len2)
+		for j := len2 - 1; j >= 0; j-- {
+			best := score[i+1][j]
+			if score[i][j+1] > best {
+				best = score[i][j+1]
+			}
+			sim[i][j] = similarity(cmp1[i], cmp2[j], opts)
+			if sim[i][j] >= opts.Similarity && score[i+1][j+1]+sim[i][j] > best {
+				best = score[i+1][j+1] + sim[i][j]
+			}
+			score[i][j] = best
+		}
+	}
+
+	// follow the best path, removed lines are placed before inserted lines
+	i, j := 0, 0
+	for i < len1 || j < len2 {
+		switch {
+		case i < len1 && j < len2 && sim[i][j] >= opts.Similarity && score[i][j] == score[i+1][j+1]+sim[i][j]:
+			pairs = append(pairs, LinePair{i, j})
+			i, j = i+1, j+1
+		case i < len1 && score[i][j] == score[i+1][j]:
+			pairs = append(pairs, LinePair{i, -1})
+			i++
+		default:
+			pairs = append(pairs, LinePair{-1, j})
+			j++
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,384 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rsrini7/godiff/utils"
+)
+
+// Default number of context lines that may be ignored when a hunk does not match
+const PATCH_FUZZ = 2
+
+// Name used in a patch for a file that does not exist
+const DEV_NULL = "/dev/null"
+
+// One hunk of a unified diff
+type PatchHunk struct {
+	Start1, Count1         int      // from the hunk header, Start1 is the line number in file1 as written
+	Start2, Count2         int      // from the hunk header, Start2 is the line number in file2 as written
+	Body                   [][]byte // lines of the hunk, starting with ' ', '-' or '+'
+	NoNewline1, NoNewline2 bool     // "\ No newline at end of file" found after the last line of file1 or file2
+}
+
+// Changes to one file in a unified diff
+type FilePatch struct {
+	Name1, Name2 string // from the "---" and "+++" lines, without the time stamp
+	Hunks        []*PatchHunk
+}
+
+// Options to control how a patch is applied
+type ApplyOptions struct {
+	Reverse bool // undo the patch, ie. apply it from file2 to file1
+	Fuzz    int  // number of context lines at the start and end of a hunk that may be ignored
+}
+
+// Where a hunk has been applied
+type HunkResult struct {
+	Applied bool
+	Line    int // line number in the original file where the hunk was found, or expected to be
+	Offset  int // number of lines away from where the hunk header says it should be
+	Fuzz    int // number of context lines ignored
+}
+
+//
+// Parse a unified diff, in the format written by "godiff -txt -u", "diff -u" or "git diff".
+// godiff prefix each line with 2 chars ("  ", "- ", "+ "), other tools use 1 char,
+// both are accepted. Lines outside of the hunks are ignored, as well as lines
+// starting with '\' within the hunks, apart from "\ No newline at end of file".
+//
+func ParsePatch(data []byte) ([]*FilePatch, error) {
+
+	var patches []*FilePatch
+	var fp *FilePatch
+
+	lines := SplitLines(data)
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case bytes.HasPrefix(line, []byte("--- ")) && i+1 < len(lines) && bytes.HasPrefix(lines[i+1], []byte("+++ ")):
+			fp = &FilePatch{Name1: patch_file_name(line[4:]), Name2: patch_file_name(lines[i+1][4:])}
+			patches = append(patches, fp)
+			i++
+
+		case bytes.HasPrefix(line, []byte("@@ -")):
+			if fp == nil {
+				return nil, fmt.Errorf("line %d: hunk without file names", i+1)
+			}
+			hunk, err := parse_hunk_header(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+			}
+
+			// read the body, until the line counts in the header are used up
+			n1, n2 := 0, 0
+			for i+1 < len(lines) && (n1 < hunk.Count1 || n2 < hunk.Count2 || (len(lines[i+1]) > 0 && lines[i+1][0] == '\\')) {
+				i++
+				line = lines[i]
+				op := byte(' ')
+				if len(line) > 0 {
+					op = line[0]
+				}
+				switch op {
+				case ' ':
+					n1, n2 = n1+1, n2+1
+				case '-':
+					n1++
+				case '+':
+					n2++
+				case '\\':
+					if bytes.Contains(line, []byte("No newline")) && len(hunk.Body) > 0 {
+						switch hunk.Body[len(hunk.Body)-1][0] {
+						case '-':
+							hunk.NoNewline1 = true
+						case '+':
+							hunk.NoNewline2 = true
+						default:
+							hunk.NoNewline1, hunk.NoNewline2 = true, true
+						}
+					}
+					continue
+				default:
+					return nil, fmt.Errorf("line %d: unexpected line in hunk", i+1)
+				}
+				if len(line) == 0 {
+					// empty context line, trailing space removed by some editors
+					line = []byte(" ")
+				}
+				hunk.Body = append(hunk.Body, line)
+			}
+
+			if n1 != hunk.Count1 || n2 != hunk.Count2 {
+				return nil, fmt.Errorf("line %d: hunk is too short", i+1)
+			}
+			fp.Hunks = append(fp.Hunks, hunk)
+		}
+	}
+
+	return patches, nil
+}
+
+// file name in the "---" and "+++" lines, with the time stamp removed
+func patch_file_name(s []byte) string {
+	name := string(s)
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSpace(name)
+}
+
+// parse "@@ -start1,count1 +start2,count2 @@", anything after the second "@@" is ignored
+func parse_hunk_header(line []byte) (*PatchHunk, error) {
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 4 || fields[3] != "@@" || fields[1][0] != '-' || fields[2][0] != '+' {
+		return nil, fmt.Errorf("invalid hunk header: %s", line)
+	}
+
+	hunk := &PatchHunk{}
+	var err1, err2 error
+	hunk.Start1, hunk.Count1, err1 = parse_hunk_range(fields[1][1:])
+	hunk.Start2, hunk.Count2, err2 = parse_hunk_range(fields[2][1:])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid hunk header: %s", line)
+	}
+	return hunk, nil
+}
+
+// parse "start,count" or "start", count is 1 if not given
+func parse_hunk_range(s string) (int, int, error) {
+	count := 1
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		n, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return 0, 0, err
+		}
+		count, s = n, s[:i]
+	}
+	start, err := strconv.Atoi(s)
+	return start, count, err
+}
+
+// Swap file1 and file2, to undo the patch
+func (fp *FilePatch) Reverse() *FilePatch {
+	rp := &FilePatch{Name1: fp.Name2, Name2: fp.Name1}
+	for _, h := range fp.Hunks {
+		rh := &PatchHunk{
+			Start1: h.Start2, Count1: h.Count2,
+			Start2: h.Start1, Count2: h.Count1,
+			NoNewline1: h.NoNewline2, NoNewline2: h.NoNewline1,
+		}
+		for _, line := range h.Body {
+			r := append([]byte{}, line...)
+			switch r[0] {
+			case '-':
+				r[0] = '+'
+			case '+':
+				r[0] = '-'
+			}
+			rh.Body = append(rh.Body, r)
+		}
+		rp.Hunks = append(rp.Hunks, rh)
+	}
+	return rp
+}
+
+// Write the hunk in unified diff format, ie. to a reject file
+func (h *PatchHunk) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", h.Start1, h.Count1, h.Start2, h.Count2)
+	for i, line := range h.Body {
+		buf.Write(line)
+		buf.WriteByte('\n')
+		if i == len(h.Body)-1 && (h.NoNewline1 || h.NoNewline2) {
+			buf.WriteString("\\ No newline at end of file\n")
+		}
+	}
+	return buf.String()
+}
+
+//
+// Split the hunk into the lines of file1 and file2, with prefix_len chars removed
+// from each line. Also return the number of context lines at the start and end,
+// and for each line of file2, the index of the same context line in file1 or -1 if added.
+//
+func (h *PatchHunk) split(prefix_len int) (old, new [][]byte, context []int, lead, trail int, ok bool) {
+
+	leading := true
+	for _, line := range h.Body {
+		if len(line) < prefix_len || (prefix_len == 2 && line[1] != ' ') {
+			return nil, nil, nil, 0, 0, false
+		}
+		text := line[prefix_len:]
+		switch line[0] {
+		case ' ':
+			context = append(context, len(old))
+			old, new = append(old, text), append(new, text)
+			if leading {
+				lead++
+			}
+			trail++
+		case '-':
+			old = append(old, text)
+			leading, trail = false, 0
+		case '+':
+			context = append(context, -1)
+			new = append(new, text)
+			leading, trail = false, 0
+		}
+	}
+	if leading {
+		// context lines only
+		trail = 0
+	}
+	return old, new, context, lead, trail, true
+}
+
+//
+// Apply the changes in the patch to the content of a file.
+// Each hunk is searched for near the line given in the hunk header, adjusted by the offset
+// of the previous hunk. If not found, up to opts.Fuzz lines of context at the start and end of
+// the hunk are ignored. Line endings (unix or dos) of the file are kept: each line of the file keeps its own,
+// the lines added use the first line ending of the file.
+// Return the patched content and the result of every hunk, the hunks that failed are not applied.
+//
+func ApplyPatch(data []byte, fp *FilePatch, opts *ApplyOptions) ([]byte, []HunkResult) {
+
+	if opts == nil {
+		opts = &ApplyOptions{Fuzz: PATCH_FUZZ}
+	}
+	if opts.Reverse {
+		fp = fp.Reverse()
+	}
+
+	lines, eols := SplitLinesEOL(data)
+	eol := EOL_LF
+	for _, e := range eols {
+		if e != EOL_NONE {
+			eol = e
+			break
+		}
+	}
+	no_newline := len(eols) > 0 && eols[len(eols)-1] == EOL_NONE
+
+	results := make([]HunkResult, len(fp.Hunks))
+	result := make([][]byte, 0, len(lines))
+	result_eols := make([]int, 0, len(lines))
+	pos, offset := 0, 0
+
+	for n, h := range fp.Hunks {
+
+		// godiff format first, it is more specific
+		var prefixes []int
+		if _, _, _, _, _, ok := h.split(2); ok {
+			prefixes = append(prefixes, 2)
+		}
+		prefixes = append(prefixes, 1)
+
+		// header line number, as a 0 based index into lines
+		expect := h.Start1 - 1
+		if h.Count1 == 0 {
+			expect = h.Start1
+		}
+		res := HunkResult{Line: expect + offset + 1}
+
+	search:
+		for fuzz := 0; fuzz <= opts.Fuzz; fuzz++ {
+			for _, prefix_len := range prefixes {
+				old, new, context, lead, trail, _ := h.split(prefix_len)
+
+				start := expect
+				if prefix_len == 2 && h.Count1 == 0 {
+					// godiff writes the line after the insertion point
+					start = h.Start1 - 1
+				}
+
+				// ignore some of the context lines
+				cut1, cut2 := utils.MinInt(fuzz, lead), utils.MinInt(fuzz, trail)
+				if fuzz > 0 && cut1+cut2 == 0 {
+					continue
+				}
+				old, new, context = old[cut1:len(old)-cut2], new[cut1:len(new)-cut2], context[cut1:len(context)-cut2]
+				start += cut1
+
+				at := find_lines(lines, old, pos, start+offset)
+				if at < 0 {
+					continue
+				}
+
+				result = append(result, lines[pos:at]...)
+				result_eols = append(result_eols, eols[pos:at]...)
+				result = append(result, new...)
+				for _, c := range context {
+					if c >= 0 {
+						result_eols = append(result_eols, eols[at+c-cut1])
+					} else {
+						result_eols = append(result_eols, eol)
+					}
+				}
+				pos = at + len(old)
+				offset = at - start
+				res = HunkResult{Applied: true, Line: at - cut1 + 1, Offset: at - start, Fuzz: fuzz}
+
+				if pos == len(lines) && cut2 == 0 {
+					no_newline = h.NoNewline2
+				}
+				break search
+			}
+		}
+		results[n] = res
+	}
+	result = append(result, lines[pos:]...)
+	result_eols = append(result_eols, eols[pos:]...)
+
+	var buf bytes.Buffer
+	for i, line := range result {
+		buf.Write(line)
+		switch {
+		case i == len(result)-1 && no_newline:
+		case result_eols[i] == EOL_NONE:
+			buf.Write(eol_bytes[eol])
+		default:
+			buf.Write(eol_bytes[result_eols[i]])
+		}
+	}
+	return buf.Bytes(), results
+}
+
+//
+// Find the lines in file, starting from line 'from', searching outward from line 'near'.
+// Return the index of the first line, or -1 if not found.
+//
+func find_lines(file, lines [][]byte, from, near int) int {
+
+	last := len(file) - len(lines)
+	if near < from {
+		near = from
+	} else if near > last {
+		near = last
+	}
+
+	match := func(at int) bool {
+		for i, line := range lines {
+			if !bytes.Equal(file[at+i], line) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for d := 0; near-d >= from || near+d <= last; d++ {
+		if near+d <= last && near+d >= from && match(near+d) {
+			return near + d
+		}
+		if d > 0 && near-d >= from && near-d <= last && match(near-d) {
+			return near - d
+		}
+	}
+	return -1
+}
+
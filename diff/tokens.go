@@ -0,0 +1,147 @@
+package diff
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"github.com/rsrini7/godiff/utils"
+)
+
+// How lines are split up for comparing the changes within a line
+const (
+	TOKENIZE_RUNE  = 0 // compare each rune
+	TOKENIZE_WORD  = 1 // compare identifiers, numbers, punctuation and runs of white space
+	TOKENIZE_SPACE = 2 // compare white space separated tokens
+)
+
+//
+// Split a pair of lines into tokens. Identical tokens in both lines are given the same id.
+//
+type tokenizer struct {
+	opts  *Options
+	ids   map[string]int
+	first []rune // first rune of each token, indexed by id. Use for scoring the boundaries.
+}
+
+func new_tokenizer(opts *Options) *tokenizer {
+	return &tokenizer{
+		opts:  opts,
+		ids:   make(map[string]int),
+		first: make([]rune, 0, 32),
+	}
+}
+
+// is this rune part of an identifier, including the combining marks after a letter
+func is_ident_rune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// is this rune part of a number, allow for hex, decimal point and exponent
+func is_number_rune(r rune) bool {
+	return r == '.' || is_ident_rune(r)
+}
+
+//
+// Find the end of the token that starts at s[i]
+//
+func (tk *tokenizer) token_end(s []byte, i int) int {
+	r, size := utf8.DecodeRune(s[i:])
+	space := unicode.IsSpace(r)
+
+	var same_token func(rune) bool
+	switch {
+	case tk.opts.Tokenizer == TOKENIZE_SPACE:
+		same_token = func(c rune) bool { return unicode.IsSpace(c) == space }
+	case space:
+		same_token = unicode.IsSpace
+	case r == '_' || unicode.IsLetter(r):
+		same_token = is_ident_rune
+	case unicode.IsDigit(r):
+		same_token = is_number_rune
+	default:
+		// punctuation or symbols, one grapheme cluster per token, ie. an emoji with its modifiers
+		cluster, _, _, _ := uniseg.FirstGraphemeCluster(s[i:], -1)
+		return i + len(cluster)
+	}
+
+	for i += size; i < len(s); i += size {
+		r, size = utf8.DecodeRune(s[i:])
+		if !same_token(r) {
+			break
+		}
+	}
+	return i
+}
+
+//
+// split text into array of token position, and another array of token ids for comparison.
+// White space and unicode normalization are handled in the same way as split_runes().
+//
+func (tk *tokenizer) split(s []byte) ([]int, []int) {
+
+	pos := make([]int, 0, len(s)/4+1)
+	cmp := make([]int, 0, len(s)/4)
+
+	end := len(s)
+	if tk.opts.IgnoreTrailingSpace {
+		end = tk.opts.trailing_space(s)
+	}
+	tab_width := 0
+	if tk.opts.IgnoreTabExpansion {
+		tab_width = tk.opts.tab_width()
+	}
+
+	col := 0
+	for i := 0; i < end; {
+		token_end := tk.token_end(s[:end], i)
+
+		key := string(s[i:token_end])
+		if form, ok := tk.opts.norm_form(); ok {
+			key = form.String(key)
+		}
+		if tk.opts.IgnoreCase {
+			key = tk.to_lower(key)
+		}
+		if tab_width > 0 {
+			next_col := tab_column(s[i:token_end], col, tab_width)
+			if strings.Trim(key, " \t") == "" {
+				// compare the width of the spaces and tabs
+				key = strings.Repeat(" ", next_col-col)
+			}
+			col = next_col
+		}
+		id, found := tk.ids[key]
+		if !found {
+			id = len(tk.first)
+			tk.ids[key] = id
+			r, _ := utf8.DecodeRuneInString(key)
+			tk.first = append(tk.first, r)
+		}
+
+		pos = append(pos, i)
+		cmp = append(cmp, id)
+		i = token_end
+	}
+	pos = append(pos, len(s))
+	return pos, cmp
+}
+
+// convert to lower case, use unicode rules only when asked for
+func (tk *tokenizer) to_lower(s string) string {
+	if tk.opts.Unicode {
+		return strings.ToLower(s)
+	}
+	b := []byte(s)
+	for i, c := range b {
+		b[i] = utils.ToLowerByte(c)
+	}
+	return string(b)
+}
+
+// scoring token boundary, same as rune_bouundary_score() using the first rune of the tokens
+func (tk *tokenizer) boundary_score(id1, id2 int) int {
+	return rune_bouundary_score(int(tk.first[id1]), int(tk.first[id2]))
+}
+
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+
+	"github.com/rsrini7/godiff/utils"
+)
+
+// Name of the encoding of plain utf-8 (or ascii) files, not shown in the file header
+const ENCODING_UTF8 = "UTF-8"
+
+// A text encoding that files are converted from
+type TextEncoding struct {
+	name    string
+	aliases []string          // other names accepted by -encoding, lower case without '-' or '_'
+	enc     encoding.Encoding // nil for utf-8, no conversion needed
+	bom     []byte            // byte order mark
+}
+
+// Encodings that are detected or can be given with -encoding. BOMs are checked in this order.
+var text_encodings = []*TextEncoding{
+	{"UTF-32LE", []string{"utf32le"}, utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM), []byte{0xFF, 0xFE, 0, 0}},
+	{"UTF-32BE", []string{"utf32be", "utf32"}, utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM), []byte{0, 0, 0xFE, 0xFF}},
+	{"UTF-16LE", []string{"utf16le", "utf16", "ucs2"}, unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), []byte{0xFF, 0xFE}},
+	{"UTF-16BE", []string{"utf16be"}, unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), []byte{0xFE, 0xFF}},
+	{ENCODING_UTF8, []string{"utf8", "ascii"}, nil, []byte{0xEF, 0xBB, 0xBF}},
+	{"Windows-1252", []string{"windows1252", "cp1252"}, charmap.Windows1252, nil},
+	{"ISO-8859-1", []string{"iso88591", "latin1"}, charmap.ISO8859_1, nil},
+}
+
+//
+// Find an encoding by name, ie. "utf-16le", "latin1". Return nil if not supported.
+//
+func find_text_encoding(name string) *TextEncoding {
+	key := strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+	for _, te := range text_encodings {
+		if strings.EqualFold(te.name, name) {
+			return te
+		}
+		for _, alias := range te.aliases {
+			if alias == key {
+				return te
+			}
+		}
+	}
+	return nil
+}
+
+// Names of the supported encodings, for the usage message
+func text_encoding_names() string {
+	var names []string
+	for _, te := range text_encodings {
+		names = append(names, te.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+//
+// Detect the encoding of a file: from its byte order mark, the pattern of zero bytes
+// of UTF-16 and UTF-32 text without a BOM, or Windows-1252/ISO-8859-1 if it is not valid utf-8.
+// Also return the size of the BOM.
+//
+func detect_text_encoding(data []byte) (*TextEncoding, int) {
+
+	for _, te := range text_encodings {
+		if len(te.bom) > 0 && bytes.HasPrefix(data, te.bom) {
+			return te, len(te.bom)
+		}
+	}
+
+	// count the zero bytes at each position of 4 byte groups
+	sample := data[:utils.MinInt(len(data), BINARY_CHECK_SIZE)&^3]
+	var zeros [4]int
+	for i, b := range sample {
+		if b == 0 {
+			zeros[i&3]++
+		}
+	}
+	n := len(sample) / 4
+	switch {
+	case n == 0:
+	case zeros[0] == 0 && zeros[2] == n && zeros[3] == n:
+		return find_text_encoding("UTF-32LE"), 0
+	case zeros[3] == 0 && zeros[0] == n && zeros[1] == n:
+		return find_text_encoding("UTF-32BE"), 0
+	case zeros[0] == 0 && zeros[2] == 0 && zeros[1]+zeros[3] >= n:
+		// mostly ascii text, with a zero in the high byte
+		return find_text_encoding("UTF-16LE"), 0
+	case zeros[1] == 0 && zeros[3] == 0 && zeros[0]+zeros[2] >= n:
+		return find_text_encoding("UTF-16BE"), 0
+	}
+
+	if bytes.IndexByte(sample, 0) >= 0 || utf8.Valid(data) {
+		// binary file, or utf-8
+		return find_text_encoding(ENCODING_UTF8), 0
+	}
+
+	// 0x80 to 0x9F are printable in Windows-1252, apart from a few unused codes
+	latin1 := true
+	for _, b := range data {
+		if b >= 0x80 && b <= 0x9F {
+			if b == 0x81 || b == 0x8D || b == 0x8F || b == 0x90 || b == 0x9D {
+				return find_text_encoding("ISO-8859-1"), 0
+			}
+			latin1 = false
+		}
+	}
+	if latin1 {
+		return find_text_encoding("ISO-8859-1"), 0
+	}
+	return find_text_encoding("Windows-1252"), 0
+}
+
+//
+// Report if data decoded to utf-8 looks like text: no zero, few control characters
+// and few invalid characters (U+FFFD) in the first BINARY_CHECK_SIZE bytes.
+// Binary data decoded as Windows-1252 or UTF-16 fails this check.
+//
+func is_text(data []byte) bool {
+
+	sample := data[:utils.MinInt(len(data), BINARY_CHECK_SIZE)]
+	runes, bad := 0, 0
+	for len(sample) > 0 {
+		r, size := utf8.DecodeRune(sample)
+		sample = sample[size:]
+		runes++
+		switch {
+		case r == 0:
+			return false
+		case r == utf8.RuneError, r == 0x7F:
+			bad++
+		case r < ' ' && !strings.ContainsRune("\t\n\v\f\r\b\x1b", r):
+			bad++
+		}
+	}
+	return bad*100 <= runes
+}
+
+//
+// Encoding given with -encoding for a file, or nil to detect it.
+//
+func encoding_for_file(fname string) *TextEncoding {
+	var te *TextEncoding
+	for _, fo := range file_options {
+		if fo.encoding != nil && fo.match(fname) {
+			te = fo.encoding
+		}
+	}
+	return te
+}
+
+//
+// Convert the file content to utf-8, and remember the encoding: -encoding, or detected.
+// A byte order mark is removed. The data are not converted if the result does not look
+// like text, the file is then binary. The original bytes are kept in file.raw.
+//
+func (file *Filedata) decode_text() {
+
+	if file.data == nil {
+		return
+	}
+	file.raw = file.data
+
+	te, bom := encoding_for_file(file.name), 0
+	if te == nil {
+		te, bom = detect_text_encoding(file.data)
+	} else if len(te.bom) > 0 && bytes.HasPrefix(file.data, te.bom) {
+		bom = len(te.bom)
+	}
+
+	file.encoding = te.name
+	if te.enc == nil && bom == 0 {
+		return
+	}
+
+	var data []byte
+	if te.enc == nil {
+		data = file.data[bom:]
+	} else {
+		var err error
+		data, err = te.enc.NewDecoder().Bytes(file.data[bom:])
+		if err != nil {
+			file.errormsg = err.Error()
+			return
+		}
+		if !is_text(data) {
+			// binary data, ie. starting with FF FE or not valid utf-8
+			file.encoding = ENCODING_UTF8
+			file.is_binary = true
+			return
+		}
+	}
+
+	if bom > 0 {
+		file.encoding += " BOM"
+		file.bom = file.raw[:bom]
+	}
+	file.text_encoding = te
+	file.data = data
+}
+
+//
+// Convert utf-8 data back to the encoding of the file, with its byte order mark,
+// ie. to write a merged file in the encoding of the files merged.
+//
+func (file *Filedata) encode_text(data []byte) ([]byte, error) {
+
+	if file.text_encoding != nil && file.text_encoding.enc != nil {
+		var err error
+		data, err = file.text_encoding.enc.NewEncoder().Bytes(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(file.bom) == 0 {
+		return data, nil
+	}
+	return append(append([]byte(nil), file.bom...), data...), nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rsrini7/godiff/diff"
+)
+
+// text encoded in utf-16 or utf-32, one rune per code unit
+func encode_units(s string, size int, big_endian bool) []byte {
+	var data []byte
+	for _, r := range s {
+		unit := make([]byte, size)
+		for i := 0; i < size; i++ {
+			shift := uint(8 * i)
+			if big_endian {
+				shift = uint(8 * (size - 1 - i))
+			}
+			unit[i] = byte(r >> shift)
+		}
+		data = append(data, unit...)
+	}
+	return data
+}
+
+// random bytes without any zero
+func random_bytes(n int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(1 + rnd.Intn(255))
+	}
+	return data
+}
+
+func TestDetectTextEncoding(t *testing.T) {
+
+	text := "Hello, world\nline 2\n"
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		bom  int
+	}{
+		{"ascii", []byte(text), ENCODING_UTF8, 0},
+		{"utf-8", []byte("café €\n"), ENCODING_UTF8, 0},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, text...), ENCODING_UTF8, 3},
+		{"utf-16le bom", append([]byte{0xFF, 0xFE}, encode_units(text, 2, false)...), "UTF-16LE", 2},
+		{"utf-16be bom", append([]byte{0xFE, 0xFF}, encode_units(text, 2, true)...), "UTF-16BE", 2},
+		{"utf-16le", encode_units(text, 2, false), "UTF-16LE", 0},
+		{"utf-16be", encode_units(text, 2, true), "UTF-16BE", 0},
+		{"utf-32le bom", append([]byte{0xFF, 0xFE, 0, 0}, encode_units(text, 4, false)...), "UTF-32LE", 4},
+		{"utf-32be", encode_units(text, 4, true), "UTF-32BE", 0},
+		{"windows-1252", []byte("caf\xe9 \x80 \x93quoted\x94\n"), "Windows-1252", 0},
+		{"iso-8859-1", []byte("caf\xe9 na\xefve\n"), "ISO-8859-1", 0},
+		{"binary", []byte("\x7fELF\x02\x01\x01\x00\x00\x00\xff\xfe"), ENCODING_UTF8, 0},
+	}
+
+	for _, test := range tests {
+		te, bom := detect_text_encoding(test.data)
+		if te.name != test.want || bom != test.bom {
+			t.Errorf("%s: got %s %d, want %s %d", test.name, te.name, bom, test.want, test.bom)
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+		text     string // "" for a binary file, not converted
+	}{
+		{"utf-8 bom", []byte("\xef\xbb\xbfabc\n"), "UTF-8 BOM", "abc\n"},
+		{"utf-16le bom", append([]byte{0xFF, 0xFE}, encode_units("abc\n", 2, false)...), "UTF-16LE BOM", "abc\n"},
+		{"latin-1", []byte("caf\xe9\n"), "ISO-8859-1", "café\n"},
+		// not valid utf-8, no zero bytes: binary, not Windows-1252
+		{"random", random_bytes(1000, 1), ENCODING_UTF8, ""},
+		// binary starting like a UTF-16 BOM
+		{"bom binary", append([]byte{0xFF, 0xFE}, random_bytes(1000, 2)...), ENCODING_UTF8, ""},
+	}
+
+	for _, test := range tests {
+		file := &Filedata{name: "x.txt", data: test.data}
+		file.decode_text()
+		if file.encoding != test.encoding || file.errormsg != "" {
+			t.Errorf("%s: encoding %q %q, want %q", test.name, file.encoding, file.errormsg, test.encoding)
+		}
+		if !bytes.Equal(file.raw, test.data) {
+			t.Errorf("%s: the original bytes are not kept", test.name)
+		}
+		if test.text == "" {
+			if !file.is_binary || !bytes.Equal(file.data, test.data) {
+				t.Errorf("%s: expecting a binary file, not converted", test.name)
+			}
+		} else if string(file.data) != test.text || file.is_binary {
+			t.Errorf("%s: got %q, want %q", test.name, file.data, test.text)
+		}
+	}
+
+	// -encoding overrides the detection
+	defer func(fos []*FileOptions) { file_options = fos }(file_options)
+	file_options = []*FileOptions{{glob: "*.txt", encoding: find_text_encoding("Windows-1252")}}
+	file := &Filedata{name: "x.txt", data: []byte("caf\xe9\n")}
+	file.decode_text()
+	if file.encoding != "Windows-1252" || string(file.data) != "café\n" {
+		t.Errorf("-encoding: got %s %q", file.encoding, file.data)
+	}
+	file = &Filedata{name: "x.csv", data: []byte("caf\xe9\n")}
+	file.decode_text()
+	if file.encoding != "ISO-8859-1" {
+		t.Errorf("-encoding for another glob: got %s", file.encoding)
+	}
+
+	// converted back, ie. for a merged file
+	file = &Filedata{name: "x.csv", data: append([]byte{0xFF, 0xFE}, encode_units("café\n", 2, false)...)}
+	raw := file.data
+	file.decode_text()
+	if data, err := file.encode_text(file.data); err != nil || !bytes.Equal(data, raw) {
+		t.Errorf("encode_text: got %q %v, want %q", data, err, raw)
+	}
+}
+
+// Write files in a temporary directory, named "a" + ext, "b" + ext..., return their names
+func write_test_files(t *testing.T, ext string, contents ...[]byte) (string, []string) {
+	dir, err := ioutil.TempDir("", "godiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i, data := range contents {
+		name := filepath.Join(dir, string(rune('a'+i))+ext)
+		if err := ioutil.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return dir, names
+}
+
+// Run diff_file with the text output, and return the output
+func diff_file_text(t *testing.T, name1, name2 string) string {
+
+	defer func(w *bufio.Writer, text bool) { out, flag_output_as_text = w, text }(out, flag_output_as_text)
+
+	var buf bytes.Buffer
+	out = bufio.NewWriter(&buf)
+	flag_output_as_text = true
+	if diff_options == nil {
+		diff_options = diff.NewOptions()
+	}
+
+	finfo1, err1 := os.Stat(name1)
+	finfo2, err2 := os.Stat(name2)
+	if err1 != nil || err2 != nil {
+		t.Fatal(err1, err2)
+	}
+	diff_file(name1, name2, finfo1, finfo2)
+	out.Flush()
+	return buf.String()
+}
+
+func TestDiffFileEncodingOnly(t *testing.T) {
+
+	text := "café\nline 2\n"
+	dir, names := write_test_files(t, ".txt", []byte(text), append([]byte{0xFF, 0xFE}, encode_units(text, 2, false)...))
+	defer os.RemoveAll(dir)
+
+	got := diff_file_text(t, names[0], names[1])
+	if !strings.Contains(got, "Only the encoding differs: UTF-8") || !strings.Contains(got, "Only the encoding differs: UTF-16LE BOM") {
+		t.Errorf("got %q, want the encoding only message", got)
+	}
+}
@@ -45,11 +45,8 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/bzip2"
-	"compress/gzip"
 	"flag"
 	"fmt"
-	"hash/crc32"
 	"html"
 	"io/ioutil"
 	"os"
@@ -59,13 +56,13 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-	"unicode"
-	"unicode/utf8"
 
-	"github.com/rsrini7/go-csv"
+	"github.com/rsrini7/godiff/diff"
 	"github.com/rsrini7/godiff/utils"
 )
 
@@ -79,9 +76,6 @@ const (
 	// Output buffer size
 	OUTPUT_BUF_SIZE = 65536
 
-	// default number of context lines to display
-	CONTEXT_LINES = 3
-
 	// convenient shortcut
 	PATH_SEPARATOR = string(os.PathSeparator)
 
@@ -104,17 +98,41 @@ const (
 	MSG_FILE_TOO_BIG     = "File too big"
 	MSG_THIS_IS_DIR      = "This is a directory"
 	MSG_THIS_IS_FILE     = "This is a file"
+	MSG_EOL_CHANGED      = "Line endings changed from %s to %s"
+	MSG_LINE_EOL         = "Line ends with %s"
+	MSG_NO_NEWLINE       = "No newline at end of file"
+	MSG_ENCODING_DIFFERS = "Only the encoding differs: %s"
+	MSG_BIN_FIRST_DIFF   = "First difference at offset %d (0x%x), %d bytes differ"
+	MSG_IMAGE_SAME       = "Images are the same, within the tolerance"
+	MSG_IMAGE_FORMAT     = "Image format changed from %s to %s"
+	MSG_IMAGE_SIZE       = "Image size changed from %s to %s"
+	MSG_IMAGE_COLORS     = "Colour model changed from %s to %s"
+	MSG_IMAGE_PIXELS     = "%.2f%% of pixels differ (%d pixels)"
+	MSG_CSV_ROWS         = "Rows added: %d, removed: %d, modified: %d"
+	MSG_CSV_COLS_ADDED   = "Columns added: %s"
+	MSG_CSV_COLS_REMOVED = "Columns removed: %s"
+	MSG_CSV_COLS_IGNORED = "Columns ignored: %s"
+	MSG_CSV_CELL         = "%s: %q -> %q"
+	MSG_CSV_DELIMITER    = "Delimiter changed from %q to %q"
+	MSG_CSV_COLUMNS      = "Rows of both files in the columns: %s"
+	MSG_CSV_DELTA_ERROR  = "Delta csv file not written: %s"
 )
 
 // file data
 type Filedata struct {
-	name      string
-	info      os.FileInfo
-	osfile    *os.File
-	errormsg  string
-	is_binary bool
-	is_mapped bool
-	data      []byte
+	name          string
+	info          os.FileInfo
+	osfile        *os.File
+	errormsg      string
+	is_binary     bool
+	is_mapped     bool
+	data          []byte
+	raw           []byte        // the data before decode_text(), for binary files
+	eols          []int         // line ending of each line, set by split_lines()
+	encoding      string        // encoding the data were converted from, see decode_text()
+	text_encoding *TextEncoding // nil if the data were not converted
+	bom           []byte        // byte order mark removed by decode_text()
+	conversions   []string      // decoders applied to the data, see decode()
 }
 
 // Output to diff as html or text format
@@ -122,33 +140,28 @@ type OutputFormat struct {
 	buf1, buf2           bytes.Buffer
 	name1, name2         string
 	fileinfo1, fileinfo2 os.FileInfo
+	label1, label2       string // shown in the header, see file_labels()
 	header_printed       bool
 	lineno_width         int
-	diffbuf              bytes.Buffer
-}
-
-const (
-	DIFF_OP_SAME   = 1
-	DIFF_OP_MODIFY = 2
-	DIFF_OP_INSERT = 3
-	DIFF_OP_REMOVE = 4
-)
-
-type DiffOp struct {
-	op           int
-	start1, end1 int
-	start2, end2 int
 }
 
-// Interface for report_diff() callbacks.
+// Interface for diff.Hunk callbacks.
 type DiffChanger interface {
-	diff_lines([]DiffOp)
+	diff_lines([]diff.DiffOp)
+	diff_message(msg string) // a message about the whole file, ie. diff.Result.EolChanged, before the hunks
+	diff_rows(res *utils.CsvDiff)
 }
 
 // Data use by DiffChanger
 type DiffChangerData struct {
 	*OutputFormat
 	file1, file2 [][]byte
+	opts         *diff.Options // options use to compare the files
+	moves        []diff.DiffOp // moved blocks, indexed by DiffOp.Move-1
+	seq          int           // unique number for this file compare, use in html anchors
+	eol_notes1   []string      // note shown after a line about its line ending, nil if none. See eol_notes()
+	eol_notes2   []string
+	comma        rune // delimiter of csv files, see diff_rows()
 }
 
 // changes to be output in Text format
@@ -188,7 +201,13 @@ const HTML_CSS = `<style type="text/css">
 .emp {color:black; font-size:75%; font-family:monospace; white-space:pre; margin:0; background-color:#E0E0E0; display:block;}
 .add {color:black; font-size:75%; font-family:monospace; white-space:pre; margin:0; background-color:#CFFFCF; display:block;}
 .del {color:black; font-size:75%; font-family:monospace; white-space:pre; margin:0; background-color:#FFCFCF; display:block;}
+.mov {color:black; font-size:75%; font-family:monospace; white-space:pre; margin:0; background-color:#FFE8B0; display:block;}
+.mov a {text-decoration:none;}
+.cfl {color:black; font-size:75%; font-family:monospace; white-space:pre; margin:0; background-color:#FFB060; display:block;}
 .chg {color:#C00080; background-color:#AFAFDF;}
+.ign {color:#A0A0A0;}
+.eol {color:#808080; font-style:italic; margin-left:1em;}
+.img {max-width:100%; border:1px dotted #808080; margin-top:4px;}
 </style>`
 
 const HTML_LEGEND = `<br><b>Legend:</b><br><table class="tab">
@@ -197,44 +216,92 @@ const HTML_LEGEND = `<br><b>Legend:</b><br><table class="tab">
 <span class="del"><span class="lno">1 </span>line deleted</span>
 <span class="nop"><span class="lno">2 </span>no change</span>
 <span class="upd"><span class="lno">3 </span>line modified</span>
+<span class="mov"><span class="lno">4 </span>line moved elsewhere</span>
 </td>
 <td class="ttd">
 <span class="add"><span class="lno">1 </span>line added</span>
 <span class="nop"><span class="lno">2 </span>no change</span>
 <span class="upd"><span class="lno">3 </span><span class="chg">L</span>ine <span class="chg">M</span>odified</span>
+<span class="mov"><span class="lno">4 </span>line moved from elsewhere</span>
 </td></tr>
 </table>
 `
 
 // command line arguments
 var (
-	flag_pprof_file              string
-	flag_version                 bool = false
-	flag_cmp_ignore_case         bool = false
-	flag_cmp_ignore_blank_lines  bool = false
-	flag_cmp_ignore_space_change bool = false
-	flag_cmp_ignore_all_space    bool = false
-	flag_unicode_case_and_space  bool = false
-	flag_show_identical_files    bool = false
-	flag_suppress_line_changes   bool = false
-	flag_suppress_missing_file   bool = false
-	flag_output_as_text          bool = false
-	flag_unified_context         bool = false
-	flag_context_lines           int  = CONTEXT_LINES
-	flag_exclude_files           string
-	flag_max_goroutines          = 1
-	flag_p_keys                  string
-	flag_html_output             string = "diff.html"
-	flag_txt_output              string = "diff.txt"
-	flag_csv_delta               string = "delta.csv"
-	flag_out_folder              string = "output-diff"
-	flag_timeit                  bool   = false
+	flag_pprof_file                string
+	flag_version                   bool = false
+	flag_cmp_ignore_case           bool = false
+	flag_cmp_ignore_blank_lines    bool = false
+	flag_cmp_ignore_space_change   bool = false
+	flag_cmp_ignore_all_space      bool = false
+	flag_cmp_ignore_trailing_space bool = false
+	flag_cmp_ignore_tab_expansion  bool = false
+	flag_cmp_ignore_eol            bool = false
+	flag_tab_width                 int  = diff.TAB_WIDTH
+	flag_unicode_case_and_space    bool = false
+	flag_unicode_norm              string
+	flag_show_identical_files      bool = false
+	flag_suppress_line_changes     bool = false
+	flag_suppress_missing_file     bool = false
+	flag_output_as_text            bool = false
+	flag_unified_context           bool = false
+	flag_context_lines             int  = diff.CONTEXT_LINES
+	flag_exclude_files             string
+	flag_max_goroutines            = 1
+	flag_p_keys                    string
+	flag_html_output               string  = "diff.html"
+	flag_txt_output                string  = "diff.txt"
+	flag_csv_delta                 string  = "delta.csv"
+	flag_csv_old_values            bool    = false
+	flag_csv_changed_columns       bool    = false
+	flag_csv_ignore_columns        string  = ""
+	flag_csv_only_columns          string  = ""
+	flag_out_folder                string  = "output-diff"
+	flag_timeit                    bool    = false
+	flag_algorithm                 string  = "myers"
+	flag_minimal                   bool    = false
+	flag_cost_limit                int     = 0
+	flag_detect_moves              bool    = false
+	flag_tokenizer                 string  = "rune"
+	flag_similarity                float64 = diff.SIMILARITY_THRESHOLD
+	flag_merge                     bool    = false
+	flag_merge_dir                 string  = "merged"
+	flag_ignore_regexps            StringList
+	flag_file_ignore_regexps       StringList
+	flag_normalizers               StringList
+	flag_encodings                 StringList
+	flag_hex_dump                  bool = false
+	flag_image_tolerance           int  = 0
+	flag_textconv                  StringList
 )
 
+// Command line flag that can be repeated, each value is added to the list
+type StringList []string
+
+func (s *StringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *StringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// Options that apply to files with names matching a glob pattern, ie. -Ifile
+type FileOptions struct {
+	glob           string
+	ignore_regexps []*regexp.Regexp
+	encoding       *TextEncoding // -encoding GLOB=ENC
+}
+
 // Job queue for goroutines
 type JobQueue struct {
 	name1, name2 string
 	info1, info2 os.FileInfo
+	name0        string      // three-way merge only: base file
+	info0        os.FileInfo // three-way merge only: base file
+	merge_name   string      // three-way merge only: write merged file to here
 }
 
 // Queue queue for goroutines diff_file
@@ -246,6 +313,15 @@ var (
 // Files/Dirs to excludes
 var regexp_exclude_files *regexp.Regexp
 
+// diff engine options, setup based on flags: -b -w -i etc.
+var diff_options *diff.Options
+
+// diff engine options for some of the files, setup based on flags: -Ifile
+var file_options []*FileOptions
+
+// Number of file compares so far, use to make html anchors unique
+var file_seq int32
+
 // Buffered stdout
 var (
 	out        *bufio.Writer
@@ -263,18 +339,8 @@ var (
 	html_entity_dquote = html.EscapeString("\"")
 )
 
-// functions to compare line and computer hash values,
-// these will be setup based on flags: -b -w -U etc.
-var (
-	compare_line func([]byte, []byte) bool
-	compute_hash func([]byte) uint32
-)
-
-var blank_line = make([]byte, 0)
-
 var (
-	csvHeaderData []string
-	csvDelimiter  string
+	csvDeltaRoot string // the second directory compared, see csv_delta_name()
 )
 
 func version() {
@@ -288,6 +354,8 @@ func usage(msg string) {
 	}
 	fmt.Fprint(os.Stderr, "A text file comparison tool displaying differenes in HTML\n\n")
 	fmt.Fprint(os.Stderr, "usage: godiff <options> <file|dir> <file|dir>\n")
+	fmt.Fprint(os.Stderr, "       godiff apply <options> <patch> [<file|dir>]\n")
+	fmt.Fprint(os.Stderr, "       godiff csv-apply <options> -key <columns> <base.csv> <delta.csv>\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -299,6 +367,16 @@ func usage0() {
 // Main routine.
 func main() {
 
+	// sub-commands
+	if len(os.Args) > 1 && os.Args[1] == "apply" {
+		apply_main(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "csv-apply" {
+		csv_apply_main(os.Args[2:])
+		return
+	}
+
 	// setup command line options
 	flag.Usage = usage0
 	flag.StringVar(&flag_pprof_file, "prof", "", "Write pprof output to file")
@@ -308,19 +386,43 @@ func main() {
 	flag.IntVar(&flag_max_goroutines, "g", flag_max_goroutines, "Max number of goroutines to use for file comparison")
 	flag.BoolVar(&flag_cmp_ignore_space_change, "b", flag_cmp_ignore_space_change, "Ignore changes in the amount of white space")
 	flag.BoolVar(&flag_cmp_ignore_all_space, "w", flag_cmp_ignore_all_space, "Ignore all white space")
+	flag.BoolVar(&flag_cmp_ignore_trailing_space, "ignore-trailing-space", flag_cmp_ignore_trailing_space, "Ignore white space at line end")
+	flag.BoolVar(&flag_cmp_ignore_tab_expansion, "ignore-tab-expansion", flag_cmp_ignore_tab_expansion, "Ignore changes due to tab expansion")
+	flag.BoolVar(&flag_cmp_ignore_eol, "ignore-eol", flag_cmp_ignore_eol, "Ignore changes in line endings (LF, CRLF, CR) and a missing newline at end of file")
+	flag.IntVar(&flag_tab_width, "tab-width", flag_tab_width, "Tab stops every N columns, for -ignore-tab-expansion")
 	flag.BoolVar(&flag_cmp_ignore_case, "i", flag_cmp_ignore_case, "Ignore case differences in file contents")
 	flag.BoolVar(&flag_cmp_ignore_blank_lines, "B", flag_cmp_ignore_blank_lines, "Ignore changes whose lines are all blank")
+	flag.Var(&flag_ignore_regexps, "I", "Ignore changes whose lines all match this `regexp`, may be repeated")
+	flag.Var(&flag_file_ignore_regexps, "Ifile", "Same as -I, for files with names matching a glob pattern: `GLOB=REGEXP`, may be repeated")
+	flag.Var(&flag_normalizers, "norm", "Rewrite lines before comparing them, may be repeated and applied in order: trim, tabs[:N], uuid, ipv4, timestamp or s/REGEXP/REPLACEMENT/")
+	flag.Var(&flag_textconv, "textconv", "Compare the output of a command, given the file content on standard input: `GLOB=COMMAND`, ie. '*.json=jq .', may be repeated")
+	flag.Var(&flag_encodings, "encoding", "Read files with this encoding instead of detecting it, `[GLOB=]ENCODING`, may be repeated: "+text_encoding_names())
 	flag.BoolVar(&flag_unicode_case_and_space, "unicode", flag_unicode_case_and_space, "Apply unicode rules for white space and upper/lower case")
+	flag.StringVar(&flag_unicode_norm, "unicode-norm", flag_unicode_norm, "Compare lines after unicode normalization: nfc (canonical), nfkc (compatibility)")
 	flag.BoolVar(&flag_show_identical_files, "s", flag_show_identical_files, "Report when two files are the identical")
 	flag.BoolVar(&flag_suppress_line_changes, "l", flag_suppress_line_changes, "Do not display changes within lines")
+	flag.StringVar(&flag_tokenizer, "tokens", flag_tokenizer, "Show changes within lines by: rune, word, space (white space separated tokens)")
+	flag.Float64Var(&flag_similarity, "similarity", flag_similarity, "Show modified lines less similar than this (0 to 1) as removed and inserted")
+	flag.BoolVar(&flag_hex_dump, "hex", flag_hex_dump, "Show the differences of binary files in a hex dump, 16 bytes per row")
+	flag.IntVar(&flag_image_tolerance, "image-tolerance", flag_image_tolerance, "Pixels of images are the same if their colours differ by at most N (0 to 255), ie. for images saved again with lossy compression")
 	flag.BoolVar(&flag_suppress_missing_file, "m", flag_suppress_missing_file, "Do not show content if corresponding file is missing")
 	flag.BoolVar(&flag_unified_context, "u", flag_unified_context, "Unified context")
 	flag.BoolVar(&flag_output_as_text, "txt", flag_output_as_text, "Output using 'diff' text format instead of HTML")
+	flag.StringVar(&flag_algorithm, "algorithm", flag_algorithm, "Diff algorithm to use: myers, patience, histogram")
+	flag.BoolVar(&flag_minimal, "minimal", flag_minimal, "Always find the minimal differences, even on large and very different files")
+	flag.IntVar(&flag_cost_limit, "cost", flag_cost_limit, "Settle for a non-minimal result after N steps, 0 to decide from the file size")
+	flag.BoolVar(&flag_detect_moves, "moves", flag_detect_moves, "Detect and show blocks of lines that have been moved")
 	flag.StringVar(&flag_txt_output, "n", flag_txt_output, "Generate given txt diff file")
+	flag.BoolVar(&flag_merge, "merge", flag_merge, "Three-way compare and merge: godiff -merge <base> <left> <right>")
+	flag.StringVar(&flag_merge_dir, "merge-dir", flag_merge_dir, "Write the merged files in this folder, under -diff-dir")
 
 	flag.StringVar(&flag_p_keys, "key", "", "The Primary Key Columns")
 	flag.StringVar(&flag_html_output, "html", flag_html_output, "Generate HTML diff file")
-	flag.StringVar(&flag_csv_delta, "csv", flag_csv_delta, "Generate CSV delta file")
+	flag.StringVar(&flag_csv_delta, "csv", flag_csv_delta, "Generate CSV delta file, in a folder with the same name for directories. Empty for none")
+	flag.BoolVar(&flag_csv_old_values, "csv-old", flag_csv_old_values, "Add the old values of the modified rows to the CSV delta file, in columns named old_COLUMN")
+	flag.BoolVar(&flag_csv_changed_columns, "csv-changed", flag_csv_changed_columns, "Add the names of the changed columns of the modified rows to the CSV delta file")
+	flag.StringVar(&flag_csv_ignore_columns, "csv-ignore", flag_csv_ignore_columns, "Do not compare these columns of CSV files, comma separated names, ie. load_ts,batch_id")
+	flag.StringVar(&flag_csv_only_columns, "csv-only", flag_csv_only_columns, "Compare only these columns of CSV files, and the key columns, comma separated names")
 	flag.StringVar(&flag_out_folder, "diff-dir", flag_out_folder, "Generate diff files in the specified folder")
 	flag.BoolVar(&flag_timeit, "timeit", flag_timeit, "Measure time and print")
 
@@ -380,22 +482,135 @@ func main() {
 		outputFile.Close()
 	}()
 
-	// choose which compare and hash function to use
-	if flag_cmp_ignore_case || flag_cmp_ignore_space_change || flag_cmp_ignore_all_space {
-		if flag_unicode_case_and_space {
-			compute_hash = compute_hash_unicode
-			compare_line = compare_line_unicode
-		} else {
-			compute_hash = compute_hash_bytes
-			compare_line = compare_line_bytes
+	// options for the diff engine
+	var algorithm int
+	switch flag_algorithm {
+	case "myers":
+		algorithm = diff.ALGORITHM_MYERS
+	case "patience":
+		algorithm = diff.ALGORITHM_PATIENCE
+	case "histogram":
+		algorithm = diff.ALGORITHM_HISTOGRAM
+	default:
+		usage("Invalid diff algorithm: " + flag_algorithm)
+	}
+
+	var tokenizer int
+	switch flag_tokenizer {
+	case "rune":
+		tokenizer = diff.TOKENIZE_RUNE
+	case "word":
+		tokenizer = diff.TOKENIZE_WORD
+	case "space":
+		tokenizer = diff.TOKENIZE_SPACE
+	default:
+		usage("Invalid tokens option: " + flag_tokenizer)
+	}
+
+	var unicode_norm int
+	switch flag_unicode_norm {
+	case "":
+		unicode_norm = diff.NORM_NONE
+	case "nfc":
+		unicode_norm = diff.NORM_NFC
+	case "nfkc":
+		unicode_norm = diff.NORM_NFKC
+	default:
+		usage("Invalid unicode-norm option: " + flag_unicode_norm)
+	}
+
+	diff_options = &diff.Options{
+		IgnoreCase:          flag_cmp_ignore_case,
+		IgnoreSpaceChange:   flag_cmp_ignore_space_change,
+		IgnoreAllSpace:      flag_cmp_ignore_all_space,
+		IgnoreTrailingSpace: flag_cmp_ignore_trailing_space,
+		IgnoreTabExpansion:  flag_cmp_ignore_tab_expansion,
+		TabWidth:            flag_tab_width,
+		IgnoreEOL:           flag_cmp_ignore_eol,
+		IgnoreBlankLines:    flag_cmp_ignore_blank_lines,
+		Unicode:             flag_unicode_case_and_space,
+		UnicodeNorm:         unicode_norm,
+		ContextLines:        flag_context_lines,
+		Algorithm:           algorithm,
+		Minimal:             flag_minimal,
+		CostLimit:           flag_cost_limit,
+		DetectMoves:         flag_detect_moves,
+		Tokenizer:           tokenizer,
+		Similarity:          flag_similarity,
+	}
+
+	for _, s := range flag_ignore_regexps {
+		r, err := regexp.Compile(s)
+		if err != nil {
+			usage("Invalid -I regex: " + err.Error())
 		}
-	} else {
-		compute_hash = compute_hash_exact
-		compare_line = bytes.Equal
+		diff_options.IgnoreRegexps = append(diff_options.IgnoreRegexps, r)
+	}
+
+	for _, s := range flag_normalizers {
+		n, err := parse_normalizer(s)
+		if err != nil {
+			usage("Invalid -norm option: " + err.Error())
+		}
+		diff_options.Normalizers = append(diff_options.Normalizers, n)
+	}
+
+	for _, s := range flag_file_ignore_regexps {
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			usage("Invalid -Ifile option, expecting GLOB=REGEXP: " + s)
+		}
+		if _, err := filepath.Match(s[:i], ""); err != nil {
+			usage("Invalid -Ifile glob pattern: " + err.Error())
+		}
+		r, err := regexp.Compile(s[i+1:])
+		if err != nil {
+			usage("Invalid -Ifile regex: " + err.Error())
+		}
+		file_options = append(file_options, &FileOptions{glob: s[:i], ignore_regexps: []*regexp.Regexp{r}})
+	}
+
+	// the textconv commands are used before the built-in decoders
+	var textconv []*Decoder
+	for _, s := range flag_textconv {
+		i := strings.IndexByte(s, '=')
+		if i <= 0 || strings.TrimSpace(s[i+1:]) == "" {
+			usage("Invalid -textconv option, expecting GLOB=COMMAND: " + s)
+		}
+		if _, err := filepath.Match(s[:i], ""); err != nil {
+			usage("Invalid -textconv glob pattern: " + err.Error())
+		}
+		d, err := textconv_decoder(s[:i], s[i+1:])
+		if err != nil {
+			usage("Invalid -textconv command: " + err.Error())
+		}
+		textconv = append(textconv, d)
+	}
+	decoders = append(textconv, decoders...)
+
+	for _, s := range flag_encodings {
+		glob, name := "*", s
+		if i := strings.IndexByte(s, '='); i >= 0 {
+			glob, name = s[:i], s[i+1:]
+		}
+		if _, err := filepath.Match(glob, ""); err != nil {
+			usage("Invalid -encoding glob pattern: " + err.Error())
+		}
+		te := find_text_encoding(name)
+		if te == nil {
+			usage("Invalid -encoding, expecting one of " + text_encoding_names() + ": " + name)
+		}
+		file_options = append(file_options, &FileOptions{glob: glob, encoding: te})
 	}
 
 	// get command line args
 	args := flag.Args()
+
+	if flag_merge {
+		merge_main(args)
+		return
+	}
+
 	if len(args) < 2 {
 		usage("Missing files")
 	}
@@ -422,17 +637,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	// archives are compared as directories
+	if !finfo1.IsDir() && is_archive(file1) {
+		file1, finfo1 = file1+ARCHIVE_SEPARATOR, &ArchiveDirInfo{finfo1}
+	}
+	if !finfo2.IsDir() && is_archive(file2) {
+		file2, finfo2 = file2+ARCHIVE_SEPARATOR, &ArchiveDirInfo{finfo2}
+	}
+
 	if finfo1.IsDir() != finfo2.IsDir() {
 		usage("Unable to compare file and directory")
 	}
 
-	if flag_p_keys == "" && filepath.Ext(file1) == ".csv" {
+	if flag_p_keys == "" && is_csv_file(file1) {
 		usage("-key is must for csv files - primary key column/s")
 	}
 
-	if filepath.Ext(file1) == ".csv" {
+	if flag_csv_delta != "" {
 		flag_csv_delta = path.Join(flag_out_folder, flag_csv_delta)
-		csvDelimiter = utils.DetectCsvDelimiter(file1)
+	}
+	if finfo2.IsDir() {
+		csvDeltaRoot = file2
 	}
 
 	if !flag_output_as_text {
@@ -460,178 +685,6 @@ func main() {
 	}
 }
 
-//
-// Call the diff algorithm.
-//
-func do_diff(data1, data2 []int) ([]bool, []bool) {
-	len1, len2 := len(data1), len(data2)
-	change1, change2 := make([]bool, len1), make([]bool, len2)
-
-	size := (len1+len2+1)*2 + 2
-	v := make([]int, size*2)
-
-	// Run diff compare algorithm.
-	algorithm_lcs(data1, data2, change1, change2, v)
-
-	return change1, change2
-}
-
-//
-// Find the begin/end of this 'changed' segment
-//
-func next_change_segment(start int, change []bool, data []int) (int, int, int) {
-
-	// find the end of this changes segment
-	end := start + 1
-	for end < len(change) && change[end] {
-		end++
-	}
-
-	// skip blank lines in the begining and end of the changes
-	i, j := start, end
-	for i < end && data[i] == 0 {
-		i++
-	}
-	for j > i && data[j-1] == 0 {
-		j--
-	}
-
-	return end, i, j
-}
-
-//
-// Add segment to the group of changes. Add context lines before and after if necessary
-//
-func add_change_segment(chg DiffChanger, ops []DiffOp, op DiffOp) []DiffOp {
-	last1, last2 := 0, 0
-	if len(ops) > 0 {
-		last_op := ops[len(ops)-1]
-		last1, last2 = last_op.end1, last_op.end2
-	}
-
-	gap1, gap2 := op.start1-last1, op.start2-last2
-	if len(ops) > 0 && (op.op == 0 || (gap1 > flag_context_lines*2 && gap2 > flag_context_lines*2)) {
-		e1, e2 := utils.MinInt(op.start1, last1+flag_context_lines), utils.MinInt(op.start2, last2+flag_context_lines)
-		if e1 > last1 || e2 > last2 {
-			ops = append(ops, DiffOp{DIFF_OP_SAME, last1, e1, last2, e2})
-		}
-		chg.diff_lines(ops)
-		ops = ops[:0]
-	}
-
-	c1, c2 := utils.MaxInt(last1, op.start1-flag_context_lines), utils.MaxInt(last2, op.start2-flag_context_lines)
-	if c1 < op.start1 || c2 < op.start2 {
-		ops = append(ops, DiffOp{DIFF_OP_SAME, c1, op.start1, c2, op.start2})
-	}
-
-	if op.op != 0 {
-		ops = append(ops, op)
-	}
-	return ops
-}
-
-//
-// Report diff changes.
-// For each group of change, call the diff_lines() function
-//
-func report_diff(chg DiffChanger, data1, data2 []int, change1, change2 []bool) bool {
-	len1, len2 := len(change1), len(change2)
-	i1, i2 := 0, 0
-	ops := make([]DiffOp, 0, 16)
-	changed := false
-	var m1start, m1end, m2start, m2end int
-
-	// scan for changes
-	for i1 < len1 || i2 < len2 {
-		switch {
-		// no change, advance both i1 and i2 to to next set of changes
-		case i1 < len1 && i2 < len2 && !change1[i1] && !change2[i2]:
-			i1++
-			i2++
-
-		// change in both lists
-		case i1 < len1 && i2 < len2 && change1[i1] && change2[i2]:
-			i1, m1start, m1end = next_change_segment(i1, change1, data1)
-			i2, m2start, m2end = next_change_segment(i2, change2, data2)
-
-			op_mode := 0
-			switch {
-			case m1start < m1end && m2start < m2end:
-				op_mode = DIFF_OP_MODIFY
-			case m1start < m1end:
-				op_mode = DIFF_OP_REMOVE
-			case m2start < m2end:
-				op_mode = DIFF_OP_INSERT
-			}
-			if op_mode != 0 {
-				ops = add_change_segment(chg, ops, DiffOp{op_mode, m1start, m1end, m2start, m2end})
-				changed = true
-			}
-
-		case i1 < len1 && change1[i1]:
-			i1, m1start, m1end = next_change_segment(i1, change1, data1)
-			if m1start < m1end {
-				ops = add_change_segment(chg, ops, DiffOp{DIFF_OP_REMOVE, m1start, m1end, i2, i2})
-				changed = true
-			}
-
-		case i2 < len2 && change2[i2]:
-			i2, m2start, m2end = next_change_segment(i2, change2, data2)
-			if m2start < m2end {
-				ops = add_change_segment(chg, ops, DiffOp{DIFF_OP_INSERT, i1, i1, m2start, m2end})
-				changed = true
-			}
-
-		default: // should not reach here
-			return true
-		}
-	}
-	if len(ops) > 0 {
-		add_change_segment(chg, ops, DiffOp{0, len1, len1, len2, len2})
-	}
-	return changed
-}
-
-//
-// split text into array of individual rune position, and another array for comparison.
-//
-func split_runes(s []byte) ([]int, []int) {
-
-	pos := make([]int, len(s)+1)
-	cmp := make([]int, len(s))
-
-	var h, i, n int
-
-	for i < len(s) {
-		pos[n] = i
-		b := s[i]
-		if b < utf8.RuneSelf {
-			if flag_cmp_ignore_case {
-				if flag_unicode_case_and_space {
-					h = int(unicode.ToLower(rune(b)))
-				} else {
-					h = int(utils.ToLowerByte(b))
-				}
-			} else {
-				h = int(b)
-			}
-			i++
-		} else {
-			r, rsize := utf8.DecodeRune(s[i:])
-			if flag_cmp_ignore_case && flag_unicode_case_and_space {
-				h = int(unicode.ToLower(r))
-			} else {
-				h = int(r)
-			}
-			i += rsize
-		}
-		cmp[n] = h
-		n = n + 1
-	}
-	pos[n] = i
-	return pos[:n+1], cmp[:n]
-}
-
 func output_diff_message_content(filename1, filename2 string, info1, info2 os.FileInfo, msg1, msg2 string, data1, data2 [][]byte, is_error bool) {
 
 	if flag_output_as_text {
@@ -658,659 +711,6 @@ func print_line_numbers(mode string, start1, end1, start2, end2 int) {
 	}
 }
 
-func skip_space_rune(line []byte, i int) int {
-	for i < len(line) {
-		b, size := utf8.DecodeRune(line[i:])
-		if !unicode.IsSpace(b) {
-			return i
-		}
-		i += size
-	}
-	return i
-}
-
-//
-// Get the next rune, and skip spaces after it
-//
-func get_next_rune_nonspace(line []byte, i int) (rune, int) {
-	b, size := utf8.DecodeRune(line[i:])
-	return b, skip_space_rune(line, i+size)
-}
-
-//
-// Get the next rune, and determine if there is a space after it.
-// Also ignore trailing spaces at end-of-line
-//
-func get_next_rune_xspace(line []byte, i int) (rune, bool, int) {
-	b, size := utf8.DecodeRune(line[i:])
-	i += size
-	space_after := false
-	for i < len(line) {
-		s, size := utf8.DecodeRune(line[i:])
-		if !unicode.IsSpace(s) {
-			break
-		}
-		space_after = true
-		i += size
-	}
-	if space_after && i >= len(line) {
-		space_after = false
-	}
-	return b, space_after, i
-}
-
-func skip_space_byte(line []byte, i int) int {
-	for i < len(line) {
-		if !utils.IsSpace(line[i]) {
-			return i
-		}
-		i++
-	}
-	return i
-}
-
-func get_next_byte_nonspace(line []byte, i int) (byte, int) {
-	return line[i], skip_space_byte(line, i+1)
-}
-
-func get_next_byte_xspace(line []byte, i int) (byte, bool, int) {
-	b, i := line[i], i+1
-	space_after := false
-	for i < len(line) {
-		if !utils.IsSpace(line[i]) {
-			break
-		}
-		space_after = true
-		i++
-	}
-	if space_after && i >= len(line) {
-		space_after = false
-	}
-	return b, space_after, i
-}
-
-func compare_line_bytes(line1, line2 []byte) bool {
-	len1, len2 := len(line1), len(line2)
-	var i, j int
-	var v1, v2 byte
-	switch {
-	case flag_cmp_ignore_all_space:
-		i = skip_space_byte(line1, 0)
-		j = skip_space_byte(line2, 0)
-		for i < len1 && j < len2 {
-			v1, i = get_next_byte_nonspace(line1, i)
-			v2, j = get_next_byte_nonspace(line2, j)
-			if flag_cmp_ignore_case && v1 != v2 {
-				v1, v2 = utils.ToLowerByte(v1), utils.ToLowerByte(v2)
-			}
-			if v1 != v2 {
-				return false
-			}
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-
-	case flag_cmp_ignore_space_change:
-		var space_after1, space_after2 bool
-		i = skip_space_byte(line1, 0)
-		j = skip_space_byte(line2, 0)
-		for i < len1 && j < len2 {
-			v1, space_after1, i = get_next_byte_xspace(line1, i)
-			v2, space_after2, j = get_next_byte_xspace(line2, j)
-			if flag_cmp_ignore_case && v1 != v2 {
-				v1, v2 = utils.ToLowerByte(v1), utils.ToLowerByte(v2)
-			}
-			if v1 != v2 || space_after1 != space_after2 {
-				return false
-			}
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-
-	case flag_cmp_ignore_case:
-		if len1 != len2 {
-			return false
-		}
-		for i < len1 && j < len2 {
-			if utils.ToLowerByte(line1[i]) != utils.ToLowerByte(line2[j]) {
-				return false
-			}
-			i, j = i+1, j+1
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-	}
-	return true
-}
-
-func compare_line_unicode(line1, line2 []byte) bool {
-	len1, len2 := len(line1), len(line2)
-	var i, j int
-	var v1, v2 rune
-	var size1, size2 int
-	switch {
-	case flag_cmp_ignore_all_space:
-		i = skip_space_rune(line1, 0)
-		j = skip_space_rune(line2, 0)
-		for i < len1 && j < len2 {
-			v1, i = get_next_rune_nonspace(line1, i)
-			v2, j = get_next_rune_nonspace(line2, j)
-			if flag_cmp_ignore_case && v1 != v2 {
-				v1, v2 = unicode.ToLower(v1), unicode.ToLower(v2)
-			}
-			if v1 != v2 {
-				return false
-			}
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-
-	case flag_cmp_ignore_space_change:
-		i = skip_space_rune(line1, 0)
-		j = skip_space_rune(line2, 0)
-		var space_after1, space_after2 bool
-		for i < len1 && j < len2 {
-			v1, space_after1, i = get_next_rune_xspace(line1, i)
-			v2, space_after2, j = get_next_rune_xspace(line2, j)
-			if flag_cmp_ignore_case && v1 != v2 {
-				v1, v2 = unicode.ToLower(v1), unicode.ToLower(v2)
-			}
-			if v1 != v2 || space_after1 != space_after2 {
-				return false
-			}
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-
-	case flag_cmp_ignore_case:
-		if len1 != len2 {
-			return false
-		}
-		for i < len1 && j < len2 {
-			v1, size1 = utf8.DecodeRune(line1[i:])
-			v2, size2 = utf8.DecodeRune(line2[j:])
-			if v1 != v2 && unicode.ToLower(v1) != unicode.ToLower(v2) {
-				return false
-			}
-			i, j = i+size1, j+size2
-		}
-		if i < len1 || j < len2 {
-			return false
-		}
-	}
-	return true
-}
-
-var crc_table = crc32.MakeTable(crc32.Castagnoli)
-
-func hash32(h uint32, b byte) uint32 {
-	return crc_table[byte(h)^b] ^ (h >> 8)
-}
-
-func hash32_unicode(h uint32, r rune) uint32 {
-	for r != 0 {
-		h = hash32(h, byte(r))
-		r = r >> 8
-	}
-	return h
-}
-
-func compute_hash_exact(data []byte) uint32 {
-	// On amd64, this will be using the SSE4.2 hardware instructions, much faster!
-	return crc32.Update(0, crc_table, data)
-}
-
-func compute_hash_bytes(line1 []byte) uint32 {
-	var hash uint32
-	switch {
-	case flag_cmp_ignore_all_space:
-		for _, v1 := range line1 {
-			if !utils.IsSpace(v1) {
-				if flag_cmp_ignore_case {
-					v1 = utils.ToLowerByte(v1)
-				}
-				hash = hash32(hash, v1)
-			}
-		}
-
-	case flag_cmp_ignore_space_change:
-		last_hash := hash
-		last_space := true
-		for _, v1 := range line1 {
-			if utils.IsSpace(v1) {
-				if !last_space {
-					last_hash = hash
-					hash = hash32(hash, ' ')
-				}
-				last_space = true
-			} else {
-				if flag_cmp_ignore_case {
-					v1 = utils.ToLowerByte(v1)
-				}
-				hash = hash32(hash, v1)
-				last_space = false
-			}
-		}
-		if last_space {
-			hash = last_hash
-		}
-
-	case flag_cmp_ignore_case:
-		for _, v1 := range line1 {
-			v1 = utils.ToLowerByte(v1)
-			hash = hash32(hash, v1)
-		}
-
-	}
-	return hash
-}
-
-func compute_hash_unicode(line1 []byte) uint32 {
-	var hash uint32
-	i, len1 := 0, len(line1)
-
-	switch {
-	case flag_cmp_ignore_all_space:
-		for i < len1 {
-			v1, size := utf8.DecodeRune(line1[i:])
-			i = i + size
-			if !unicode.IsSpace(v1) {
-				if flag_cmp_ignore_case {
-					v1 = unicode.ToLower(v1)
-				}
-				hash = hash32_unicode(hash, v1)
-			}
-		}
-
-	case flag_cmp_ignore_space_change:
-		last_hash := hash
-		last_space := true
-		for i < len1 {
-			v1, size := utf8.DecodeRune(line1[i:])
-			i += size
-			if unicode.IsSpace(v1) {
-				if !last_space {
-					last_hash = hash
-					hash = hash32(hash, ' ')
-				}
-				last_space = true
-			} else {
-				if flag_cmp_ignore_case {
-					v1 = unicode.ToLower(v1)
-				}
-				hash = hash32_unicode(hash, v1)
-				last_space = false
-			}
-		}
-		if last_space {
-			hash = last_hash
-		}
-
-	case flag_cmp_ignore_case:
-		for i < len1 {
-			v1, size := utf8.DecodeRune(line1[i:])
-			i = i + size
-			v1 = unicode.ToLower(v1)
-			hash = hash32_unicode(hash, v1)
-		}
-	}
-	return hash
-}
-
-type EquivClass struct {
-	id   int
-	hash uint32
-	line *[]byte
-	next *EquivClass
-}
-
-type LinesData struct {
-	ids        []int // Id's for each line,
-	zids       []int // list of ids with unmatched lines replaced by a single entry (and blank lines removed)
-	zcount     []int // Number of lines that represent each zids entry
-	change     []bool
-	zids_start int
-	zids_end   int
-}
-
-//
-// Compute id's that represent the original lines, these numeric id's are use for faster line comparison.
-//
-func find_equiv_lines(lines1, lines2 [][]byte) (*LinesData, *LinesData) {
-
-	info1 := LinesData{
-		ids:    make([]int, len(lines1)),
-		change: make([]bool, len(lines1)),
-	}
-
-	info2 := LinesData{
-		ids:    make([]int, len(lines2)),
-		change: make([]bool, len(lines2)),
-	}
-
-	// since we already have a hashing function, it's faster to use arrays than to use go's builtin map
-	// Use bucket size that is power of 2
-	buckets := 1 << 9
-	for buckets < (len(lines1)+len(lines2))*2 {
-		buckets = buckets << 1
-	}
-
-	// create the slice we are using for hash tables
-	eqhash := make([]*EquivClass, buckets)
-
-	// Use id=0 for blank lines.
-	// Later in report_changes(), do not report changes on chunks of lines with id=0
-	if flag_cmp_ignore_blank_lines {
-		hashcode := compute_hash(blank_line)
-		ihash := int(hashcode) & (buckets - 1)
-		eqhash[ihash] = &EquivClass{id: 0, line: &blank_line, hash: hashcode}
-	}
-
-	// the unique id for identical lines, start with 1.
-	var max_id_f1, max_id_f2 int
-	next_id := 1
-
-	// process both sets of lines
-	for findex := 0; findex < 2; findex++ {
-		var lines [][]byte
-		var ids []int
-
-		if findex == 0 {
-			lines = lines1
-			ids = info1.ids
-		} else {
-			lines = lines2
-			ids = info2.ids
-		}
-
-		for i := 0; i < len(lines); i++ {
-			lptr := &lines[i]
-			// find current line in eqhash
-			hashcode := compute_hash(*lptr)
-			ihash := int(hashcode) & (buckets - 1)
-			eq := eqhash[ihash]
-			if eq == nil {
-				// not found in eqhash, create new entry
-				ids[i] = next_id
-				eqhash[ihash] = &EquivClass{id: next_id, line: lptr, hash: hashcode}
-				next_id++
-			} else if eq.hash == hashcode && compare_line(*lptr, *eq.line) {
-				// found, and line is the same. reuse same id
-				ids[i] = eq.id
-			} else {
-				// hash-collision. look through link-list for same match
-				n := eq.next
-				for n != nil {
-					if n.hash == hashcode && compare_line(*lptr, *n.line) {
-						ids[i] = n.id
-						break
-					}
-					n = n.next
-				}
-				// new entry, link to start of linked-list
-				if n == nil {
-					ids[i] = next_id
-					eq.next = &EquivClass{id: next_id, line: lptr, hash: hashcode, next: eq.next}
-					next_id++
-				}
-			}
-		}
-
-		if findex == 0 {
-			max_id_f1 = next_id - 1
-		} else {
-			max_id_f2 = next_id - 1
-		}
-	}
-
-	compress_equiv_ids(&info1, &info2, max_id_f1, max_id_f2)
-
-	return &info1, &info2
-}
-
-// Count the occurrances of each unique ids in both sets of lines, we will then know which lines are only present in one file, but not the other.
-// Remove chunks of lines that do not appear in the other files, and replace with a single entry
-// Return compressed lists of ids and a list indicating where are the chunk of lines being replaced
-func compress_equiv_ids(lines1, lines2 *LinesData, max_id1, max_id2 int) {
-
-	len1, len2 := len(lines1.ids), len(lines2.ids)
-	has_ids1, has_ids2 := make([]bool, max_id1+1), make([]bool, max_id2+1)
-
-	// Determine which id's are in the each file
-	for _, v := range lines1.ids {
-		has_ids1[v] = true
-	}
-	for _, v := range lines2.ids {
-		has_ids2[v] = true
-	}
-
-	// exclude lines from the begining that are identical in both files
-	// if line in file1 but not in file2, exclude it and marked as changed
-	// if line in file2 but not in file1, exclude it and marked as changed
-	i1, i2 := 0, 0
-	for i1 < len1 && i2 < len2 {
-		v1, v2 := lines1.ids[i1], lines2.ids[i2]
-		if v1 > max_id2 || !has_ids2[v1] {
-			lines1.change[i1] = true
-			i1++
-		} else if v2 > max_id1 || !has_ids1[v2] {
-			lines2.change[i2] = true
-			i2++
-		} else if v1 == v2 {
-			i1++
-			i2++
-		} else {
-			break
-		}
-	}
-
-	// exclude lines from the end that are identical in both files
-	// if line in file1 but not in file2, exclude it and marked as changed
-	// if line in file2 but not in file1, exclude it and marked as changed
-	j1, j2 := len1, len2
-	for i1 < j1 && i2 < j2 {
-		v1, v2 := lines1.ids[j1-1], lines2.ids[j2-1]
-		if v1 > max_id2 || !has_ids2[v1] {
-			j1--
-			lines1.change[j1] = true
-		} else if v2 > max_id1 || !has_ids1[v2] {
-			j2--
-			lines2.change[j2] = true
-		} else if v1 == v2 {
-			j1--
-			j2--
-		} else {
-			break
-		}
-	}
-
-	// One of the list is now empty, no need to run diff algorithm for comparison.
-	// Just mark the remaining lines other list as changed.
-	if i1 == j1 {
-		for i2 < j2 {
-			lines2.change[i2] = true
-			i2++
-		}
-		return
-	}
-	if i2 == j2 {
-		for i1 < j1 {
-			lines1.change[i1] = true
-			i1++
-		}
-		return
-	}
-
-	// store excluded lines from begining and end of file
-	lines1.zids_start, lines1.zids_end = i1, j1
-	lines2.zids_start, lines2.zids_end = i2, j2
-
-	// Go through all lines, replace chunk of lines that does not exists in the
-	// other set with a single entry and a negative new id).
-	next_id := utils.MaxInt(max_id1, max_id2) + 1
-	for findex := 0; findex < 2; findex++ {
-		var ids []int
-		var has_ids []bool
-		var max_id int
-
-		if findex == 0 {
-			ids = lines1.ids[lines1.zids_start:lines1.zids_end]
-			has_ids = has_ids2
-			max_id = max_id2
-		} else {
-			ids = lines2.ids[lines2.zids_start:lines2.zids_end]
-			has_ids = has_ids1
-			max_id = max_id1
-		}
-
-		// new slices for compressed ids and the number of lines each entry replaced
-		// use a new negative id for those merged lines
-		zcount := make([]int, len(ids))
-		zids := make([]int, len(ids))
-
-		lastexclude := false
-		n := 0
-		for _, v := range ids {
-			exclude := (v > max_id || !has_ids[v])
-			if exclude && lastexclude {
-				zcount[n-1]++
-				zids[n-1] = -next_id
-				next_id++
-			} else if exclude {
-				zcount[n]++
-				zids[n] = -v
-				n++
-			} else {
-				zcount[n]++
-				zids[n] = v
-				n++
-			}
-			lastexclude = exclude
-		}
-
-		// shrink the slice
-		zids = zids[:n]
-		zcount = zcount[:n]
-
-		if findex == 0 {
-			lines1.zids = zids
-			lines1.zcount = zcount
-		} else {
-			lines2.zids = zids
-			lines2.zcount = zcount
-		}
-	}
-}
-
-//
-// Do the reverse of the compress_equiv_ids.
-// zllines1 and zlines2 contains the 'extra' lines each entry represents.
-//
-func expand_change_list(info1, info2 *LinesData, zchange1, zchange2 []bool) {
-
-	for findex := 0; findex < 2; findex++ {
-		var info *LinesData
-		var change, zchange []bool
-
-		// expand the changes into the range between zids_start and zids_end
-		if findex == 0 {
-			info = info1
-			change = info1.change[info1.zids_start:]
-			zchange = zchange1
-		} else {
-			info = info2
-			change = info2.change[info2.zids_start:]
-			zchange = zchange2
-		}
-
-		// no change
-		if zchange == nil {
-			continue
-		}
-
-		// expand each entry by the number of lines in zcount[]
-		n := 0
-		for i, m := range info.zcount {
-			if zchange[i] {
-				for end := n + m; n < end; n++ {
-					change[n] = true
-				}
-			} else {
-				n += m
-			}
-		}
-	}
-}
-
-func openCsvFile(fname string, finfo os.FileInfo, csvReorder *CsvReorder) *Filedata {
-
-	if csvReorder.reorderFlag {
-		var reorderColumn []int
-		var sourceColumn []int
-
-		sourceHeader := csvReorder.header
-		deltaHeader := utils.GetHeader(fname)
-
-		for sourceIdx, data := range sourceHeader {
-			destIdx := utils.Find(deltaHeader, data)
-			sourceColumn = append(sourceColumn, sourceIdx)
-			reorderColumn = append(reorderColumn, destIdx)
-		}
-
-		//Copy(fname, fname+".original", 2048)
-		if !utils.HeaderPositionEqual(sourceColumn, reorderColumn) {
-			utils.ColumnReorder(fname, reorderColumn, csvDelimiter)
-			return open_file(sortCsv(fname + ".colreordered"))
-		} else {
-			return open_file(sortCsv(fname))
-		}
-
-		//os.Rename(fname+".tmp", fname)
-		//stat, _ := os.Stat(fname)
-	} else {
-		//return open_file(fname, stat)
-		return open_file(sortCsv(fname))
-	}
-}
-
-func sortCsv(fname string) (string, os.FileInfo) {
-	file, err := os.Open(fname)
-	defer file.Close()
-
-	defer removeFile(fname, ".colreordered")
-
-	fnameSorted := fname + ".sorted"
-	wfile, err := os.Create(fnameSorted)
-	defer wfile.Close()
-
-	// Use  a CSV parser to extract the partial keys from the parameter
-	/*pKeys, err := csv.Parse(flag_p_keys)
-	if err != nil || len(pKeys) < 1 {
-		usage("invalid key columns")
-	}*/
-
-	pKeys := strings.Split(flag_p_keys, ",")
-
-	var p *csv.SortProcess
-	p = (&csv.SortKeys{Keys: pKeys, Numeric: []string{}, Reversed: []string{}}).AsSortProcess()
-
-	var errCh = make(chan error, 1)
-	csvDelimiterRune := rune(csvDelimiter[0])
-	p.Run(csv.WithIoReaderAndDelimiter(file, csvDelimiterRune), csv.WithIoWriterAndDelimiter(wfile, csvDelimiterRune), errCh)
-	err = <-errCh
-	if err != nil {
-		fmt.Printf("fatal: %v\n", err)
-		os.Exit(1)
-	}
-	stat, _ := os.Stat(fnameSorted)
-	return fnameSorted, stat
-}
-
 // open file, and read/mmap the entire content into byte array
 func open_file(fname string, finfo os.FileInfo) *Filedata {
 
@@ -1329,6 +729,18 @@ func open_file(fname string, finfo os.FileInfo) *Filedata {
 		return file
 	}
 
+	// file in an archive
+	if _, _, ok := split_archive_path(fname); ok {
+		file.data, err = read_archive_file(fname)
+		if err != nil {
+			file.errormsg = err.Error()
+			return file
+		}
+		file.decode()
+		file.decode_text()
+		return file
+	}
+
 	// open the file
 	file.osfile, err = os.Open(file.name)
 	if err != nil {
@@ -1337,26 +749,9 @@ func open_file(fname string, finfo os.FileInfo) *Filedata {
 		return file
 	}
 
-	if strings.HasSuffix(fname, ".gz") {
-		// Uncompress .gz file
-		reader, err := gzip.NewReader(file.osfile)
-		if err != nil {
-			file.errormsg = err.Error()
-			return file
-		}
-		fdata, err := ioutil.ReadAll(reader)
-		if err != nil {
-			file.errormsg = err.Error()
-			return file
-		}
-		reader.Close()
-		file.data = fdata
-		file.osfile.Close()
-		file.osfile = nil
-	} else if strings.HasSuffix(fname, ".bz2") {
-		// Uncompress .bz2 file
-		reader := bzip2.NewReader(file.osfile)
-		fdata, err := ioutil.ReadAll(reader)
+	if find_decoder(fname) != nil {
+		// read in the entire file, to decode it
+		fdata, err := ioutil.ReadAll(file.osfile)
 		if err != nil {
 			file.errormsg = err.Error()
 			return file
@@ -1388,28 +783,36 @@ func open_file(fname string, finfo os.FileInfo) *Filedata {
 		file.osfile = nil
 	}
 
+	file.decode()
+	file.decode_text()
 	return file
 }
 
+//
+// Replace the file content with the decoded data, a memory mapped file is unmapped.
+//
+func (file *Filedata) replace_data(data []byte) {
+	if file.is_mapped {
+		unmap_file(file.data)
+		file.osfile.Close()
+		file.osfile = nil
+		file.is_mapped = false
+	}
+	file.data = data
+}
+
 // Close file (and umap it)
 func (file *Filedata) close_file() {
 
-	defer removeFile(file.name, ".sorted")
-
 	if file.osfile != nil {
-		if file.is_mapped && file.data != nil {
-			unmap_file(file.data)
+		if file.is_mapped && file.raw != nil {
+			unmap_file(file.raw)
 		}
 		file.osfile.Close()
 		file.osfile = nil
 	}
 	file.data = nil
-}
-
-func removeFile(fileName string, ext string) {
-	if strings.HasSuffix(fileName, ext) {
-		os.Remove(fileName)
-	}
+	file.raw = nil
 }
 
 // check if file is binary
@@ -1422,7 +825,7 @@ func (file *Filedata) check_binary() {
 		file.errormsg = MSG_FILE_SIZE_ZERO
 		return
 	}
-	if bytes.IndexByte(file.data[0:utils.MinInt(len(file.data), BINARY_CHECK_SIZE)], 0) >= 0 {
+	if file.is_binary || bytes.IndexByte(file.data[0:utils.MinInt(len(file.data), BINARY_CHECK_SIZE)], 0) >= 0 {
 		file.data = nil
 		file.errormsg = MSG_FILE_IS_BINARY
 		return
@@ -1434,31 +837,14 @@ func (file *Filedata) check_binary() {
 //
 func (file *Filedata) split_lines() [][]byte {
 
-	lines := make([][]byte, 0, utils.MinInt(len(file.data)/32, 500))
-	var i, previ int
-	var b, lastb byte
-
-	data := file.data
-	for i, b = range data {
-		// accept dos, unix, mac newline
-		if b == '\n' && lastb == '\r' {
-			previ = i + 1
-		} else if b == '\n' || b == '\r' {
-			lines = append(lines, data[previ:i])
-			previ = i + 1
-		} else if b == 0 && i < BINARY_CHECK_SIZE {
-			file.is_binary = true
-			file.errormsg = MSG_FILE_IS_BINARY
-			return nil
-		}
-		lastb = b
-	}
-
-	// add last incomplete line (if required)
-	if len(data) > previ {
-		lines = append(lines, data[previ:len(data)])
+	if file.is_binary || bytes.IndexByte(file.data[0:utils.MinInt(len(file.data), BINARY_CHECK_SIZE)], 0) >= 0 {
+		file.is_binary = true
+		file.errormsg = MSG_FILE_IS_BINARY
+		return nil
 	}
 
+	lines, eols := diff.SplitLinesEOL(file.data)
+	file.eols = eols
 	return lines
 }
 
@@ -1472,19 +858,29 @@ func (s FileInfoList) Less(i, j int) bool { return s[i].Name() < s[j].Name() }
 // get a list of sorted directory entries
 func read_sorted_dir(dirname string) ([]os.FileInfo, error) {
 
-	dir, err := os.Open(dirname)
-	if err != nil {
-		return nil, err
-	}
+	var all []os.FileInfo
+	var err error
+
+	if _, _, ok := split_archive_path(dirname); ok {
+		all, err = read_archive_dir(dirname)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		dir, err := os.Open(dirname)
+		if err != nil {
+			return nil, err
+		}
+
+		all, err = dir.Readdir(-1)
+		if err != nil {
+			dir.Close()
+			return nil, err
+		}
 
-	all, err := dir.Readdir(-1)
-	if err != nil {
 		dir.Close()
-		return nil, err
 	}
 
-	dir.Close()
-
 	// Exclude files
 	if regexp_exclude_files != nil && len(all) > 0 {
 		eall := make([]os.FileInfo, 0, len(all))
@@ -1509,8 +905,13 @@ func diff_dirs(dirname1, dirname2 string, finfo1, finfo2 os.FileInfo) {
 	dirname1 = strings.TrimRight(dirname1, PATH_SEPARATOR)
 	dirname2 = strings.TrimRight(dirname2, PATH_SEPARATOR)
 
+	// archives are kept in memory until the files in them are compared
+	hold_archives(dirname1, dirname2)
+	defer release_archives(dirname1, dirname2)
+
 	dir1, err1 := read_sorted_dir(dirname1)
 	dir2, err2 := read_sorted_dir(dirname2)
+	dir1, dir2 = archives_as_dirs(dir1), archives_as_dirs(dir2)
 
 	if err1 != nil || err2 != nil {
 		msg1, msg2 := "", ""
@@ -1548,65 +949,47 @@ func diff_dirs(dirname1, dirname2 string, finfo1, finfo2 os.FileInfo) {
 				if dir1[i1].IsDir() != dir2[i2].IsDir() {
 					if !dir_mode {
 						if dir1[i1].IsDir() {
-							output_diff_message(dirname1+PATH_SEPARATOR+name1, dirname2+PATH_SEPARATOR+name2, dir1[i1], dir2[i2], MSG_THIS_IS_DIR, MSG_THIS_IS_FILE, true)
+							output_diff_message(join_path(dirname1, name1), join_path(dirname2, name2), dir1[i1], dir2[i2], MSG_THIS_IS_DIR, MSG_THIS_IS_FILE, true)
 						} else {
-							output_diff_message(dirname1+PATH_SEPARATOR+name1, dirname2+PATH_SEPARATOR+name2, dir1[i1], dir2[i2], MSG_THIS_IS_FILE, MSG_THIS_IS_DIR, true)
+							output_diff_message(join_path(dirname1, name1), join_path(dirname2, name2), dir1[i1], dir2[i2], MSG_THIS_IS_FILE, MSG_THIS_IS_DIR, true)
 						}
 					}
 				} else if dir_mode {
 					// compare sub-directories
-					diff_dirs(dirname1+PATH_SEPARATOR+name1, dirname2+PATH_SEPARATOR+name2, dir1[i1], dir2[i2])
+					diff_dirs(join_path(dirname1, name1), join_path(dirname2, name2), dir1[i1], dir2[i2])
 				} else {
 					// compare files
 					if flag_max_goroutines > 1 {
-						queue_diff_file(dirname1+PATH_SEPARATOR+name1, dirname2+PATH_SEPARATOR+name2, dir1[i1], dir2[i2])
+						queue_diff_file(join_path(dirname1, name1), join_path(dirname2, name2), dir1[i1], dir2[i2])
 					} else {
-						diff_file(dirname1+PATH_SEPARATOR+name1, dirname2+PATH_SEPARATOR+name2, dir1[i1], dir2[i2])
+						diff_file(join_path(dirname1, name1), join_path(dirname2, name2), dir1[i1], dir2[i2])
 					}
 				}
 				i1, i2 = i1+1, i2+1
 			} else if (i1 < len(dir1) && name1 < name2) || i2 >= len(dir2) {
 				if dir_mode {
-					output_diff_message(dirname1+PATH_SEPARATOR+name1, dirname2+PATH_SEPARATOR+name1, dir1[i1], nil, "", MSG_DIR_NOT_EXISTS, true)
+					output_diff_message(join_path(dirname1, name1), join_path(dirname2, name1), dir1[i1], nil, "", MSG_DIR_NOT_EXISTS, true)
 				} else {
 					if flag_suppress_missing_file {
-						output_diff_message(dirname1+PATH_SEPARATOR+name1, dirname2+PATH_SEPARATOR+name1, dir1[i1], nil, "", MSG_FILE_NOT_EXISTS, true)
+						output_diff_message(join_path(dirname1, name1), join_path(dirname2, name1), dir1[i1], nil, "", MSG_FILE_NOT_EXISTS, true)
 					} else {
-						if strings.HasSuffix(dirname1+PATH_SEPARATOR+name1, ".csv") {
-							csvSourceReorder := &CsvReorder{
-								reorderFlag: false,
-								header:      nil,
-							}
-							fdata = openCsvFile(dirname1+PATH_SEPARATOR+name1, dir1[i1], csvSourceReorder)
-						} else {
-							fdata = open_file(dirname1+PATH_SEPARATOR+name1, dir1[i1])
-						}
+						fdata = open_file(join_path(dirname1, name1), dir1[i1])
 						fdata.check_binary()
-						output_diff_message_content(dirname1+PATH_SEPARATOR+name1, dirname2+PATH_SEPARATOR+name1, dir1[i1], nil, fdata.errormsg, MSG_FILE_NOT_EXISTS, fdata.split_lines(), nil, true)
+						output_diff_message_content(join_path(dirname1, name1), join_path(dirname2, name1), dir1[i1], nil, fdata.errormsg, MSG_FILE_NOT_EXISTS, fdata.preview_lines(), nil, true)
 						fdata.close_file()
-
 					}
 				}
 				i1++
 			} else if (i2 < len(dir2) && name2 < name1) || i1 >= len(dir1) {
 				if dir_mode {
-					output_diff_message(dirname1+PATH_SEPARATOR+name2, dirname2+PATH_SEPARATOR+name2, nil, dir2[i2], MSG_DIR_NOT_EXISTS, "", true)
+					output_diff_message(join_path(dirname1, name2), join_path(dirname2, name2), nil, dir2[i2], MSG_DIR_NOT_EXISTS, "", true)
 				} else {
 					if flag_suppress_missing_file {
-						output_diff_message(dirname1+PATH_SEPARATOR+name2, dirname2+PATH_SEPARATOR+name2, nil, dir2[i2], MSG_FILE_NOT_EXISTS, "", true)
+						output_diff_message(join_path(dirname1, name2), join_path(dirname2, name2), nil, dir2[i2], MSG_FILE_NOT_EXISTS, "", true)
 					} else {
-						if strings.HasSuffix(dirname2+PATH_SEPARATOR+name2, ".csv") {
-							csvDeltaReorder := &CsvReorder{
-								reorderFlag: true,
-								header:      utils.GetHeader(dirname1 + PATH_SEPARATOR + name1),
-							}
-							fdata = openCsvFile(dirname2+PATH_SEPARATOR+name2, dir2[i2], csvDeltaReorder)
-						} else {
-							fdata = open_file(dirname2+PATH_SEPARATOR+name2, dir2[i2])
-						}
-
+						fdata = open_file(join_path(dirname2, name2), dir2[i2])
 						fdata.check_binary()
-						output_diff_message_content(dirname1+PATH_SEPARATOR+name2, dirname2+PATH_SEPARATOR+name2, nil, dir2[i2], MSG_FILE_NOT_EXISTS, fdata.errormsg, nil, fdata.split_lines(), true)
+						output_diff_message_content(join_path(dirname1, name2), join_path(dirname2, name2), nil, dir2[i2], MSG_FILE_NOT_EXISTS, fdata.errormsg, nil, fdata.preview_lines(), true)
 						fdata.close_file()
 					}
 				}
@@ -1618,41 +1001,16 @@ func diff_dirs(dirname1, dirname2 string, finfo1, finfo2 os.FileInfo) {
 	}
 }
 
-type CsvReorder struct {
-	reorderFlag bool
-	header      []string
-}
-
 // compare 2 file
 func diff_file(filename1, filename2 string, finfo1, finfo2 os.FileInfo) {
 
-	var file1, file2 *Filedata
-
-	if strings.HasSuffix(filename1, ".csv") {
-		csvSourceReorder := &CsvReorder{
-			reorderFlag: false,
-			header:      nil,
-		}
-		file1 = openCsvFile(filename1, finfo1, csvSourceReorder)
-	} else {
-		file1 = open_file(filename1, finfo1)
+	if is_csv_file(filename1) && is_csv_file(filename2) {
+		diff_csv_file(filename1, filename2, finfo1, finfo2)
+		return
 	}
 
-	if strings.HasSuffix(filename2, ".csv") {
-		csvDeltaReorder := &CsvReorder{
-			reorderFlag: true,
-			header:      utils.GetHeader(filename1),
-		}
-		csvHeaderData = csvDeltaReorder.header
-
-		if len(csvHeaderData) > 0 {
-			fmt.Fprintf(out, "<p>%s</p>", strings.Join(csvHeaderData, csvDelimiter))
-		}
-
-		file2 = openCsvFile(filename2, finfo2, csvDeltaReorder)
-	} else {
-		file2 = open_file(filename2, finfo2)
-	}
+	file1 := open_file(filename1, finfo1)
+	file2 := open_file(filename2, finfo2)
 
 	defer file1.close_file()
 	defer file2.close_file()
@@ -1661,229 +1019,282 @@ func diff_file(filename1, filename2 string, finfo1, finfo2 os.FileInfo) {
 		// display error messages
 		output_diff_message(filename1, filename2, finfo1, finfo2, file1.errormsg, file2.errormsg, true)
 		return
-	} else if bytes.Equal(file1.data, file2.data) {
-		// files are equal
+	} else if bytes.Equal(file1.raw, file2.raw) {
+		// same bytes
 		if flag_show_identical_files {
 			output_diff_message(filename1, filename2, finfo1, finfo2, MSG_FILE_IDENTICAL, MSG_FILE_IDENTICAL, false)
 		}
 		return
+	} else if bytes.Equal(file1.data, file2.data) {
+		// files are equal, once converted to utf-8
+		if file1.encoding != file2.encoding {
+			output_diff_message(filename1, filename2, finfo1, finfo2, fmt.Sprintf(MSG_ENCODING_DIFFERS, file1.encoding), fmt.Sprintf(MSG_ENCODING_DIFFERS, file2.encoding), false)
+		} else if flag_show_identical_files {
+			output_diff_message(filename1, filename2, finfo1, finfo2, MSG_FILE_IDENTICAL, MSG_FILE_IDENTICAL, false)
+		}
+		return
 	}
 
 	lines1 := file1.split_lines()
 	lines2 := file2.split_lines()
 
-	if file1.is_binary || file2.is_binary {
+	var opts *diff.Options
+	var msg string // shown before the changes
 
-		var msg1, msg2 string
+	if file1.is_binary || file2.is_binary {
 
-		if file1.is_binary {
-			msg1 = MSG_BIN_FILE_DIFFERS
-		} else {
-			msg1 = MSG_FILE_DIFFERS
+		// the original bytes, not converted from their detected encoding
+		if image1, image2 := decode_image(file1.raw), decode_image(file2.raw); image1 != nil && image2 != nil {
+			// compare the pixels
+			res := compare_images(image1, image2, flag_image_tolerance)
+			if !res.same() {
+				diff_image_file(filename1, filename2, finfo1, finfo2, res)
+			} else if flag_show_identical_files {
+				output_diff_message(filename1, filename2, finfo1, finfo2, MSG_IMAGE_SAME, MSG_IMAGE_SAME, false)
+			}
+			return
 		}
 
-		if file2.is_binary {
-			msg2 = MSG_BIN_FILE_DIFFERS
-		} else {
-			msg2 = MSG_FILE_DIFFERS
-		}
+		if !flag_hex_dump || len(file1.raw) > HEX_DUMP_MAX_SIZE || len(file2.raw) > HEX_DUMP_MAX_SIZE {
+			var msg1, msg2 string
 
-		if msg1 != "" || msg2 != "" {
-			output_diff_message(filename1, filename2, finfo1, finfo2, msg1, msg2, true)
-		}
-	} else {
-		// Compute equiv ids for each line.
-		info1, info2 := find_equiv_lines(lines1, lines2)
+			if file1.is_binary {
+				msg1 = MSG_BIN_FILE_DIFFERS
+			} else {
+				msg1 = MSG_FILE_DIFFERS
+			}
 
-		// No zids avaiable, no need to run diff comparision algorithm
-		// The find_equiv_lines() function may have perform the comparison already.
-		if info1.zids != nil && info2.zids != nil {
-			// run the diff algorithm
-			zchange1, zchange2 := do_diff(info1.zids, info2.zids)
+			if file2.is_binary {
+				msg2 = MSG_BIN_FILE_DIFFERS
+			} else {
+				msg2 = MSG_FILE_DIFFERS
+			}
 
-			// expand the change list, so that change array contains changes to actual lines
-			expand_change_list(info1, info2, zchange1, zchange2)
+			output_diff_message(filename1, filename2, finfo1, finfo2, msg1, msg2, true)
+			return
 		}
 
-		// perform shift boundary
-		shift_boundaries(info1.ids, info1.change, nil)
-		shift_boundaries(info2.ids, info2.change, nil)
-
-		chg_data := DiffChangerData{
-			OutputFormat: &OutputFormat{
-				name1:        filename1,
-				name2:        filename2,
-				fileinfo1:    finfo1,
-				fileinfo2:    finfo2,
-				lineno_width: len(fmt.Sprintf("%d", utils.MaxInt(len(lines1), len(lines2)))),
-			},
-			file1: lines1,
-			file2: lines2,
-		}
+		// compare the hex dump rows
+		lines1, lines2 = hex_dump(file1.raw), hex_dump(file2.raw)
+		file1.eols, file2.eols = nil, nil
+		opts = hex_dump_options()
+		msg = hex_dump_message(file1.raw, file2.raw)
+	} else {
+		opts = options_for_file(filename1, filename2)
+	}
 
-		var chg DiffChanger
+	// run the diff engine
+	res := diff.CompareEOL(lines1, lines2, file1.eols, file2.eols, opts)
 
-		// Choose change output format: text or html
-		if flag_output_as_text {
-			if flag_unified_context {
-				chg = &DiffChangerUnifiedText{DiffChangerData: chg_data}
-			} else {
-				chg = &DiffChangerText{DiffChangerData: chg_data}
-			}
-		} else {
-			if flag_unified_context {
-				chg = &DiffChangerUnifiedHtml{DiffChangerData: chg_data}
-			} else {
-				chg = &DiffChangerHtml{DiffChangerData: chg_data}
-			}
-		}
+	chg_data := DiffChangerData{
+		OutputFormat: &OutputFormat{
+			name1:        filename1,
+			name2:        filename2,
+			fileinfo1:    finfo1,
+			fileinfo2:    finfo2,
+			lineno_width: len(fmt.Sprintf("%d", utils.MaxInt(len(lines1), len(lines2)))),
+		},
+		file1: lines1,
+		file2: lines2,
+		opts:  opts,
+		moves: res.Moves,
+		seq:   int(atomic.AddInt32(&file_seq, 1)),
+	}
+	chg_data.eol_notes1, chg_data.eol_notes2 = eol_notes(res)
+	chg_data.label1, chg_data.label2 = file_labels(file1, file2)
 
-		// output diff results
-		changed := report_diff(chg, info1.ids, info2.ids, info1.change, info2.change)
+	chg := new_diff_changer(chg_data)
 
-		if chg_data.header_printed {
-			if !flag_output_as_text {
-				out.WriteString("</table><br>\n")
-			}
-			chg_data.header_printed = false
-			out_release_lock()
-		}
+	// output diff results
+	if msg != "" {
+		chg.diff_message(msg)
+	}
+	if res.EolChanged {
+		chg.diff_message(fmt.Sprintf(MSG_EOL_CHANGED, diff.EolName(diff.EolStyle(res.Eols1)), diff.EolName(diff.EolStyle(res.Eols2))))
+	}
+	for _, hunk := range res.Hunks {
+		chg.diff_lines(hunk.Ops)
+	}
+	changed := res.Changed()
 
-		if !changed && flag_show_identical_files {
-			// report on identical file if required
-			output_diff_message(filename1, filename2, finfo1, finfo2, MSG_FILE_IDENTICAL, MSG_FILE_IDENTICAL, false)
+	if chg_data.header_printed {
+		if !flag_output_as_text {
+			out.WriteString("</table><br>\n")
 		}
+		chg_data.header_printed = false
+		out_release_lock()
+	}
+
+	if !changed && flag_show_identical_files {
+		// report on identical file if required
+		output_diff_message(filename1, filename2, finfo1, finfo2, MSG_FILE_IDENTICAL, MSG_FILE_IDENTICAL, false)
 	}
 }
 
-// Perform the shift
-func do_shift_boundary(start, end, offset int, change []bool) {
-	if offset < 0 {
-		for offset != 0 {
-			start, end, offset = start-1, end-1, offset+1
-			change[start], change[end] = true, false
-		}
-	} else {
-		for offset != 0 {
-			change[start], change[end] = false, true
-			start, end, offset = start+1, end+1, offset-1
+// Choose change output format: text or html
+func new_diff_changer(chg_data DiffChangerData) DiffChanger {
+	if flag_output_as_text {
+		if flag_unified_context {
+			return &DiffChangerUnifiedText{DiffChangerData: chg_data}
 		}
+		return &DiffChangerText{DiffChangerData: chg_data}
+	}
+	if flag_unified_context {
+		return &DiffChangerUnifiedHtml{DiffChangerData: chg_data}
 	}
+	return &DiffChangerHtml{DiffChangerData: chg_data}
 }
 
-// Determine if the changes starting at 'pos' can be shifted 'up' or 'down'
-func find_shift_boundary(start int, data []int, change []bool) (int, int, int, bool, bool) {
-	end, dlen := start+1, len(data)
-	up, down := 0, 0
+//
+// Notes shown after the lines with a line ending different from most lines, and after a last line without newline.
+// Return nil if there are no notes for a file.
+//
+func eol_notes(res *diff.Result) ([]string, []string) {
 
-	// Find the end of this chunk of changes
-	for end < dlen && change[end] {
-		end++
+	if res.Eols1 == nil || res.Eols2 == nil {
+		return nil, nil
 	}
 
-	for start-up-1 >= 0 && !change[start-up-1] && data[start-up-1] == data[end-up-1] {
-		up = up + 1
+	// most common line ending in both files
+	var count [diff.EOL_MIXED]int
+	for _, eols := range [][]int{res.Eols1, res.Eols2} {
+		for _, eol := range eols {
+			count[eol]++
+		}
+	}
+	common := diff.EOL_LF
+	for eol := diff.EOL_LF; eol <= diff.EOL_CR; eol++ {
+		if count[eol] > count[common] {
+			common = eol
+		}
 	}
 
-	for end+down < dlen && !change[end+down] && data[end+down] == data[start+down] {
-		down = down + 1
+	notes := func(eols []int) []string {
+		var notes []string
+		for i, eol := range eols {
+			var msg string
+			switch {
+			case eol == diff.EOL_NONE:
+				msg = MSG_NO_NEWLINE
+			case eol != common && !res.EolChanged:
+				msg = fmt.Sprintf(MSG_LINE_EOL, diff.EolName(eol))
+			default:
+				continue
+			}
+			if notes == nil {
+				notes = make([]string, len(eols))
+			}
+			notes[i] = msg
+		}
+		return notes
 	}
 
-	// has changes been shifted to start/end of list or merged with previous/next change
-	up_merge := (start-up == 0) || change[start-up-1]
-	down_merge := (end+down == dlen) || change[end+down]
+	return notes(res.Eols1), notes(res.Eols2)
+}
 
-	return end, up, down, up_merge, down_merge
+//
+// Notes for the lines start to end of file1 (side 1) or file2 (side 2), nil if none.
+//
+func (chg *DiffChangerData) notes(side, start, end int) []string {
+	notes := chg.eol_notes1
+	if side == 2 {
+		notes = chg.eol_notes2
+	}
+	if notes == nil {
+		return nil
+	}
+	return notes[start:end]
 }
 
-// scoring function for shifting characters in a line.
-func rune_edge_score(r rune) int {
+// Regexps for the -norm presets, and what to replace them with
+var normalizer_presets = map[string][2]string{
+	"uuid":      {`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`, "<UUID>"},
+	"ipv4":      {`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}(:\d+)?\b`, "<IPV4>"},
+	"timestamp": {`\d{4}-\d\d-\d\d[T ]\d\d:\d\d(:\d\d([.,]\d+)?)?(Z|[+-]\d\d:?\d\d)?`, "<TIMESTAMP>"},
+}
 
-	switch r {
-	case ' ', '\t', '\v', '\f':
-		return 100
+//
+// Parse the value of a -norm option: trim, tabs[:N], a preset name or s/REGEXP/REPLACEMENT/
+// Any character can be use in place of '/' in the s command.
+//
+func parse_normalizer(s string) (diff.Normalizer, error) {
 
-	case '<', '>', '(', ')', '[', ']', '\'', '"':
-		return 40
+	if preset, ok := normalizer_presets[s]; ok {
+		return diff.ReplaceNormalizer(regexp.MustCompile(preset[0]), []byte(preset[1])), nil
 	}
 
-	return 0
-}
-
-// scoring character boundary, for finding a change chunk that is easier to read
-func rune_bouundary_score(r1, r2 int) int {
+	switch {
+	case s == "trim":
+		return diff.TrimNormalizer(), nil
+
+	case s == "tabs" || strings.HasPrefix(s, "tabs:"):
+		tab_width := diff.TAB_WIDTH
+		if s != "tabs" {
+			n, err := strconv.Atoi(s[5:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid tab width: %s", s)
+			}
+			tab_width = n
+		}
+		return diff.ExpandTabsNormalizer(tab_width), nil
 
-	s1 := rune_edge_score(rune(r1))
-	s2 := rune_edge_score(rune(r2))
+	case len(s) > 2 && s[0] == 's':
+		parts := strings.Split(s[2:], s[1:2])
+		if len(parts) != 3 || parts[2] != "" {
+			return nil, fmt.Errorf("expecting s/REGEXP/REPLACEMENT/: %s", s)
+		}
+		re, err := regexp.Compile(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		return diff.ReplaceNormalizer(re, []byte(parts[1])), nil
+	}
 
-	return s1 + s2
+	return nil, fmt.Errorf("unknown normalizer: %s", s)
 }
 
 //
-// shift changes up or down to make it more readable.
+// Options for the diff engine, with the options for the file name patterns added: -Ifile.
 //
-func shift_boundaries(data []int, change []bool, boundary_score func(int, int) int) {
+func options_for_file(filename1, filename2 string) *diff.Options {
 
-	start, clen := 0, len(change)
+	opts := diff_options
 
-	for start < clen {
-		// find the next chunk of changes
-		for start < clen && !change[start] {
-			start++
-		}
-		if start >= clen {
-			break
+	for _, fo := range file_options {
+		if len(fo.ignore_regexps) == 0 || !fo.match(filename1, filename2) {
+			continue
 		}
 
-		// find the limit of where this set of changes can be shifted
-		end, up, down, up_merge, down_merge := find_shift_boundary(start, data, change)
-
-		// The chunk is already at the start, do not shift downwards
-		if start == 0 {
-			up, down = 0, 0
+		// copy the global options before changing them
+		if opts == diff_options {
+			o := *diff_options
+			opts = &o
 		}
+		opts.IgnoreRegexps = append(opts.IgnoreRegexps[:len(opts.IgnoreRegexps):len(opts.IgnoreRegexps)], fo.ignore_regexps...)
+	}
 
-		switch {
-		case up > 0 && up_merge:
-			// shift up, merged with previous chunk of changes
-			do_shift_boundary(start, end, -up, change)
-			// restart at the begining of this merged chunk
-			nstart := start
-			for nstart -= up; nstart-1 >= 0 && change[nstart-1]; nstart-- {
-			}
-			if nstart > 0 {
-				start = nstart
-			}
+	return opts
+}
 
-		case down > 0 && down_merge:
-			// shift down, merged with next chunk of changes
-			do_shift_boundary(start, end, down, change)
-			start += down
-
-		case (up > 0 || down > 0) && boundary_score != nil:
-			// Only perform shifts when there is a boundary score function
-			offset, best_score := 0, boundary_score(data[start], data[end-1])
-			for i := -up; i <= down; i++ {
-				if i != 0 {
-					score := boundary_score(data[start+i], data[end+i-1])
-					if score > best_score {
-						offset, best_score = i, score
-					}
-				}
-			}
-			if offset != 0 {
-				do_shift_boundary(start, end, offset, change)
-			}
-			start = end
-			if offset > 0 {
-				start += offset
-			}
+// Report if any of the file names match the glob pattern of the options
+func (fo *FileOptions) match(names ...string) bool {
+	return match_glob(fo.glob, names...)
+}
 
-		default:
-			// no shift
-			start = end
+//
+// Report if any of the file names match a glob pattern.
+// A glob pattern with a path separator is matched with the full name, otherwise just the base name.
+//
+func match_glob(glob string, names ...string) bool {
+	for _, name := range names {
+		if !strings.Contains(glob, "/") && !strings.Contains(glob, PATH_SEPARATOR) {
+			name = filepath.Base(name)
+		}
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
 		}
 	}
+	return false
 }
 
 // Wait for all jobs to finish
@@ -1909,7 +1320,12 @@ func job_queue_init() {
 		for i := 0; i < flag_max_goroutines; i++ {
 			go func() {
 				for job := range job_queue {
-					diff_file(job.name1, job.name2, job.info1, job.info2)
+					if job.merge_name != "" {
+						merge_file(job.name0, job.name1, job.name2, job.info0, job.info1, job.info2, job.merge_name)
+					} else {
+						diff_file(job.name1, job.name2, job.info1, job.info2)
+						release_archives(job.name1, job.name2)
+					}
 					job_wait.Done()
 				}
 			}()
@@ -1919,6 +1335,7 @@ func job_queue_init() {
 
 // Queue file comparison task
 func queue_diff_file(fname1, fname2 string, finfo1, finfo2 os.FileInfo) {
+	hold_archives(fname1, fname2)
 	job_wait.Add(1)
 	job_queue <- JobQueue{
 		name1: fname1,
@@ -1928,6 +1345,20 @@ func queue_diff_file(fname1, fname2 string, finfo1, finfo2 os.FileInfo) {
 	}
 }
 
+// Queue three-way merge task
+func queue_merge_file(fname0, fname1, fname2 string, finfo0, finfo1, finfo2 os.FileInfo, merge_name string) {
+	job_wait.Add(1)
+	job_queue <- JobQueue{
+		name0:      fname0,
+		name1:      fname1,
+		name2:      fname2,
+		info0:      finfo0,
+		info1:      finfo1,
+		info2:      finfo2,
+		merge_name: merge_name,
+	}
+}
+
 // Acquire Mutext lock on output stream
 func out_acquire_lock() {
 	if flag_max_goroutines > 1 {
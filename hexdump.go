@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rsrini7/godiff/diff"
+)
+
+const (
+	// Bytes shown on each row of a hex dump
+	HEX_DUMP_ROW_SIZE = 16
+
+	// Width of the offset at the start of a hex dump row, ie. "00000010  "
+	HEX_DUMP_OFFSET_WIDTH = 10
+
+	// Binary files greater than this size are not shown as a hex dump, only reported as different
+	HEX_DUMP_MAX_SIZE = 16 * 1024 * 1024
+)
+
+//
+// Format data as rows of 16 bytes, in the same layout as hexdump -C:
+// "00000010  48 65 6c 6c 6f 0a 00 01  02 03 04 05 06 07 08 09  |Hello...........|"
+//
+func hex_dump(data []byte) [][]byte {
+
+	const hex_digits = "0123456789abcdef"
+
+	rows := make([][]byte, 0, (len(data)+HEX_DUMP_ROW_SIZE-1)/HEX_DUMP_ROW_SIZE)
+	for offset := 0; offset < len(data); offset += HEX_DUMP_ROW_SIZE {
+		end := offset + HEX_DUMP_ROW_SIZE
+		if end > len(data) {
+			end = len(data)
+		}
+
+		row := make([]byte, 0, HEX_DUMP_OFFSET_WIDTH+HEX_DUMP_ROW_SIZE*4+4)
+		row = append(row, fmt.Sprintf("%08x  ", offset)...)
+		for i := offset; i < offset+HEX_DUMP_ROW_SIZE; i++ {
+			if i < end {
+				row = append(row, hex_digits[data[i]>>4], hex_digits[data[i]&0xf], ' ')
+			} else {
+				row = append(row, "   "...)
+			}
+			if i-offset == HEX_DUMP_ROW_SIZE/2-1 {
+				row = append(row, ' ')
+			}
+		}
+		row = append(row, " |"...)
+		for _, b := range data[offset:end] {
+			if b < ' ' || b > '~' {
+				b = '.'
+			}
+			row = append(row, b)
+		}
+		rows = append(rows, append(row, '|'))
+	}
+	return rows
+}
+
+//
+// Options to compare hex dump rows: the offsets are ignored, so that the rows after
+// inserted or removed bytes still match. Changes within rows are shown by bytes.
+//
+func hex_dump_options() *diff.Options {
+
+	opts := diff.NewOptions()
+	opts.ContextLines = diff_options.ContextLines
+	opts.Algorithm = diff_options.Algorithm
+	opts.Minimal = diff_options.Minimal
+	opts.CostLimit = diff_options.CostLimit
+	opts.DetectMoves = diff_options.DetectMoves
+	opts.Tokenizer = diff.TOKENIZE_SPACE
+	opts.IgnoreEOL = true
+	opts.Normalizers = []diff.Normalizer{func(row []byte) []byte {
+		return row[HEX_DUMP_OFFSET_WIDTH:]
+	}}
+	return opts
+}
+
+//
+// Describe the differences in the same way as cmp: the offset of the first byte that differs,
+// and the number of bytes that differ at the same offset, plus the bytes after the end of the shortest file.
+//
+func hex_dump_message(data1, data2 []byte) string {
+
+	first, count := -1, 0
+	for i := 0; i < len(data1) && i < len(data2); i++ {
+		if data1[i] != data2[i] {
+			if first < 0 {
+				first = i
+			}
+			count++
+		}
+	}
+
+	n := len(data1) - len(data2)
+	if n < 0 {
+		n = -n
+	}
+	if n > 0 && first < 0 {
+		first = len(data1)
+		if len(data2) < first {
+			first = len(data2)
+		}
+	}
+	return fmt.Sprintf(MSG_BIN_FIRST_DIFF, first, first, count+n)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiffFileHexDump(t *testing.T) {
+
+	defer func(hex bool) { flag_hex_dump = hex }(flag_hex_dump)
+	flag_hex_dump = true
+
+	// not valid utf-8 and no zero byte, they must not be converted from Windows-1252
+	data1 := random_bytes(1000, 3)
+	for _, changed := range [][]byte{{0x01, 0x02}, {0x00, 0x03}} {
+		data2 := append([]byte(nil), data1...)
+		copy(data2[50:], changed)
+		if data2[50] == data1[50] || data2[51] == data1[51] {
+			t.Fatalf("the bytes at offset 50 are not changed")
+		}
+
+		dir, names := write_test_files(t, ".bin", data1, data2)
+		got := diff_file_text(t, names[0], names[1])
+		os.RemoveAll(dir)
+
+		if want := fmt.Sprintf(MSG_BIN_FIRST_DIFF, 50, 50, 2); !strings.Contains(got, want) {
+			t.Errorf("%x: got %q, want %q", changed, got, want)
+		}
+		// the rows of the original bytes
+		if row := string(hex_dump(data2)[3]); !strings.Contains(got, row[HEX_DUMP_OFFSET_WIDTH:]) {
+			t.Errorf("%x: row %q not found in %q", changed, row, got)
+		}
+		if strings.Contains(got, "ISO-8859-1") || strings.Contains(got, "Windows-1252") {
+			t.Errorf("%x: binary files shown as converted: %q", changed, got)
+		}
+	}
+}
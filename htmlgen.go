@@ -1,14 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"html"
 	"os"
-	"strings"
 	"time"
 
+	"github.com/rsrini7/godiff/diff"
 	"github.com/rsrini7/godiff/utils"
 )
 
@@ -68,35 +67,54 @@ func html_file_table_unified(outfmt *OutputFormat) {
 		if outfmt.fileinfo1 != nil {
 			fmt.Fprintf(out, " <span class=\"inf\">%d %s</span>", outfmt.fileinfo1.Size(), outfmt.fileinfo1.ModTime().Format(time.RFC1123))
 		}
+		write_html_file_label(outfmt.label1)
 		out.WriteString("<br><span class=\"hdr\">")
 		out.WriteString(html.EscapeString(outfmt.name2))
 		out.WriteString("</span>")
 		if outfmt.fileinfo2 != nil {
 			fmt.Fprintf(out, " <span class=\"inf\">%d %s</span>", outfmt.fileinfo2.Size(), outfmt.fileinfo2.ModTime().Format(time.RFC1123))
 		}
+		write_html_file_label(outfmt.label2)
 		out.WriteString("</td></tr>")
 	}
 }
 
-func (chg *DiffChangerUnifiedHtml) diff_lines(ops []DiffOp) {
+func (chg *DiffChangerUnifiedHtml) diff_message(msg string) {
+	html_file_table_unified(chg.OutputFormat)
+	out.WriteString("<tr><td class=\"ttd\"><span class=\"msg\">")
+	out.WriteString(html.EscapeString(msg))
+	out.WriteString("</span></td></tr>\n")
+}
+
+func (chg *DiffChangerUnifiedHtml) diff_lines(ops []diff.DiffOp) {
 
 	html_file_table_unified(chg.OutputFormat)
 	chg.buf1.Reset()
 
 	for _, v := range ops {
-		switch v.op {
-		case DIFF_OP_INSERT:
-			write_html_lines_unified(&chg.buf1, "add", "+", chg.file2[v.start2:v.end2], -1, v.start2, chg.lineno_width)
-
-		case DIFF_OP_REMOVE:
-			write_html_lines_unified(&chg.buf1, "del", "-", chg.file1[v.start1:v.end1], v.start1, -1, chg.lineno_width)
-
-		case DIFF_OP_MODIFY:
-			write_html_lines_unified(&chg.buf1, "del", "-", chg.file1[v.start1:v.end1], v.start1, -1, chg.lineno_width)
-			write_html_lines_unified(&chg.buf1, "add", "+", chg.file2[v.start2:v.end2], -1, v.start2, chg.lineno_width)
+		switch v.Op {
+		case diff.DIFF_OP_INSERT:
+			write_html_lines_unified(&chg.buf1, "add", "+", chg.file2[v.Start2:v.End2], chg.notes(2, v.Start2, v.End2), -1, v.Start2, chg.lineno_width)
+
+		case diff.DIFF_OP_REMOVE:
+			write_html_lines_unified(&chg.buf1, "del", "-", chg.file1[v.Start1:v.End1], chg.notes(1, v.Start1, v.End1), v.Start1, -1, chg.lineno_width)
+
+		case diff.DIFF_OP_MODIFY:
+			write_html_lines_unified(&chg.buf1, "del", "-", chg.file1[v.Start1:v.End1], chg.notes(1, v.Start1, v.End1), v.Start1, -1, chg.lineno_width)
+			write_html_lines_unified(&chg.buf1, "add", "+", chg.file2[v.Start2:v.End2], chg.notes(2, v.Start2, v.End2), -1, v.Start2, chg.lineno_width)
+
+		case diff.DIFF_OP_MOVE:
+			m := chg.moves[v.Move-1]
+			if v.End1 > v.Start1 {
+				write_html_lines_unified_moved(&chg.buf1, "-", chg.file1[v.Start1:v.End1], chg.notes(1, v.Start1, v.End1), v.Start1, -1, chg.lineno_width,
+					chg.move_anchor(v.Move, 1), chg.move_anchor(v.Move, 2), fmt.Sprintf("moved to line %d", m.Start2+1))
+			} else {
+				write_html_lines_unified_moved(&chg.buf1, "+", chg.file2[v.Start2:v.End2], chg.notes(2, v.Start2, v.End2), -1, v.Start2, chg.lineno_width,
+					chg.move_anchor(v.Move, 2), chg.move_anchor(v.Move, 1), fmt.Sprintf("moved from line %d", m.Start1+1))
+			}
 
 		default:
-			write_html_lines_unified(&chg.buf1, "nop", " ", chg.file1[v.start1:v.end1], v.start1, v.start2, chg.lineno_width)
+			write_html_lines_unified(&chg.buf1, "nop", " ", chg.file1[v.Start1:v.End1], chg.notes(1, v.Start1, v.End1), v.Start1, v.Start2, chg.lineno_width)
 		}
 	}
 
@@ -116,17 +134,26 @@ func html_file_table(outfmt *OutputFormat) {
 		if outfmt.fileinfo1 != nil {
 			fmt.Fprintf(out, "<br><span class=\"inf\">%d %s</span>", outfmt.fileinfo1.Size(), outfmt.fileinfo1.ModTime().Format(time.RFC1123))
 		}
+		write_html_file_label(outfmt.label1)
 		out.WriteString("</td><td class=\"tth\"><span class=\"hdr\">")
 		out.WriteString(html.EscapeString(outfmt.name2))
 		out.WriteString("</span>")
 		if outfmt.fileinfo2 != nil {
 			fmt.Fprintf(out, "<br><span class=\"inf\">%d %s</span>", outfmt.fileinfo2.Size(), outfmt.fileinfo2.ModTime().Format(time.RFC1123))
 		}
+		write_html_file_label(outfmt.label2)
 		out.WriteString("</td></tr>")
 	}
 }
 
-func (chg *DiffChangerHtml) diff_lines(ops []DiffOp) {
+func (chg *DiffChangerHtml) diff_message(msg string) {
+	html_file_table(chg.OutputFormat)
+	out.WriteString("<tr><td class=\"ttd\" colspan=\"2\"><span class=\"msg\">")
+	out.WriteString(html.EscapeString(msg))
+	out.WriteString("</span></td></tr>\n")
+}
+
+func (chg *DiffChangerHtml) diff_lines(ops []diff.DiffOp) {
 
 	html_file_table(chg.OutputFormat)
 
@@ -134,82 +161,66 @@ func (chg *DiffChangerHtml) diff_lines(ops []DiffOp) {
 	chg.buf2.Reset()
 
 	for _, v := range ops {
-		switch v.op {
-		case DIFF_OP_INSERT:
-			write_html_blanks(&chg.buf1, v.end2-v.start2)
-			write_html_lines(&chg.buf2, "add", chg.file2[v.start2:v.end2], v.start2, chg.lineno_width)
-			writeDiffCSVDelta(&chg.diffbuf, chg.file2[v.start2])
-
-		case DIFF_OP_REMOVE:
-			write_html_lines(&chg.buf1, "del", chg.file1[v.start1:v.end1], v.start1, chg.lineno_width)
-			write_html_blanks(&chg.buf2, v.end1-v.start1)
-
-		case DIFF_OP_MODIFY:
-			chg.buf1.WriteString("<span class=\"upd\">")
-			chg.buf2.WriteString("<span class=\"upd\">")
-
-			start1, start2 := v.start1, v.start2
-
-			for start1 < v.end1 && start2 < v.end2 {
-
-				write_html_lineno(&chg.buf1, start1+1, chg.lineno_width)
-				write_html_lineno(&chg.buf2, start2+1, chg.lineno_width)
-
-				if flag_suppress_line_changes {
-					write_html_bytes(&chg.buf1, chg.file1[start1])
-					write_html_bytes(&chg.buf2, chg.file2[start2])
-				} else {
-					// report on changes within the line
-					line1, line2 := chg.file1[start1], chg.file2[start2]
-					pos1, cmp1 := split_runes(line1)
-					pos2, cmp2 := split_runes(line2)
-
-					change1, change2 := do_diff(cmp1, cmp2)
-
-					if change1 != nil {
-						// perform shift boundaries, to make the changes more readable
-						shift_boundaries(cmp1, change1, rune_bouundary_score)
-						shift_boundaries(cmp2, change2, rune_bouundary_score)
-
-						write_html_line_change(&chg.buf1, line1, pos1, change1)
-						write_html_line_change(&chg.buf2, line2, pos2, change2)
-
-						writeDiffCSVDelta(&chg.diffbuf, line2)
-					}
+		switch v.Op {
+		case diff.DIFF_OP_INSERT:
+			write_html_blanks(&chg.buf1, v.End2-v.Start2)
+			write_html_lines(&chg.buf2, "add", chg.file2[v.Start2:v.End2], chg.notes(2, v.Start2, v.End2), v.Start2, chg.lineno_width)
+
+		case diff.DIFF_OP_REMOVE:
+			write_html_lines(&chg.buf1, "del", chg.file1[v.Start1:v.End1], chg.notes(1, v.Start1, v.End1), v.Start1, chg.lineno_width)
+			write_html_blanks(&chg.buf2, v.End1-v.Start1)
+
+		case diff.DIFF_OP_MODIFY:
+			// pair up the most similar lines, the rest are shown as removed or inserted
+			pairs := diff.PairLines(chg.file1[v.Start1:v.End1], chg.file2[v.Start2:v.End2], chg.opts)
+
+			for i := 0; i < len(pairs); {
+				p := pairs[i]
+				j := i + 1
+				for j < len(pairs) && (pairs[j].Line1 < 0) == (p.Line1 < 0) && (pairs[j].Line2 < 0) == (p.Line2 < 0) {
+					j++
 				}
 
-				chg.buf1.WriteByte('\n')
-				chg.buf2.WriteByte('\n')
-				start1++
-				start2++
-			}
+				switch {
+				case p.Line2 < 0:
+					write_html_lines(&chg.buf1, "del", chg.file1[v.Start1+p.Line1:v.Start1+p.Line1+j-i], chg.notes(1, v.Start1+p.Line1, v.Start1+p.Line1+j-i), v.Start1+p.Line1, chg.lineno_width)
+					write_html_blanks(&chg.buf2, j-i)
 
-			chg.buf1.WriteString("</span>")
-			chg.buf2.WriteString("</span>")
+				case p.Line1 < 0:
+					write_html_blanks(&chg.buf1, j-i)
+					write_html_lines(&chg.buf2, "add", chg.file2[v.Start2+p.Line2:v.Start2+p.Line2+j-i], chg.notes(2, v.Start2+p.Line2, v.Start2+p.Line2+j-i), v.Start2+p.Line2, chg.lineno_width)
 
-			if start1 < v.end1 {
-				write_html_lines(&chg.buf1, "del", chg.file1[start1:v.end1], start1, chg.lineno_width)
-				write_html_blanks(&chg.buf2, v.end1-start1)
+				default:
+					chg.write_html_lines_modified(pairs[i:j], v.Start1, v.Start2)
+				}
+				i = j
 			}
 
-			if start2 < v.end2 {
-				write_html_blanks(&chg.buf1, v.end2-start2)
-				write_html_lines(&chg.buf2, "add", chg.file2[start2:v.end2], start2, chg.lineno_width)
+		case diff.DIFF_OP_MOVE:
+			m := chg.moves[v.Move-1]
+			if v.End1 > v.Start1 {
+				write_html_lines_moved(&chg.buf1, chg.file1[v.Start1:v.End1], chg.notes(1, v.Start1, v.End1), v.Start1, chg.lineno_width,
+					chg.move_anchor(v.Move, 1), chg.move_anchor(v.Move, 2), fmt.Sprintf("moved to line %d", m.Start2+1))
+				write_html_blanks(&chg.buf2, v.End1-v.Start1)
+			} else {
+				write_html_blanks(&chg.buf1, v.End2-v.Start2)
+				write_html_lines_moved(&chg.buf2, chg.file2[v.Start2:v.End2], chg.notes(2, v.Start2, v.End2), v.Start2, chg.lineno_width,
+					chg.move_anchor(v.Move, 2), chg.move_anchor(v.Move, 1), fmt.Sprintf("moved from line %d", m.Start1+1))
 			}
 
 		default:
-			n1, n2 := v.end1-v.start1, v.end2-v.start2
+			n1, n2 := v.End1-v.Start1, v.End2-v.Start2
 			maxn := utils.MaxInt(n1, n2)
 
 			if n1 > 0 {
-				write_html_lines(&chg.buf1, "nop", chg.file1[v.start1:v.end1], v.start1, chg.lineno_width)
+				write_html_lines(&chg.buf1, "nop", chg.file1[v.Start1:v.End1], chg.notes(1, v.Start1, v.End1), v.Start1, chg.lineno_width)
 			}
 			if n1 < maxn {
 				write_html_blanks(&chg.buf1, maxn-n1)
 			}
 
 			if n2 > 0 {
-				write_html_lines(&chg.buf2, "nop", chg.file2[v.start2:v.end2], v.start2, chg.lineno_width)
+				write_html_lines(&chg.buf2, "nop", chg.file2[v.Start2:v.End2], chg.notes(2, v.Start2, v.End2), v.Start2, chg.lineno_width)
 			}
 			if n2 < maxn {
 				write_html_blanks(&chg.buf2, maxn-n2)
@@ -222,26 +233,145 @@ func (chg *DiffChangerHtml) diff_lines(ops []DiffOp) {
 	out.WriteString("</td><td class=\"ttd\">")
 	out.Write(chg.buf2.Bytes())
 	out.WriteString("</td></tr>\n")
+}
+
+// Write the pairs of modified lines, with the changes within the lines
+func (chg *DiffChangerHtml) write_html_lines_modified(pairs []diff.LinePair, start1, start2 int) {
+
+	chg.buf1.WriteString("<span class=\"upd\">")
+	chg.buf2.WriteString("<span class=\"upd\">")
+
+	for _, p := range pairs {
+		lineno1, lineno2 := start1+p.Line1, start2+p.Line2
+
+		write_html_lineno(&chg.buf1, lineno1+1, chg.lineno_width)
+		write_html_lineno(&chg.buf2, lineno2+1, chg.lineno_width)
+
+		if flag_suppress_line_changes {
+			write_html_bytes(&chg.buf1, chg.file1[lineno1])
+			write_html_bytes(&chg.buf2, chg.file2[lineno2])
+		} else {
+			// report on changes within the line
+			line1, line2 := chg.file1[lineno1], chg.file2[lineno2]
+			pos1, change1, pos2, change2 := diff.DiffLine(line1, line2, chg.opts)
+
+			if change1 != nil {
+				write_html_line_change(&chg.buf1, line1, pos1, change1)
+				write_html_line_change(&chg.buf2, line2, pos2, change2)
+			} else {
+				// same text, ie. only the line ending has changed
+				write_html_bytes(&chg.buf1, line1)
+				write_html_bytes(&chg.buf2, line2)
+			}
+		}
+		write_html_note(&chg.buf1, chg.notes(1, lineno1, lineno1+1), 0)
+		write_html_note(&chg.buf2, chg.notes(2, lineno2, lineno2+1), 0)
+
+		chg.buf1.WriteByte('\n')
+		chg.buf2.WriteByte('\n')
+	}
 
-	writeDiffToCSV(chg.diffbuf.Bytes())
+	chg.buf1.WriteString("</span>")
+	chg.buf2.WriteString("</span>")
 }
 
-func writeDiffCSVDelta(buf *bytes.Buffer, line []byte) {
-	buf.Write(line)
-	buf.WriteString("\n")
+// Write the rows of csv files that changed, the changed cells are highlighted
+func (chg *DiffChangerHtml) diff_rows(res *utils.CsvDiff) {
+
+	html_file_table(chg.OutputFormat)
+
+	chg.buf1.Reset()
+	chg.buf2.Reset()
+
+	// the header, to name the columns
+	header := csv_cell_classes(res, nil)
+	write_html_csv_line(&chg.buf1, "nop", res.Header, header, 1, chg.lineno_width, chg.comma)
+	write_html_csv_line(&chg.buf2, "nop", res.Header, header, 1, chg.lineno_width, chg.comma)
+
+	for _, r := range res.Rows {
+		switch r.Change {
+		case utils.CSV_ROW_ADDED:
+			write_html_blanks(&chg.buf1, 1)
+			write_html_csv_line(&chg.buf2, "add", r.Row2, header, r.Record2+2, chg.lineno_width, chg.comma)
+
+		case utils.CSV_ROW_REMOVED:
+			write_html_csv_line(&chg.buf1, "del", r.Row1, header, r.Record1+2, chg.lineno_width, chg.comma)
+			write_html_blanks(&chg.buf2, 1)
+
+		case utils.CSV_ROW_MODIFIED:
+			classes := csv_cell_classes(res, r)
+			write_html_csv_line(&chg.buf1, "upd", r.Row1, classes, r.Record1+2, chg.lineno_width, chg.comma)
+			write_html_csv_line(&chg.buf2, "upd", r.Row2, classes, r.Record2+2, chg.lineno_width, chg.comma)
+		}
+	}
+
+	out.WriteString("<tr><td class=\"ttd\">")
+	out.Write(chg.buf1.Bytes())
+	out.WriteString("</td><td class=\"ttd\">")
+	out.Write(chg.buf2.Bytes())
+	out.WriteString("</td></tr>\n")
 }
 
-func writeDiffToCSV(buf []byte) {
-	output_csv_file, err := os.Create(flag_csv_delta)
-	if err != nil {
-		usage(err.Error())
+// Write the rows of csv files that changed, in unified format
+func (chg *DiffChangerUnifiedHtml) diff_rows(res *utils.CsvDiff) {
+
+	html_file_table_unified(chg.OutputFormat)
+	chg.buf1.Reset()
+
+	header := csv_cell_classes(res, nil)
+	write_html_csv_line_unified(&chg.buf1, "nop", " ", res.Header, header, 1, 1, chg.lineno_width, chg.comma)
+
+	for _, r := range res.Rows {
+		switch r.Change {
+		case utils.CSV_ROW_ADDED:
+			write_html_csv_line_unified(&chg.buf1, "add", "+", r.Row2, header, -1, r.Record2+2, chg.lineno_width, chg.comma)
+
+		case utils.CSV_ROW_REMOVED:
+			write_html_csv_line_unified(&chg.buf1, "del", "-", r.Row1, header, r.Record1+2, -1, chg.lineno_width, chg.comma)
+
+		case utils.CSV_ROW_MODIFIED:
+			classes := csv_cell_classes(res, r)
+			write_html_csv_line_unified(&chg.buf1, "del", "-", r.Row1, classes, r.Record1+2, -1, chg.lineno_width, chg.comma)
+			write_html_csv_line_unified(&chg.buf1, "add", "+", r.Row2, classes, -1, r.Record2+2, chg.lineno_width, chg.comma)
+		}
+	}
+
+	out.WriteString("<tr><td class=\"ttd\">")
+	out.Write(chg.buf1.Bytes())
+	out.WriteString("</td></tr>\n")
+}
+
+// Write a csv row as a line, see write_html_csv_row()
+func write_html_csv_line(buf *bytes.Buffer, class string, row []string, classes []string, lineno, lineno_width int, comma rune) {
+	fmt.Fprintf(buf, "<span class=\"%s\">", class)
+	write_html_lineno(buf, lineno, lineno_width)
+	write_html_csv_row(buf, row, classes, comma)
+	buf.WriteString("\n</span>")
+}
+
+// Write a csv row as a line, in unified format
+func write_html_csv_line_unified(buf *bytes.Buffer, class, mode string, row []string, classes []string, lineno1, lineno2, lineno_width int, comma rune) {
+	fmt.Fprintf(buf, "<span class=\"%s\">", class)
+	write_html_lineno_unified(buf, mode, lineno1, lineno2, lineno_width)
+	write_html_csv_row(buf, row, classes, comma)
+	buf.WriteString("\n</span>")
+}
+
+// Write a csv row, each cell in the html class of its column, see csv_cell_classes()
+func write_html_csv_row(buf *bytes.Buffer, row []string, classes []string, comma rune) {
+	line, pos := format_csv_record(row, comma)
+	for c := range row {
+		if c > 0 {
+			write_html_bytes(buf, line[pos[2*c-1]:pos[2*c]])
+		}
+		if classes[c] != "" {
+			fmt.Fprintf(buf, "<span class=\"%s\">", classes[c])
+		}
+		write_html_bytes(buf, line[pos[2*c]:pos[2*c+1]])
+		if classes[c] != "" {
+			buf.WriteString("</span>")
+		}
 	}
-	defer output_csv_file.Close()
-	outCSV := bufio.NewWriter(output_csv_file)
-	outCSV.WriteString(strings.Join(csvHeaderData, csvDelimiter))
-	outCSV.WriteString("\n")
-	outCSV.Write(buf)
-	outCSV.Flush()
 }
 
 // Write single line with changes
@@ -266,11 +396,11 @@ func write_html_line_change(buf *bytes.Buffer, line []byte, pos []int, change []
 	}
 }
 
-func write_html_lines_unified(buf *bytes.Buffer, class string, mode string, lines [][]byte, start1, start2, lineno_width int) {
+func write_html_lines_unified(buf *bytes.Buffer, class string, mode string, lines [][]byte, notes []string, start1, start2, lineno_width int) {
 	buf.WriteString("<span class=\"")
 	buf.WriteString(class)
 	buf.WriteString("\">")
-	for _, line := range lines {
+	for i, line := range lines {
 		if start1 >= 0 {
 			start1++
 		}
@@ -280,11 +410,60 @@ func write_html_lines_unified(buf *bytes.Buffer, class string, mode string, line
 		write_html_lineno_unified(buf, mode, start1, start2, lineno_width)
 
 		write_html_bytes(buf, line)
+		write_html_note(buf, notes, i)
 		buf.WriteByte('\n')
 	}
 	buf.WriteString("</span>")
 }
 
+// html anchor for the source (side=1) or destination (side=2) of a moved block
+func (chg *DiffChangerData) move_anchor(move, side int) string {
+	return fmt.Sprintf("mv%d_%d_%d", chg.seq, move, side)
+}
+
+// Write moved lines, the line numbers are linked to the other end of the move
+func write_html_lines_moved(buf *bytes.Buffer, lines [][]byte, notes []string, lineno, lineno_width int, anchor, target, title string) {
+	fmt.Fprintf(buf, "<span class=\"mov\" id=\"%s\">", anchor)
+	for i, line := range lines {
+		lineno++
+		fmt.Fprintf(buf, "<a href=\"#%s\" title=\"%s\">", target, title)
+		write_html_lineno(buf, lineno, lineno_width)
+		buf.WriteString("</a>")
+		write_html_bytes(buf, line)
+		write_html_note(buf, notes, i)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("</span>")
+}
+
+func write_html_lines_unified_moved(buf *bytes.Buffer, mode string, lines [][]byte, notes []string, start1, start2, lineno_width int, anchor, target, title string) {
+	fmt.Fprintf(buf, "<span class=\"mov\" id=\"%s\">", anchor)
+	for i, line := range lines {
+		if start1 >= 0 {
+			start1++
+		}
+		if start2 >= 0 {
+			start2++
+		}
+		fmt.Fprintf(buf, "<a href=\"#%s\" title=\"%s\">", target, title)
+		write_html_lineno_unified(buf, mode, start1, start2, lineno_width)
+		buf.WriteString("</a>")
+		write_html_bytes(buf, line)
+		write_html_note(buf, notes, i)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("</span>")
+}
+
+// Write the note after line i, see eol_notes()
+func write_html_note(buf *bytes.Buffer, notes []string, i int) {
+	if notes != nil && notes[i] != "" {
+		buf.WriteString("<span class=\"eol\">")
+		write_html_bytes(buf, []byte(notes[i]))
+		buf.WriteString("</span>")
+	}
+}
+
 func write_html_blanks(buf *bytes.Buffer, n int) {
 	buf.WriteString("<span class=\"nop\">")
 	for n > 0 {
@@ -321,14 +500,15 @@ func write_html_lineno_unified(buf *bytes.Buffer, mode string, lineno1, lineno2,
 	buf.WriteString(" </span>")
 }
 
-func write_html_lines(buf *bytes.Buffer, class string, lines [][]byte, lineno, lineno_width int) {
+func write_html_lines(buf *bytes.Buffer, class string, lines [][]byte, notes []string, lineno, lineno_width int) {
 	buf.WriteString("<span class=\"")
 	buf.WriteString(class)
 	buf.WriteString("\">")
-	for _, line := range lines {
+	for i, line := range lines {
 		lineno++
 		write_html_lineno(buf, lineno, lineno_width)
 		write_html_bytes(buf, line)
+		write_html_note(buf, notes, i)
 		buf.WriteByte('\n')
 	}
 	buf.WriteString("</span>")
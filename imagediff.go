@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+)
+
+const (
+	// Images with more pixels than this are only reported as binary files that differ
+	IMAGE_MAX_PIXELS = 25 * 1000 * 1000
+)
+
+// Pixel colours of the difference overlay
+var (
+	image_diff_color = color.NRGBA{0xFF, 0, 0, 0xFF} // pixels that differ
+	image_none_color = color.NRGBA{0, 0, 0, 0}       // outside of both images
+)
+
+// A decoded image file
+type ImageData struct {
+	img    image.Image
+	format string // "png", "jpeg" or "gif"
+	data   []byte // the file content, embedded in the html output
+}
+
+// Result of comparing two images, pixel by pixel
+type ImageDiff struct {
+	image1, image2 *ImageData
+	diff_pixels    int // pixels that differ by more than the tolerance, including the pixels only in one of the images
+	total_pixels   int // pixels in the area covered by both images
+	overlay        *image.NRGBA
+}
+
+//
+// Decode a png, jpeg or gif file. Return nil if the data is not an image, or the image is too big.
+//
+func decode_image(data []byte) *ImageData {
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > IMAGE_MAX_PIXELS {
+		return nil
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return &ImageData{img: img, format: format, data: data}
+}
+
+// Name of a colour model, ie. "RGBA", "paletted, 256 colours"
+func color_model_name(m color.Model) string {
+	switch m {
+	case color.RGBAModel:
+		return "RGBA"
+	case color.RGBA64Model:
+		return "RGBA64"
+	case color.NRGBAModel:
+		return "NRGBA"
+	case color.NRGBA64Model:
+		return "NRGBA64"
+	case color.AlphaModel:
+		return "alpha"
+	case color.Alpha16Model:
+		return "alpha16"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.YCbCrModel:
+		return "YCbCr"
+	case color.NYCbCrAModel:
+		return "NYCbCrA"
+	case color.CMYKModel:
+		return "CMYK"
+	}
+	if p, ok := m.(color.Palette); ok {
+		return fmt.Sprintf("paletted, %d colours", len(p))
+	}
+	return "unknown"
+}
+
+// Size of an image, ie. "640x480"
+func (img *ImageData) size() string {
+	return fmt.Sprintf("%dx%d", img.img.Bounds().Dx(), img.img.Bounds().Dy())
+}
+
+// Describe an image: "png 640x480 NRGBA"
+func (img *ImageData) String() string {
+	return fmt.Sprintf("%s %s %s", img.format, img.size(), color_model_name(img.img.ColorModel()))
+}
+
+//
+// Compare two images, pixel by pixel. Pixels are the same if none of the red, green, blue and alpha values
+// differ by more than tolerance (0 to 255). The overlay shows the pixels that differ in red, over a faded copy of image1.
+//
+func compare_images(image1, image2 *ImageData, tolerance int) *ImageDiff {
+
+	b1, b2 := image1.img.Bounds(), image2.img.Bounds()
+	w, h := b1.Dx(), b1.Dy()
+	if b2.Dx() > w {
+		w = b2.Dx()
+	}
+	if b2.Dy() > h {
+		h = b2.Dy()
+	}
+
+	res := &ImageDiff{image1: image1, image2: image2, total_pixels: w * h, overlay: image.NewNRGBA(image.Rect(0, 0, w, h))}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p1 := image.Pt(b1.Min.X+x, b1.Min.Y+y)
+			p2 := image.Pt(b2.Min.X+x, b2.Min.Y+y)
+			in1, in2 := p1.In(b1), p2.In(b2)
+
+			switch {
+			case !in1 && !in2:
+				res.overlay.SetNRGBA(x, y, image_none_color)
+				continue
+			case in1 && in2 && same_color(image1.img.At(p1.X, p1.Y), image2.img.At(p2.X, p2.Y), tolerance):
+				res.overlay.SetNRGBA(x, y, faded_color(image1.img.At(p1.X, p1.Y)))
+				continue
+			}
+			res.diff_pixels++
+			res.overlay.SetNRGBA(x, y, image_diff_color)
+		}
+	}
+	return res
+}
+
+// Compare the 8 bit red, green, blue and alpha values of two colours
+func same_color(c1, c2 color.Color, tolerance int) bool {
+	r1, g1, b1, a1 := c1.RGBA()
+	r2, g2, b2, a2 := c2.RGBA()
+	for _, v := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}, {a1, a2}} {
+		d := int(v[0]>>8) - int(v[1]>>8)
+		if d > tolerance || -d > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// Light gray version of a colour, to show the pixels that are the same in the overlay
+func faded_color(c color.Color) color.NRGBA {
+	g := color.GrayModel.Convert(c).(color.Gray)
+	return color.NRGBA{0xC0 + g.Y/4, 0xC0 + g.Y/4, 0xC0 + g.Y/4, 0xFF}
+}
+
+// Report if the images are the same, within the tolerance
+func (res *ImageDiff) same() bool {
+	return res.diff_pixels == 0
+}
+
+//
+// Describe the changes: size, colour model and the percentage of pixels that differ
+//
+func (res *ImageDiff) messages() []string {
+
+	var msgs []string
+
+	if res.image1.format != res.image2.format {
+		msgs = append(msgs, fmt.Sprintf(MSG_IMAGE_FORMAT, res.image1.format, res.image2.format))
+	}
+	if size1, size2 := res.image1.size(), res.image2.size(); size1 != size2 {
+		msgs = append(msgs, fmt.Sprintf(MSG_IMAGE_SIZE, size1, size2))
+	}
+	if model1, model2 := color_model_name(res.image1.img.ColorModel()), color_model_name(res.image2.img.ColorModel()); model1 != model2 {
+		msgs = append(msgs, fmt.Sprintf(MSG_IMAGE_COLORS, model1, model2))
+	}
+	// no pixels in empty images
+	percent := 0.0
+	if res.total_pixels > 0 {
+		percent = 100 * float64(res.diff_pixels) / float64(res.total_pixels)
+	}
+	return append(msgs, fmt.Sprintf(MSG_IMAGE_PIXELS, percent, res.diff_pixels))
+}
+
+//
+// Image as a html img tag, with the data embedded in a data URI
+//
+func write_html_image(buf *bytes.Buffer, format string, data []byte) {
+	buf.WriteString("<img class=\"img\" src=\"data:image/")
+	buf.WriteString(format)
+	buf.WriteString(";base64,")
+	buf.WriteString(base64.StdEncoding.EncodeToString(data))
+	buf.WriteString("\">")
+}
+
+//
+// Output the differences of two images. In html, show both images and the difference overlay.
+//
+func diff_image_file(filename1, filename2 string, finfo1, finfo2 os.FileInfo, res *ImageDiff) {
+
+	msgs := res.messages()
+
+	if flag_output_as_text {
+		GenerateText(filename1, filename2, res.image1.String(), res.image2.String(), msgs...)
+		return
+	}
+
+	var overlay bytes.Buffer
+	if err := png.Encode(&overlay, res.overlay); err != nil {
+		output_diff_message(filename1, filename2, finfo1, finfo2, MSG_BIN_FILE_DIFFERS, err.Error(), true)
+		return
+	}
+
+	outfmt := &OutputFormat{name1: filename1, name2: filename2, fileinfo1: finfo1, fileinfo2: finfo2}
+
+	for i, img := range []*ImageData{res.image1, res.image2} {
+		buf := &outfmt.buf1
+		if i == 1 {
+			buf = &outfmt.buf2
+		}
+		buf.WriteString("<span class=\"msg\">")
+		write_html_bytes(buf, []byte(img.String()))
+		buf.WriteString("</span><br>")
+		write_html_image(buf, img.format, img.data)
+	}
+
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		buf.WriteString("<span class=\"msg\">")
+		write_html_bytes(&buf, []byte(msg))
+		buf.WriteString("</span><br>")
+	}
+	write_html_image(&buf, "png", overlay.Bytes())
+
+	if flag_unified_context {
+		html_file_table_unified(outfmt)
+		for _, b := range []*bytes.Buffer{&outfmt.buf1, &outfmt.buf2, &buf} {
+			out.WriteString("<tr><td class=\"ttd\">")
+			out.Write(b.Bytes())
+			out.WriteString("</td></tr>\n")
+		}
+	} else {
+		html_file_table(outfmt)
+		out.WriteString("<tr><td class=\"ttd\">")
+		out.Write(outfmt.buf1.Bytes())
+		out.WriteString("</td><td class=\"ttd\">")
+		out.Write(outfmt.buf2.Bytes())
+		out.WriteString("</td></tr>\n<tr><td class=\"ttd\" colspan=\"2\">")
+		out.Write(buf.Bytes())
+		out.WriteString("</td></tr>\n")
+	}
+	out.WriteString("</table><br>\n")
+	out_release_lock()
+}
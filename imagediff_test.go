@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+)
+
+// An image of one colour, with the given pixels changed
+func test_image(w, h int, c color.NRGBA, changed map[image.Point]color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	for p, c := range changed {
+		img.SetNRGBA(p.X, p.Y, c)
+	}
+	return img
+}
+
+// Encode an image as a png file, and decode it
+func test_png(t *testing.T, img image.Image, enc *png.Encoder) *ImageData {
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	res := decode_image(buf.Bytes())
+	if res == nil {
+		t.Fatal("not decoded as an image")
+	}
+	return res
+}
+
+func TestCompareImages(t *testing.T) {
+
+	gray := color.NRGBA{0x80, 0x80, 0x80, 0xFF}
+	img1 := &ImageData{img: test_image(4, 3, gray, nil), format: "png"}
+
+	tests := []struct {
+		name      string
+		img2      image.Image
+		tolerance int
+		diff      int
+		total     int
+	}{
+		{"same", test_image(4, 3, gray, nil), 0, 0, 12},
+		{"one pixel", test_image(4, 3, gray, map[image.Point]color.NRGBA{{1, 2}: {0x80, 0x80, 0x83, 0xFF}}), 0, 1, 12},
+		{"within the tolerance", test_image(4, 3, gray, map[image.Point]color.NRGBA{{1, 2}: {0x80, 0x80, 0x83, 0xFF}}), 3, 0, 12},
+		{"over the tolerance", test_image(4, 3, gray, map[image.Point]color.NRGBA{{1, 2}: {0x7C, 0x80, 0x80, 0xFF}}), 3, 1, 12},
+		{"alpha", test_image(4, 3, gray, map[image.Point]color.NRGBA{{0, 0}: {0x80, 0x80, 0x80, 0x00}}), 3, 1, 12},
+		// the pixels only in one of the images differ
+		{"wider", test_image(5, 3, gray, nil), 0, 3, 15},
+		{"smaller", test_image(3, 2, gray, nil), 0, 6, 12},
+		{"other bounds", test_image(5, 4, gray, nil).SubImage(image.Rect(1, 1, 5, 4)), 0, 0, 12},
+	}
+
+	for _, test := range tests {
+		res := compare_images(img1, &ImageData{img: test.img2, format: "png"}, test.tolerance)
+		if res.diff_pixels != test.diff || res.total_pixels != test.total || res.same() != (test.diff == 0) {
+			t.Errorf("%s: got %d of %d pixels differ, want %d of %d", test.name, res.diff_pixels, res.total_pixels, test.diff, test.total)
+		}
+		if b := res.overlay.Bounds(); b.Dx()*b.Dy() != test.total {
+			t.Errorf("%s: overlay %v", test.name, b)
+		}
+	}
+
+	if !same_color(color.NRGBA{10, 20, 30, 255}, color.NRGBA{12, 18, 30, 255}, 2) || same_color(color.NRGBA{10, 20, 30, 255}, color.NRGBA{13, 20, 30, 255}, 2) {
+		t.Errorf("same_color: tolerance not applied")
+	}
+}
+
+func TestImageMessages(t *testing.T) {
+
+	red := color.NRGBA{0xFF, 0, 0, 0xFF}
+	img := test_image(4, 4, red, nil)
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	image1, image2 := test_png(t, img, &png.Encoder{}), decode_image(buf.Bytes())
+
+	res := compare_images(image1, image2, 0)
+	msgs := strings.Join(res.messages(), "\n")
+	if want := fmt.Sprintf(MSG_IMAGE_FORMAT, "png", "gif"); !strings.Contains(msgs, want) {
+		t.Errorf("got %q, want %q", msgs, want)
+	}
+	if want := fmt.Sprintf(MSG_IMAGE_COLORS, color_model_name(image1.img.ColorModel()), color_model_name(image2.img.ColorModel())); !strings.Contains(msgs, want) {
+		t.Errorf("got %q, want %q", msgs, want)
+	}
+
+	image1 = &ImageData{img: image.NewNRGBA(image.Rect(0, 0, 2, 2)), format: "png"}
+	image2 = &ImageData{img: image.NewGray(image.Rect(0, 0, 2, 3)), format: "png"}
+	msgs = strings.Join(compare_images(image1, image2, 0).messages(), "\n")
+	for _, want := range []string{fmt.Sprintf(MSG_IMAGE_COLORS, "NRGBA", "gray"), fmt.Sprintf(MSG_IMAGE_SIZE, "2x2", "2x3")} {
+		if !strings.Contains(msgs, want) {
+			t.Errorf("got %q, want %q", msgs, want)
+		}
+	}
+	if strings.Contains(msgs, MSG_IMAGE_FORMAT[:10]) {
+		t.Errorf("got %q, the format is the same", msgs)
+	}
+
+	// empty images
+	image1 = &ImageData{img: image.NewNRGBA(image.Rect(0, 0, 0, 0)), format: "png"}
+	msgs = strings.Join(compare_images(image1, image1, 0).messages(), "\n")
+	if strings.Contains(msgs, "NaN") {
+		t.Errorf("got %q", msgs)
+	}
+}
+
+func TestDiffFileImages(t *testing.T) {
+
+	defer func(identical bool, tolerance int) {
+		flag_show_identical_files, flag_image_tolerance = identical, tolerance
+	}(flag_show_identical_files, flag_image_tolerance)
+	flag_show_identical_files = true
+
+	// the same pixels, compressed differently
+	img := test_image(8, 8, color.NRGBA{0x10, 0x20, 0x30, 0xFF}, map[image.Point]color.NRGBA{{3, 3}: {0x12, 0x20, 0x30, 0xFF}})
+	var buf1, buf2, buf3 bytes.Buffer
+	(&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&buf1, img)
+	(&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf2, img)
+	img.SetNRGBA(3, 3, color.NRGBA{0x10, 0x20, 0x30, 0xFF})
+	png.Encode(&buf3, img)
+	if bytes.Equal(buf1.Bytes(), buf2.Bytes()) {
+		t.Fatal("the png files are the same")
+	}
+
+	dir, names := write_test_files(t, ".png", buf1.Bytes(), buf2.Bytes(), buf3.Bytes())
+	defer os.RemoveAll(dir)
+
+	if got := diff_file_text(t, names[0], names[1]); !strings.Contains(got, MSG_IMAGE_SAME) {
+		t.Errorf("got %q, want %q", got, MSG_IMAGE_SAME)
+	}
+
+	want := fmt.Sprintf(MSG_IMAGE_PIXELS, 100.0/64, 1)
+	if got := diff_file_text(t, names[0], names[2]); !strings.Contains(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	flag_image_tolerance = 2
+	if got := diff_file_text(t, names[0], names[2]); !strings.Contains(got, MSG_IMAGE_SAME) {
+		t.Errorf("-image-tolerance 2: got %q, want %q", got, MSG_IMAGE_SAME)
+	}
+}
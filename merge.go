@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rsrini7/godiff/diff"
+	"github.com/rsrini7/godiff/utils"
+)
+
+const HTML_LEGEND_MERGE = `<br><b>Legend:</b><br><table class="tab">
+<tr><td class="tth"><span class="hdr">left</span></td><td class="tth"><span class="hdr">base</span></td><td class="tth"><span class="hdr">right</span></td></tr>
+<tr><td class="ttd">
+<span class="upd"><span class="lno">1 </span>changed in left</span>
+<span class="nop"><span class="lno">2 </span>no change</span>
+<span class="cfl"><span class="lno">3 </span>conflict</span>
+</td>
+<td class="ttd">
+<span class="nop"><span class="lno">1 </span>original</span>
+<span class="nop"><span class="lno">2 </span>no change</span>
+<span class="cfl"><span class="lno">3 </span>conflict</span>
+</td>
+<td class="ttd">
+<span class="nop"><span class="lno">1 </span>no change</span>
+<span class="nop"><span class="lno">2 </span>no change</span>
+<span class="cfl"><span class="lno">3 </span>conflict</span>
+</td></tr>
+</table>
+`
+
+// Order of the output columns: left, base, right
+var merge_columns = [3]int{1, 0, 2}
+
+// Output of a three-way compare, arrays are indexed by 0: base, 1: left, 2: right
+type MergeOutput struct {
+	names          [3]string
+	infos          [3]os.FileInfo
+	msgs           [3]string
+	is_error       bool
+	merged         string // name of the merged file
+	conflicts      int
+	header_printed bool
+	lineno_width   int
+}
+
+// Print the file names, and take the output lock until print_footer()
+func (m *MergeOutput) print_header() {
+
+	if m.header_printed {
+		return
+	}
+
+	out_acquire_lock()
+	m.header_printed = true
+
+	if flag_output_as_text {
+		for i, mark := range []string{"<<<", "|||", ">>>"} {
+			k := merge_columns[i]
+			fmt.Fprintf(out, "%s %s", mark, m.names[k])
+			if m.msgs[k] != "" {
+				fmt.Fprintf(out, ": %s", m.msgs[k])
+			}
+			out.WriteByte('\n')
+		}
+		if m.merged != "" {
+			fmt.Fprintf(out, "*** %s: %d conflicts\n", m.merged, m.conflicts)
+		}
+		return
+	}
+
+	span := "<span class=\"msg\">"
+	if m.is_error {
+		span = "<span class=\"err\">"
+	}
+
+	out.WriteString("<table class=\"tab\"><tr>")
+	for _, k := range merge_columns {
+		out.WriteString("<td class=\"tth\"><span class=\"hdr\">")
+		out.WriteString(html.EscapeString(m.names[k]))
+		out.WriteString("</span>")
+		if m.infos[k] != nil {
+			fmt.Fprintf(out, "<br><span class=\"inf\">%d %s</span>", m.infos[k].Size(), m.infos[k].ModTime().Format(time.RFC1123))
+		}
+		if m.msgs[k] != "" {
+			out.WriteString("<br>")
+			out.WriteString(span)
+			out.WriteString(html.EscapeString(m.msgs[k]))
+			out.WriteString("</span>")
+		}
+		if k == 0 && m.merged != "" {
+			fmt.Fprintf(out, "<br><span class=\"inf\">Merged to %s, %d conflicts</span>", html.EscapeString(m.merged), m.conflicts)
+		}
+		out.WriteString("</td>")
+	}
+	out.WriteString("</tr>")
+}
+
+func (m *MergeOutput) print_footer() {
+	if m.header_printed {
+		if !flag_output_as_text {
+			out.WriteString("</table><br>\n")
+		}
+		m.header_printed = false
+		out_release_lock()
+	}
+}
+
+//
+// Output a group of changes, as a row of 3 columns in html
+//
+func (m *MergeOutput) merge_lines_html(res *diff.MergeResult, ops []diff.MergeOp) {
+
+	var bufs [3]bytes.Buffer
+
+	for _, v := range ops {
+		lines := [3][][]byte{res.Base[v.Start0:v.End0], res.Left[v.Start1:v.End1], res.Right[v.Start2:v.End2]}
+		starts := [3]int{v.Start0, v.Start1, v.Start2}
+		classes := [3]string{"nop", "nop", "nop"}
+
+		switch v.Op {
+		case diff.MERGE_OP_LEFT:
+			classes[1] = "upd"
+		case diff.MERGE_OP_RIGHT:
+			classes[2] = "upd"
+		case diff.MERGE_OP_BOTH:
+			classes[1], classes[2] = "upd", "upd"
+		case diff.MERGE_OP_CONFLICT:
+			classes = [3]string{"cfl", "cfl", "cfl"}
+		}
+
+		maxn := utils.MaxInt(len(lines[0]), utils.MaxInt(len(lines[1]), len(lines[2])))
+		for k := range lines {
+			if len(lines[k]) > 0 {
+				write_html_lines(&bufs[k], classes[k], lines[k], nil, starts[k], m.lineno_width)
+			}
+			if len(lines[k]) < maxn {
+				write_html_blanks(&bufs[k], maxn-len(lines[k]))
+			}
+		}
+	}
+
+	out.WriteString("<tr>")
+	for _, k := range merge_columns {
+		out.WriteString("<td class=\"ttd\">")
+		out.Write(bufs[k].Bytes())
+		out.WriteString("</td>")
+	}
+	out.WriteString("</tr>\n")
+}
+
+//
+// Output a group of changes in diff3 format, files are numbered as in
+// "diff3 left base right": 1 is left, 2 is base and 3 is right.
+//
+func (m *MergeOutput) merge_lines_text(res *diff.MergeResult, ops []diff.MergeOp) {
+
+	for _, v := range ops {
+		lines := [4][][]byte{nil, res.Left[v.Start1:v.End1], res.Base[v.Start0:v.End0], res.Right[v.Start2:v.End2]}
+		starts := [4]int{0, v.Start1, v.Start0, v.Start2}
+
+		// print the files in this order, the lines of a file are omitted if same as the next file
+		order := []int{1, 2, 3}
+		same := [4]bool{}
+
+		switch v.Op {
+		case diff.MERGE_OP_SAME:
+			continue
+		case diff.MERGE_OP_LEFT:
+			out.WriteString("====1\n")
+			same[2] = true
+		case diff.MERGE_OP_RIGHT:
+			out.WriteString("====3\n")
+			same[1] = true
+		case diff.MERGE_OP_BOTH:
+			out.WriteString("====2\n")
+			order = []int{1, 3, 2}
+			same[1] = true
+		default:
+			out.WriteString("====\n")
+		}
+
+		for _, n := range order {
+			switch len(lines[n]) {
+			case 0:
+				fmt.Fprintf(out, "%d:%da\n", n, starts[n])
+			case 1:
+				fmt.Fprintf(out, "%d:%dc\n", n, starts[n]+1)
+			default:
+				fmt.Fprintf(out, "%d:%d,%dc\n", n, starts[n]+1, starts[n]+len(lines[n]))
+			}
+			if !same[n] {
+				for _, line := range lines[n] {
+					out.WriteString("  ")
+					out.Write(line)
+					out.WriteByte('\n')
+				}
+			}
+		}
+	}
+}
+
+//
+// Three-way compare of base, left and right files, and write the merged result to merge_name.
+// A nil FileInfo means the file does not exist, it is compared as an empty file.
+//
+func merge_file(filename0, filename1, filename2 string, finfo0, finfo1, finfo2 os.FileInfo, merge_name string) {
+
+	m := &MergeOutput{
+		names: [3]string{filename0, filename1, filename2},
+		infos: [3]os.FileInfo{finfo0, finfo1, finfo2},
+	}
+
+	var lines [3][][]byte
+	var eols [3][]int
+	var files [3]*Filedata
+
+	for k := range m.names {
+		if m.infos[k] == nil {
+			m.msgs[k] = MSG_FILE_NOT_EXISTS
+			continue
+		}
+		file := open_file(m.names[k], m.infos[k])
+		defer file.close_file()
+		files[k] = file
+
+		if file.errormsg == "" && len(file.data) > 0 {
+			lines[k], eols[k] = file.split_lines(), file.eols
+		}
+		if file.errormsg != "" {
+			m.msgs[k] = file.errormsg
+			m.is_error = true
+		}
+	}
+
+	if m.is_error {
+		m.print_header()
+		m.print_footer()
+		return
+	}
+
+	res := diff.Merge3EOL(lines[0], lines[1], lines[2], eols[0], eols[1], eols[2], diff_options)
+	m.conflicts = res.Conflicts
+
+	// write the merged file, a file deleted on one side and not changed on the other side stays deleted
+	// in the encoding of left, or base or right if it does not exist
+	var merged bytes.Buffer
+	res.WriteMerged(&merged, m.names[0], m.names[1], m.names[2])
+	if merged.Len() > 0 || (finfo1 != nil && finfo2 != nil) {
+		data := merged.Bytes()
+		for _, file := range []*Filedata{files[1], files[0], files[2]} {
+			if file != nil {
+				var err error
+				if data, err = file.encode_text(data); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %s\n", merge_name, err.Error())
+					data = merged.Bytes()
+				}
+				break
+			}
+		}
+		CreateDirIfNotExist(filepath.Dir(merge_name))
+		if err := ioutil.WriteFile(merge_name, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		} else {
+			m.merged = merge_name
+		}
+	}
+
+	if !res.Changed() {
+		if flag_show_identical_files {
+			m.msgs = [3]string{MSG_FILE_IDENTICAL, MSG_FILE_IDENTICAL, MSG_FILE_IDENTICAL}
+			m.print_header()
+			m.print_footer()
+		}
+		return
+	}
+
+	m.lineno_width = len(fmt.Sprintf("%d", utils.MaxInt(len(lines[0]), utils.MaxInt(len(lines[1]), len(lines[2])))))
+	m.print_header()
+	for _, hunk := range res.Hunks(diff_options.ContextLines) {
+		if flag_output_as_text {
+			m.merge_lines_text(res, hunk)
+		} else {
+			m.merge_lines_html(res, hunk)
+		}
+	}
+	m.print_footer()
+}
+
+//
+// Three-way compare of directories, files are paired up by name in the same way as diff_dirs().
+// A file or directory missing from any of the directories is compared as empty.
+//
+func merge_dirs(dirname0, dirname1, dirname2, merge_dir string) {
+
+	dirnames := [3]string{dirname0, dirname1, dirname2}
+	var dirs [3][]os.FileInfo
+
+	for k := range dirnames {
+		dirnames[k] = strings.TrimRight(dirnames[k], PATH_SEPARATOR)
+		if _, err := os.Stat(dirnames[k]); os.IsNotExist(err) {
+			continue
+		}
+		dir, err := read_sorted_dir(dirnames[k])
+		if err != nil {
+			m := &MergeOutput{names: dirnames, is_error: true}
+			m.msgs[k] = err.Error()
+			m.print_header()
+			m.print_footer()
+			return
+		}
+		dirs[k] = dir
+	}
+
+	// Loop through all files, then all directories
+	for _, dir_mode := range []bool{false, true} {
+		var idx [3]int
+		for {
+			// next name in sorted order, from any of the directories
+			name, found := "", false
+			for k := range dirs {
+				for idx[k] < len(dirs[k]) && (dirs[k][idx[k]].IsDir() != dir_mode || strings.HasPrefix(dirs[k][idx[k]].Name(), ".")) {
+					idx[k]++
+				}
+				if idx[k] < len(dirs[k]) && (!found || dirs[k][idx[k]].Name() < name) {
+					name, found = dirs[k][idx[k]].Name(), true
+				}
+			}
+			if !found {
+				break
+			}
+
+			var infos [3]os.FileInfo
+			for k := range dirs {
+				if idx[k] < len(dirs[k]) && dirs[k][idx[k]].Name() == name {
+					infos[k] = dirs[k][idx[k]]
+					idx[k]++
+				}
+			}
+
+			name0 := dirnames[0] + PATH_SEPARATOR + name
+			name1 := dirnames[1] + PATH_SEPARATOR + name
+			name2 := dirnames[2] + PATH_SEPARATOR + name
+
+			if dir_mode {
+				merge_dirs(name0, name1, name2, merge_dir+PATH_SEPARATOR+name)
+			} else if flag_max_goroutines > 1 {
+				queue_merge_file(name0, name1, name2, infos[0], infos[1], infos[2], merge_dir+PATH_SEPARATOR+name)
+			} else {
+				merge_file(name0, name1, name2, infos[0], infos[1], infos[2], merge_dir+PATH_SEPARATOR+name)
+			}
+		}
+	}
+}
+
+//
+// Three-way compare and merge of files or directories, args are: base, left and right
+//
+func merge_main(args []string) {
+
+	if len(args) < 3 {
+		usage("Missing files")
+	}
+
+	if len(args) > 3 {
+		usage("Too many files")
+	}
+
+	// check file type
+	var finfos [3]os.FileInfo
+	failed := false
+	for k, name := range args {
+		finfo, err := os.Stat(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			failed = true
+		}
+		finfos[k] = finfo
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+
+	if finfos[0].IsDir() != finfos[1].IsDir() || finfos[0].IsDir() != finfos[2].IsDir() {
+		usage("Unable to compare file and directory")
+	}
+
+	merge_dir := path.Join(flag_out_folder, flag_merge_dir)
+
+	if !flag_output_as_text {
+		out.WriteString(HTML_HEADER)
+		fmt.Fprintf(out, "<title>Merge %s, %s and %s</title>\n", html.EscapeString(args[0]), html.EscapeString(args[1]), html.EscapeString(args[2]))
+		out.WriteString(HTML_CSS)
+		out.WriteString("</head><body>\n")
+		fmt.Fprintf(out, "<p>Merge <strong>%s</strong> and <strong>%s</strong>, base <strong>%s</strong></p>\n", html.EscapeString(args[1]), html.EscapeString(args[2]), html.EscapeString(args[0]))
+	}
+
+	if finfos[0].IsDir() {
+		job_queue_init()
+		merge_dirs(args[0], args[1], args[2], merge_dir)
+		job_queue_finish()
+	} else {
+		merge_file(args[0], args[1], args[2], finfos[0], finfos[1], finfos[2], path.Join(merge_dir, filepath.Base(args[1])))
+	}
+
+	if !flag_output_as_text {
+		fmt.Fprintf(out, "Generated on %s<br>", time.Now().Format(time.RFC1123))
+		out.WriteString(HTML_LEGEND_MERGE)
+		out.WriteString("</body></html>\n")
+	}
+}
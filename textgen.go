@@ -1,93 +1,249 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
 
-func GenerateText(filename1, filename2 string, msg1, msg2 string) {
+	"github.com/rsrini7/godiff/diff"
+	"github.com/rsrini7/godiff/utils"
+)
+
+func GenerateText(filename1, filename2 string, msg1, msg2 string, notes ...string) {
 	out_acquire_lock()
 	if flag_unified_context {
 		fmt.Fprintf(out, "<<< %s: %s\n", filename1, msg1)
-		fmt.Fprintf(out, ">>> %s: %s\n\n", filename2, msg2)
+		fmt.Fprintf(out, ">>> %s: %s\n", filename2, msg2)
 	} else {
 		fmt.Fprintf(out, "--- %s: %s\n", filename1, msg1)
-		fmt.Fprintf(out, "+++ %s: %s\n\n", filename2, msg2)
+		fmt.Fprintf(out, "+++ %s: %s\n", filename2, msg2)
+	}
+	for _, note := range notes {
+		write_text_message(note)
 	}
+	out.WriteByte('\n')
 	out_release_lock()
 }
 
-func (chg *DiffChangerUnifiedText) diff_lines(ops []DiffOp) {
-
+func (chg *DiffChangerUnifiedText) print_header() {
 	if !chg.header_printed {
 		out_acquire_lock()
 		chg.header_printed = true
-		fmt.Fprintf(out, "--- %s\n", chg.name1)
-		fmt.Fprintf(out, "+++ %s\n", chg.name2)
+		fmt.Fprintf(out, "--- %s%s\n", chg.name1, text_file_label(chg.label1))
+		fmt.Fprintf(out, "+++ %s%s\n", chg.name2, text_file_label(chg.label2))
 	}
+}
+
+func (chg *DiffChangerUnifiedText) diff_message(msg string) {
+	chg.print_header()
+	write_text_message(msg)
+}
+
+func (chg *DiffChangerUnifiedText) diff_lines(ops []diff.DiffOp) {
+
+	chg.print_header()
 
-	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", ops[0].start1+1, ops[len(ops)-1].end1-ops[0].start1, ops[0].start2+1, ops[len(ops)-1].end2-ops[0].start2)
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@", ops[0].Start1+1, ops[len(ops)-1].End1-ops[0].Start1, ops[0].Start2+1, ops[len(ops)-1].End2-ops[0].Start2)
 
+	// annotate the moved lines after the hunk header
 	for _, v := range ops {
-		switch v.op {
-		case DIFF_OP_INSERT, DIFF_OP_REMOVE, DIFF_OP_MODIFY:
-			for _, line := range chg.file1[v.start1:v.end1] {
-				out.WriteString("- ")
-				out.Write(line)
-				out.WriteByte('\n')
+		if v.Op == diff.DIFF_OP_MOVE {
+			m := chg.moves[v.Move-1]
+			if v.End1 > v.Start1 {
+				fmt.Fprintf(out, " moved %d,%d to %d,%d", m.Start1+1, m.End1, m.Start2+1, m.End2)
+			} else {
+				fmt.Fprintf(out, " moved %d,%d from %d,%d", m.Start2+1, m.End2, m.Start1+1, m.End1)
 			}
+		}
+	}
+	out.WriteByte('\n')
 
-			for _, line := range chg.file2[v.start2:v.end2] {
-				out.WriteString("+ ")
-				out.Write(line)
-				out.WriteByte('\n')
-			}
+	for _, v := range ops {
+		switch v.Op {
+		case diff.DIFF_OP_INSERT, diff.DIFF_OP_REMOVE, diff.DIFF_OP_MODIFY, diff.DIFF_OP_MOVE:
+			chg.write_text_lines("- ", 1, v.Start1, v.End1)
+			chg.write_text_lines("+ ", 2, v.Start2, v.End2)
 
 		default:
-			for _, line := range chg.file1[v.start1:v.end1] {
-				out.WriteString("  ")
-				out.Write(line)
-				out.WriteByte('\n')
-			}
+			chg.write_text_lines("  ", 1, v.Start1, v.End1)
 		}
 	}
 }
 
-func (chg *DiffChangerText) diff_lines(ops []DiffOp) {
+// Write the rows of csv files that changed, with a note for each changed cell
+func (chg *DiffChangerUnifiedText) diff_rows(res *utils.CsvDiff) {
+
+	chg.print_header()
+	write_text_csv_columns(res, chg.comma)
+
+	// line of the last row of each file, in key order. The header is line 0
+	prev1, prev2 := 0, 0
+
+	for _, r := range res.Rows {
+		if r.Change != utils.CSV_ROW_SAME {
+			start1, n1, start2, n2 := prev1+1, 0, prev2+1, 0
+			if r.Row1 != nil {
+				start1, n1 = r.Record1+1, 1
+			}
+			if r.Row2 != nil {
+				start2, n2 = r.Record2+1, 1
+			}
+			fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", start1+1, n1, start2+1, n2)
+			write_text_csv_row("- ", r.Row1, chg.comma)
+			write_text_csv_row("+ ", r.Row2, chg.comma)
+			for _, note := range csv_cell_notes(res, r) {
+				write_text_message(note)
+			}
+		}
+		if r.Row1 != nil {
+			prev1 = r.Record1 + 1
+		}
+		if r.Row2 != nil {
+			prev2 = r.Record2 + 1
+		}
+	}
+}
 
+func (chg *DiffChangerText) print_header() {
 	if !chg.header_printed {
 		out_acquire_lock()
 		chg.header_printed = true
-		fmt.Fprintf(out, "<<< %s\n", chg.name1)
-		fmt.Fprintf(out, ">>> %s\n", chg.name2)
+		fmt.Fprintf(out, "<<< %s%s\n", chg.name1, text_file_label(chg.label1))
+		fmt.Fprintf(out, ">>> %s%s\n", chg.name2, text_file_label(chg.label2))
 	}
+}
+
+func (chg *DiffChangerText) diff_message(msg string) {
+	chg.print_header()
+	write_text_message(msg)
+}
+
+func (chg *DiffChangerText) diff_lines(ops []diff.DiffOp) {
+
+	chg.print_header()
 
 	for _, v := range ops {
-		switch v.op {
-		case DIFF_OP_SAME:
+		switch v.Op {
+		case diff.DIFF_OP_SAME:
 			continue
 
-		case DIFF_OP_INSERT:
-			print_line_numbers("a", v.start1-1, -1, v.start2, v.end2)
+		case diff.DIFF_OP_INSERT:
+			print_line_numbers("a", v.Start1-1, -1, v.Start2, v.End2)
 
-		case DIFF_OP_REMOVE:
-			print_line_numbers("d", v.start1, v.end1, v.start2-1, -1)
+		case diff.DIFF_OP_REMOVE:
+			print_line_numbers("d", v.Start1, v.End1, v.Start2-1, -1)
 
-		case DIFF_OP_MODIFY:
-			print_line_numbers("c", v.start1, v.end1, v.start2, v.end2)
-		}
+		case diff.DIFF_OP_MODIFY:
+			print_line_numbers("c", v.Start1, v.End1, v.Start2, v.End2)
 
-		for _, line := range chg.file1[v.start1:v.end1] {
-			out.WriteString("< ")
-			out.Write(line)
-			out.WriteByte('\n')
+		case diff.DIFF_OP_MOVE:
+			if v.End1 > v.Start1 {
+				print_line_numbers("d", v.Start1, v.End1, v.Start2-1, -1)
+			} else {
+				print_line_numbers("a", v.Start1-1, -1, v.Start2, v.End2)
+			}
 		}
 
-		if v.end1 > v.start1 && v.end2 > v.start2 {
+		chg.write_text_lines("< ", 1, v.Start1, v.End1)
+
+		if v.End1 > v.Start1 && v.End2 > v.Start2 {
 			out.WriteString("---\n")
 		}
 
-		for _, line := range chg.file2[v.start2:v.end2] {
-			out.WriteString("> ")
-			out.Write(line)
-			out.WriteByte('\n')
+		chg.write_text_lines("> ", 2, v.Start2, v.End2)
+
+		// annotate the moved lines
+		if v.Op == diff.DIFF_OP_MOVE {
+			m := chg.moves[v.Move-1]
+			if v.End1 > v.Start1 {
+				fmt.Fprintf(out, "\\ Moved to %d,%d\n", m.Start2+1, m.End2)
+			} else {
+				fmt.Fprintf(out, "\\ Moved from %d,%d\n", m.Start1+1, m.End1)
+			}
 		}
 	}
 }
+
+// Write the rows of csv files that changed, with a note for each changed cell
+func (chg *DiffChangerText) diff_rows(res *utils.CsvDiff) {
+
+	chg.print_header()
+	write_text_csv_columns(res, chg.comma)
+
+	// line of the last row of each file, in key order. The header is line 0
+	prev1, prev2 := 0, 0
+
+	for _, r := range res.Rows {
+		line1, line2 := r.Record1+1, r.Record2+1
+
+		switch r.Change {
+		case utils.CSV_ROW_ADDED:
+			print_line_numbers("a", prev1, -1, line2, line2+1)
+
+		case utils.CSV_ROW_REMOVED:
+			print_line_numbers("d", line1, line1+1, prev2, -1)
+
+		case utils.CSV_ROW_MODIFIED:
+			print_line_numbers("c", line1, line1+1, line2, line2+1)
+		}
+
+		if r.Change != utils.CSV_ROW_SAME {
+			write_text_csv_row("< ", r.Row1, chg.comma)
+			if r.Row1 != nil && r.Row2 != nil {
+				out.WriteString("---\n")
+			}
+			write_text_csv_row("> ", r.Row2, chg.comma)
+			for _, note := range csv_cell_notes(res, r) {
+				write_text_message(note)
+			}
+		}
+
+		if r.Row1 != nil {
+			prev1 = line1
+		}
+		if r.Row2 != nil {
+			prev2 = line2
+		}
+	}
+}
+
+//
+// Name the columns of the rows: the columns of file1, then the columns only in file2.
+// The rows of file2 are written in this order too, like in the html output.
+//
+func write_text_csv_columns(res *utils.CsvDiff, comma rune) {
+	line, _ := format_csv_record(res.Header, comma)
+	write_text_message(fmt.Sprintf(MSG_CSV_COLUMNS, line))
+}
+
+// Write a csv row, nothing if nil
+func write_text_csv_row(prefix string, row []string, comma rune) {
+	if row != nil {
+		line, _ := format_csv_record(row, comma)
+		out.WriteString(prefix)
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+}
+
+//
+// Write lines start to end of file1 (side 1) or file2 (side 2), a note about the line ending
+// is written after a line if needed, ie. "\ No newline at end of file".
+//
+func (chg *DiffChangerData) write_text_lines(prefix string, side, start, end int) {
+	lines := chg.file1
+	if side == 2 {
+		lines = chg.file2
+	}
+	notes := chg.notes(side, start, end)
+	for i, line := range lines[start:end] {
+		out.WriteString(prefix)
+		out.Write(line)
+		out.WriteByte('\n')
+		if notes != nil && notes[i] != "" {
+			fmt.Fprintf(out, "\\ %s\n", notes[i])
+		}
+	}
+}
+
+func write_text_message(msg string) {
+	fmt.Fprintf(out, "\\ %s\n", msg)
+}
@@ -0,0 +1,373 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Columns added to the delta csv file
+const (
+	CSV_DELTA_CHANGE     = "change_type"     // first column: ADDED, REMOVED or MODIFIED
+	CSV_DELTA_CHANGED    = "changed_columns" // names of the changed columns of the modified rows
+	CSV_DELTA_OLD        = "old_"            // prefix of the columns with the old values of the modified rows
+	CSV_DELTA_OLD_VALUES = "old_values"      // in the checksum record, when the old_ columns are written
+	CSV_DELTA_CHECKSUM   = "CHECKSUM"        // change type of the last record: number of rows, hash of the new file, ignored and optional columns
+)
+
+// Value of the change type column, by CSV_ROW_xxx
+var CsvChangeNames = []string{"", "ADDED", "REMOVED", "MODIFIED"}
+
+// Optional columns of the delta csv file
+type CsvDeltaOptions struct {
+	OldValues      bool // add the old values of the modified rows, in a column named "old_NAME" for each column
+	ChangedColumns bool // add the names of the changed columns of the modified rows, separated by ','
+}
+
+// A record of a delta csv file
+type CsvDeltaRecord struct {
+	Change int      // CSV_ROW_xxx
+	Row    []string // with the columns of CsvDelta.Header
+}
+
+// A delta csv file, as read by ParseCsvDelta()
+type CsvDelta struct {
+	Header  []string // columns of the new file
+	Records []CsvDeltaRecord
+	Rows    int      // number of rows of the new file, -1 if there is no checksum record
+	Hash    string   // hash of the rows of the new file, see CsvRowsHash()
+	Ignored []string // columns not in the hash, they were not compared
+	Options CsvDeltaOptions
+}
+
+// Index in Header of the columns of file2, in the order of file2: the columns of the delta file
+func (res *CsvDiff) delta_columns() []int {
+	var columns []int
+	for c := range res.Header {
+		if res.Columns2[c] >= 0 {
+			columns = append(columns, c)
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool { return res.Columns2[columns[i]] < res.Columns2[columns[j]] })
+	return columns
+}
+
+// Values of a row in the given columns
+func select_columns(row []string, columns []int) []string {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		values[i] = row[c]
+	}
+	return values
+}
+
+//
+// Header of the delta csv file: the change type, the columns of file2 in the same order, then the optional columns
+//
+func (res *CsvDiff) DeltaHeader(opts *CsvDeltaOptions) []string {
+
+	columns := select_columns(res.Header, res.delta_columns())
+	header := append([]string{CSV_DELTA_CHANGE}, columns...)
+	if opts.OldValues {
+		for _, name := range columns {
+			header = append(header, CSV_DELTA_OLD+name)
+		}
+	}
+	if opts.ChangedColumns {
+		header = append(header, CSV_DELTA_CHANGED)
+	}
+	return header
+}
+
+//
+// A record of the delta csv file, for a row that changed. The values are the new values of the added
+// and modified rows, and the old values of the removed rows.
+//
+func (res *CsvDiff) DeltaRecord(r *CsvRowDiff, opts *CsvDeltaOptions) []string {
+
+	columns := res.delta_columns()
+	rec := []string{CsvChangeNames[r.Change]}
+	if r.Row2 != nil {
+		rec = append(rec, select_columns(r.Row2, columns)...)
+	} else {
+		rec = append(rec, select_columns(r.Row1, columns)...)
+	}
+
+	if opts.OldValues {
+		if r.Change == CSV_ROW_MODIFIED {
+			rec = append(rec, select_columns(r.Row1, columns)...)
+		} else {
+			rec = append(rec, make([]string, len(columns))...)
+		}
+	}
+	if opts.ChangedColumns {
+		var names []string
+		for _, cell := range r.Cells {
+			names = append(names, res.Header[cell.Column])
+		}
+		rec = append(rec, strings.Join(names, ","))
+	}
+	return rec
+}
+
+//
+// The checksum record, last of the delta csv file: the number of rows of file2, their hash, the names
+// of the ignored columns and the optional columns written (old_values, changed_columns), separated by ','.
+// Used to check the result of applying the delta: the ignored columns are not in the hash, the rows where
+// only they changed are not in the delta. The optional columns are not told from the header, a column of
+// the files can have the same name.
+//
+func (res *CsvDiff) DeltaChecksum(opts *CsvDeltaOptions) []string {
+
+	var columns []int
+	var ignored []string
+	for _, c := range res.delta_columns() {
+		if res.Ignored[c] {
+			ignored = append(ignored, res.Header[c])
+		} else {
+			columns = append(columns, c)
+		}
+	}
+
+	var rows [][]string
+	for _, r := range res.Rows {
+		if r.Row2 != nil {
+			rows = append(rows, select_columns(r.Row2, columns))
+		}
+	}
+
+	var options []string
+	if opts.OldValues {
+		options = append(options, CSV_DELTA_OLD_VALUES)
+	}
+	if opts.ChangedColumns {
+		options = append(options, CSV_DELTA_CHANGED)
+	}
+
+	rec := make([]string, MaxInt(len(res.DeltaHeader(opts)), 4))
+	rec[0], rec[1], rec[2], rec[3] = CSV_DELTA_CHECKSUM, strconv.Itoa(len(rows)), CsvRowsHash(rows), strings.Join(ignored, ",")
+	if len(options) > 0 {
+		if len(rec) < 5 {
+			rec = append(rec, "")
+		}
+		rec[4] = strings.Join(options, ",")
+	}
+	return rec
+}
+
+//
+// Write the delta csv file: the header, a record for each row added, removed or modified in key order,
+// and the checksum record.
+//
+func WriteCsvDelta(w *csv.Writer, res *CsvDiff, opts *CsvDeltaOptions) error {
+
+	w.Write(res.DeltaHeader(opts))
+	for _, r := range res.Rows {
+		if r.Change != CSV_ROW_SAME {
+			w.Write(res.DeltaRecord(r, opts))
+		}
+	}
+	w.Write(res.DeltaChecksum(opts))
+	w.Flush()
+	return w.Error()
+}
+
+//
+// Hash of the rows of a csv file, in any order: sha256 of the rows written as csv, sorted.
+//
+func CsvRowsHash(rows [][]string) string {
+
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(row)
+		w.Flush()
+		lines[i] = buf.String()
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//
+// Read the records of a delta csv file, written by WriteCsvDelta(). The optional columns, as listed
+// in the checksum record, are ignored. Without a checksum record, there are no optional columns.
+//
+func ParseCsvDelta(records [][]string) (*CsvDelta, error) {
+
+	if len(records) == 0 || len(records[0]) == 0 || records[0][0] != CSV_DELTA_CHANGE {
+		return nil, fmt.Errorf("not a delta csv file, expecting a %s column", CSV_DELTA_CHANGE)
+	}
+
+	delta := &CsvDelta{Rows: -1}
+	for i, rec := range records[1:] {
+		if rec[0] != CSV_DELTA_CHECKSUM || len(rec) < 3 {
+			continue
+		}
+		rows, err := strconv.Atoi(rec[1])
+		if err != nil {
+			return nil, fmt.Errorf("record %d: invalid number of rows: %s", i+2, rec[1])
+		}
+		delta.Rows, delta.Hash = rows, rec[2]
+		if len(rec) >= 4 && rec[3] != "" {
+			delta.Ignored = strings.Split(rec[3], ",")
+		}
+		if len(rec) >= 5 && rec[4] != "" {
+			for _, option := range strings.Split(rec[4], ",") {
+				switch option {
+				case CSV_DELTA_OLD_VALUES:
+					delta.Options.OldValues = true
+				case CSV_DELTA_CHANGED:
+					delta.Options.ChangedColumns = true
+				default:
+					return nil, fmt.Errorf("record %d: invalid optional column: %s", i+2, option)
+				}
+			}
+		}
+	}
+
+	// remove the optional columns
+	header := records[0][1:]
+	if delta.Options.ChangedColumns {
+		if n := len(header); n == 0 || header[n-1] != CSV_DELTA_CHANGED {
+			return nil, fmt.Errorf("no %s column, as listed in the %s record", CSV_DELTA_CHANGED, CSV_DELTA_CHECKSUM)
+		}
+		header = header[:len(header)-1]
+	}
+	if delta.Options.OldValues {
+		n := len(header) / 2
+		for i := 0; i < n; i++ {
+			if header[n+i] != CSV_DELTA_OLD+header[i] {
+				n = -1
+				break
+			}
+		}
+		if n <= 0 || len(header) != 2*n {
+			return nil, fmt.Errorf("no %s columns, as listed in the %s record", CSV_DELTA_OLD, CSV_DELTA_CHECKSUM)
+		}
+		header = header[:n]
+	}
+	delta.Header = header
+
+	for i, rec := range records[1:] {
+		if rec[0] == CSV_DELTA_CHECKSUM && len(rec) >= 3 {
+			continue
+		}
+		change := Find(CsvChangeNames, rec[0])
+		if change <= CSV_ROW_SAME {
+			return nil, fmt.Errorf("record %d: invalid change type: %s", i+2, rec[0])
+		}
+		delta.Records = append(delta.Records, CsvDeltaRecord{Change: change, Row: align_record(rec[1:], columns_range(len(header)))})
+	}
+	return delta, nil
+}
+
+// The column indexes 0 to n-1
+func columns_range(n int) []int {
+	columns := make([]int, n)
+	for i := range columns {
+		columns[i] = i
+	}
+	return columns
+}
+
+//
+// Apply the delta to the rows of the old file, joined on the key columns. Return the rows of the new file,
+// with the columns of the delta: the rows of the old file in the same order, the modified rows in place,
+// then the added rows. The result is checked with the checksum record if there is one.
+//
+func (delta *CsvDelta) Apply(header []string, rows [][]string, keys []string) ([][]string, error) {
+
+	columns := make([]int, len(delta.Header))
+	for c, name := range delta.Header {
+		columns[c] = Find(header, name)
+	}
+
+	var key_columns []int
+	for _, key := range keys {
+		if Find(header, key) < 0 || Find(delta.Header, key) < 0 {
+			return nil, fmt.Errorf("key column not found: %s", key)
+		}
+		key_columns = append(key_columns, Find(delta.Header, key))
+	}
+	if len(keys) == 0 {
+		key_columns = columns_range(len(delta.Header))
+	}
+
+	// rows of the old file by key, removed rows are set to nil
+	result := make([][]string, len(rows))
+	index := make(map[string][]int)
+	for i, rec := range rows {
+		result[i] = align_record(rec, columns)
+		k := strings.Join(select_columns(result[i], key_columns), "\x00")
+		index[k] = append(index[k], i)
+	}
+
+	for _, rec := range delta.Records {
+		k := strings.Join(select_columns(rec.Row, key_columns), "\x00")
+		if rec.Change == CSV_ROW_ADDED {
+			result = append(result, rec.Row)
+			continue
+		}
+		if len(index[k]) == 0 {
+			return nil, fmt.Errorf("%s row not found: %s", CsvChangeNames[rec.Change], strings.Join(select_columns(rec.Row, key_columns), ","))
+		}
+		i := index[k][0]
+		index[k] = index[k][1:]
+		if rec.Change == CSV_ROW_MODIFIED {
+			result[i] = rec.Row
+		} else {
+			result[i] = nil
+		}
+	}
+
+	n := 0
+	for _, row := range result {
+		if row != nil {
+			result[n] = row
+			n++
+		}
+	}
+	result = result[:n]
+
+	if err := delta.check(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Check the rows of the new file with the checksum record, without the ignored columns
+func (delta *CsvDelta) check(rows [][]string) error {
+
+	if delta.Rows < 0 {
+		return nil
+	}
+	if len(rows) != delta.Rows {
+		return fmt.Errorf("the result does not match the checksum of the delta: %d rows, expecting %d rows", len(rows), delta.Rows)
+	}
+
+	var columns []int
+	for c, name := range delta.Header {
+		if Find(delta.Ignored, name) < 0 {
+			columns = append(columns, c)
+		}
+	}
+	hashed := make([][]string, len(rows))
+	for i, row := range rows {
+		hashed[i] = select_columns(row, columns)
+	}
+	if CsvRowsHash(hashed) != delta.Hash {
+		return fmt.Errorf("the result does not match the checksum of the delta: the rows differ")
+	}
+	return nil
+}
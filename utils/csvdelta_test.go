@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	delta_header1 = []string{"id", "name", "qty"}
+	delta_rows1   = [][]string{{"1", "bolt", "5"}, {"2", "nut", "7"}, {"3", "gear", "1"}}
+	delta_header2 = []string{"id", "qty", "name"}
+	delta_rows2   = [][]string{{"1", "5", "bolt"}, {"2", "8", "nut"}, {"4", "2", "cog, big"}}
+)
+
+func TestWriteCsvDelta(t *testing.T) {
+
+	res, err := CompareCsv(delta_header1, delta_rows1, delta_header2, delta_rows2, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum := "CHECKSUM,3," + CsvRowsHash(delta_rows2)
+
+	tests := []struct {
+		opts CsvDeltaOptions
+		want string
+	}{
+		{CsvDeltaOptions{},
+			"change_type,id,qty,name\nMODIFIED,2,8,nut\nREMOVED,3,1,gear\nADDED,4,2,\"cog, big\"\n" + checksum + ",\n"},
+		{CsvDeltaOptions{OldValues: true},
+			"change_type,id,qty,name,old_id,old_qty,old_name\nMODIFIED,2,8,nut,2,7,nut\nREMOVED,3,1,gear,,,\nADDED,4,2,\"cog, big\",,,\n" + checksum + ",,old_values,,\n"},
+		{CsvDeltaOptions{ChangedColumns: true},
+			"change_type,id,qty,name,changed_columns\nMODIFIED,2,8,nut,qty\nREMOVED,3,1,gear,\nADDED,4,2,\"cog, big\",\n" + checksum + ",,changed_columns\n"},
+		{CsvDeltaOptions{OldValues: true, ChangedColumns: true},
+			"change_type,id,qty,name,old_id,old_qty,old_name,changed_columns\nMODIFIED,2,8,nut,2,7,nut,qty\nREMOVED,3,1,gear,,,,\nADDED,4,2,\"cog, big\",,,,\n" + checksum + ",,\"old_values,changed_columns\",,,\n"},
+	}
+
+	for i, test := range tests {
+		var buf bytes.Buffer
+		if err := WriteCsvDelta(csv.NewWriter(&buf), res, &test.opts); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%d: WriteCsvDelta() =\n%s\nwant\n%s", i, buf.String(), test.want)
+		}
+	}
+}
+
+// Read a delta csv file, the checksum record can have more fields than the header
+func readDeltaRecords(t *testing.T, buf *bytes.Buffer) [][]string {
+	r := csv.NewReader(buf)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestApplyCsvDelta(t *testing.T) {
+
+	res, err := CompareCsv(delta_header1, delta_rows1, delta_header2, delta_rows2, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []CsvDeltaOptions{{}, {OldValues: true, ChangedColumns: true}} {
+		var buf bytes.Buffer
+		WriteCsvDelta(csv.NewWriter(&buf), res, &opts)
+		delta, err := ParseCsvDelta(readDeltaRecords(t, &buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(delta.Header, delta_header2) {
+			t.Errorf("%v: Header = %v, want %v", opts, delta.Header, delta_header2)
+		}
+
+		got, err := delta.Apply(delta_header1, delta_rows1, []string{"id"})
+		if err != nil || !reflect.DeepEqual(got, delta_rows2) {
+			t.Errorf("%v: Apply() = %v, %v, want %v", opts, got, err, delta_rows2)
+		}
+
+		// not the file the delta was computed from
+		if _, err := delta.Apply(delta_header1, delta_rows1[:2], []string{"id"}); err == nil {
+			t.Errorf("%v: Apply() to another file, want an error", opts)
+		}
+	}
+
+	// a changed row, the checksum does not match
+	delta, _ := ParseCsvDelta([][]string{{"change_type", "id", "qty", "name"}, {"MODIFIED", "2", "9", "nut"}, {"CHECKSUM", "3", CsvRowsHash(delta_rows2), ""}})
+	if _, err := delta.Apply(delta_header1, delta_rows1, []string{"id"}); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Apply() with a wrong checksum = %v, want an error", err)
+	}
+
+	// a column ignored: the rows where only it changed are not in the delta, and not checked
+	res, err = CompareCsv(delta_header1, delta_rows1, delta_header2, delta_rows2, []string{"id"}, &CsvCompareOptions{Ignore: []string{"qty"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	WriteCsvDelta(csv.NewWriter(&buf), res, &CsvDeltaOptions{})
+	delta, err = ParseCsvDelta(readDeltaRecords(t, &buf))
+	if err != nil || len(delta.Records) != 2 || !reflect.DeepEqual(delta.Ignored, []string{"qty"}) {
+		t.Fatalf("ParseCsvDelta() = %v, %v, want 2 records and qty ignored", delta, err)
+	}
+	want := [][]string{{"1", "5", "bolt"}, {"2", "7", "nut"}, {"4", "2", "cog, big"}}
+	if got, err := delta.Apply(delta_header1, delta_rows1, []string{"id"}); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply() with an ignored column = %v, %v, want %v", got, err, want)
+	}
+
+	if _, err := ParseCsvDelta([][]string{{"id", "name"}}); err == nil {
+		t.Errorf("ParseCsvDelta() without a change type column, want an error")
+	}
+}
+
+// Columns named like the optional columns of the delta file
+func TestApplyCsvDeltaColumnNames(t *testing.T) {
+
+	tests := []struct {
+		header []string
+		rows1  [][]string
+		rows2  [][]string
+	}{
+		{[]string{"id", "changed_columns"}, [][]string{{"1", "a"}, {"2", "b"}}, [][]string{{"1", "a"}, {"2", "c"}}},
+		{[]string{"x", "old_x"}, [][]string{{"1", "a"}, {"2", "b"}}, [][]string{{"1", "a"}, {"2", "c"}}},
+	}
+
+	for _, test := range tests {
+		res, err := CompareCsv(test.header, test.rows1, test.header, test.rows2, test.header[:1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opts := range []CsvDeltaOptions{{}, {OldValues: true}, {ChangedColumns: true}, {OldValues: true, ChangedColumns: true}} {
+			var buf bytes.Buffer
+			WriteCsvDelta(csv.NewWriter(&buf), res, &opts)
+			delta, err := ParseCsvDelta(readDeltaRecords(t, &buf))
+			if err != nil || !reflect.DeepEqual(delta.Header, test.header) || delta.Options != opts {
+				t.Errorf("%v %v: ParseCsvDelta() = %v, %v", test.header, opts, delta, err)
+				continue
+			}
+			if got, err := delta.Apply(test.header, test.rows1, test.header[:1]); err != nil || !reflect.DeepEqual(got, test.rows2) {
+				t.Errorf("%v %v: Apply() = %v, %v, want %v", test.header, opts, got, err, test.rows2)
+			}
+		}
+	}
+
+	// the optional columns listed, not in the header
+	if _, err := ParseCsvDelta([][]string{{"change_type", "id", "name"}, {"CHECKSUM", "0", CsvRowsHash(nil), "", "old_values"}}); err == nil {
+		t.Errorf("ParseCsvDelta() without the old_ columns, want an error")
+	}
+}
+
+func TestCsvRowsHash(t *testing.T) {
+	rows := [][]string{{"a", "b"}, {"c", "d"}}
+	if CsvRowsHash(rows) != CsvRowsHash([][]string{{"c", "d"}, {"a", "b"}}) {
+		t.Errorf("CsvRowsHash() depends on the order of the rows")
+	}
+	if CsvRowsHash(rows) == CsvRowsHash([][]string{{"a", "b,c"}, {"d"}}) {
+		t.Errorf("CsvRowsHash() is the same for different rows")
+	}
+}
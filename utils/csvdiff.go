@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change to a row, comparing two csv files by key
+const (
+	CSV_ROW_SAME = iota
+	CSV_ROW_ADDED
+	CSV_ROW_REMOVED
+	CSV_ROW_MODIFIED
+)
+
+// A cell that changed in a modified row
+type CsvCellChange struct {
+	Column   int // index in CsvDiff.Header
+	Old, New string
+}
+
+// A row of either file, joined on the key columns
+type CsvRowDiff struct {
+	Key              []string // values of the key columns
+	Change           int      // CSV_ROW_xxx
+	Record1, Record2 int      // index of the record in the rows of file1 and file2, -1 if not in the file
+	Row1, Row2       []string // the records, with the columns of CsvDiff.Header. nil if not in the file
+	Cells            []CsvCellChange
+}
+
+// Columns compared, by name. The key columns are always compared.
+type CsvCompareOptions struct {
+	Ignore []string // columns not compared
+	Only   []string // compare only these columns, all if empty
+}
+
+// Result of comparing two csv files by key
+type CsvDiff struct {
+	Header             []string // the columns of file1, then the columns only in file2
+	Columns1, Columns2 []int    // index of each column of Header in the records of file1 and file2, -1 if not in the file
+	Keys               []int    // index of the key columns in Header
+	Ignored            []bool   // columns of Header not compared, see CsvCompareOptions
+	Rows               []*CsvRowDiff
+	Added              int
+	Removed            int
+	Modified           int
+	Same               int
+}
+
+//
+// Compare the rows of two csv files, joined on the key columns. The columns are matched by name, in any order.
+// Without key columns, the whole row is the key: rows are only added or removed.
+// Rows with the same key are paired in the order of the files. The result is ordered by key,
+// key values are compared as numbers if both are numbers. The ignored columns, see opts, are not compared:
+// they differ in rows that are the same. opts may be nil to compare all the columns.
+//
+func CompareCsv(header1 []string, rows1 [][]string, header2 []string, rows2 [][]string, keys []string, opts *CsvCompareOptions) (*CsvDiff, error) {
+
+	res := &CsvDiff{}
+
+	// columns of both files, by name
+	for i, name := range header1 {
+		res.Header = append(res.Header, name)
+		res.Columns1 = append(res.Columns1, i)
+		res.Columns2 = append(res.Columns2, Find(header2, name))
+	}
+	for i, name := range header2 {
+		if Find(header1, name) < 0 {
+			res.Header = append(res.Header, name)
+			res.Columns1 = append(res.Columns1, -1)
+			res.Columns2 = append(res.Columns2, i)
+		}
+	}
+
+	for _, key := range keys {
+		if Find(header1, key) < 0 || Find(header2, key) < 0 {
+			return nil, fmt.Errorf("key column not found: %s", key)
+		}
+		res.Keys = append(res.Keys, Find(res.Header, key))
+	}
+	if len(keys) == 0 {
+		for i := range res.Header {
+			res.Keys = append(res.Keys, i)
+		}
+	}
+
+	res.Ignored = make([]bool, len(res.Header))
+	if opts != nil {
+		for c, name := range res.Header {
+			if Find(keys, name) < 0 {
+				res.Ignored[c] = Find(opts.Ignore, name) >= 0 || len(opts.Only) > 0 && Find(opts.Only, name) < 0
+			}
+		}
+	}
+
+	// records of file2 by key, in file order
+	index2 := make(map[string][]int)
+	for i, rec := range rows2 {
+		row := align_record(rec, res.Columns2)
+		k := strings.Join(res.key(row), "\x00")
+		index2[k] = append(index2[k], i)
+	}
+
+	for i, rec := range rows1 {
+		r := &CsvRowDiff{Record1: i, Record2: -1, Row1: align_record(rec, res.Columns1)}
+		r.Key = res.key(r.Row1)
+		k := strings.Join(r.Key, "\x00")
+		if recs := index2[k]; len(recs) > 0 {
+			r.Record2, r.Row2 = recs[0], align_record(rows2[recs[0]], res.Columns2)
+			index2[k] = recs[1:]
+		}
+		res.Rows = append(res.Rows, r)
+	}
+	for i, rec := range rows2 {
+		row := align_record(rec, res.Columns2)
+		k := strings.Join(res.key(row), "\x00")
+		if recs := index2[k]; len(recs) > 0 && recs[0] == i {
+			res.Rows = append(res.Rows, &CsvRowDiff{Key: res.key(row), Record1: -1, Record2: i, Row2: row})
+			index2[k] = recs[1:]
+		}
+	}
+
+	for _, r := range res.Rows {
+		switch {
+		case r.Row2 == nil:
+			r.Change = CSV_ROW_REMOVED
+			res.Removed++
+		case r.Row1 == nil:
+			r.Change = CSV_ROW_ADDED
+			res.Added++
+		default:
+			for c := range res.Header {
+				if !res.Ignored[c] && r.Row1[c] != r.Row2[c] {
+					r.Cells = append(r.Cells, CsvCellChange{Column: c, Old: r.Row1[c], New: r.Row2[c]})
+				}
+			}
+			if len(r.Cells) > 0 {
+				r.Change = CSV_ROW_MODIFIED
+				res.Modified++
+			} else {
+				r.Change = CSV_ROW_SAME
+				res.Same++
+			}
+		}
+	}
+
+	sort.SliceStable(res.Rows, func(i, j int) bool {
+		return CompareCsvKeys(res.Rows[i].Key, res.Rows[j].Key) < 0
+	})
+
+	return res, nil
+}
+
+// Report if the files have the same rows and columns, in any order, except the ignored columns
+func (res *CsvDiff) Equal() bool {
+	if res.Added+res.Removed+res.Modified > 0 {
+		return false
+	}
+	for c := range res.Header {
+		if !res.Ignored[c] && (res.Columns1[c] < 0 || res.Columns2[c] < 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Values of the key columns of a row
+func (res *CsvDiff) key(row []string) []string {
+	key := make([]string, len(res.Keys))
+	for i, c := range res.Keys {
+		key[i] = row[c]
+	}
+	return key
+}
+
+// A record with the columns in the order of the header, "" for the missing columns
+func align_record(rec []string, columns []int) []string {
+	row := make([]string, len(columns))
+	for c, i := range columns {
+		if i >= 0 && i < len(rec) {
+			row[c] = rec[i]
+		}
+	}
+	return row
+}
+
+//
+// Compare the values of two keys, column by column. Values are compared as numbers if both are numbers.
+// Return a negative number, 0 or a positive number, like strings.Compare.
+//
+func CompareCsvKeys(key1, key2 []string) int {
+	for i := 0; i < len(key1) && i < len(key2); i++ {
+		v1, v2 := key1[i], key2[i]
+		if v1 == v2 {
+			continue
+		}
+		f1, err1 := strconv.ParseFloat(v1, 64)
+		f2, err2 := strconv.ParseFloat(v2, 64)
+		switch {
+		case err1 == nil && err2 == nil && f1 < f2:
+			return -1
+		case err1 == nil && err2 == nil && f1 > f2:
+			return 1
+		case v1 < v2:
+			return -1
+		case v1 > v2:
+			return 1
+		}
+	}
+	return len(key1) - len(key2)
+}
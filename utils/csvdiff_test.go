@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareCsv(t *testing.T) {
+
+	header1 := []string{"id", "name", "qty"}
+	rows1 := [][]string{
+		{"10", "bolt", "5"},
+		{"2", "nut", "7"},
+		{"3", "gear", "1"},
+		{"4", "cog", "9"},
+	}
+	// columns in another order, one row removed, one added, one modified
+	header2 := []string{"qty", "id", "name"}
+	rows2 := [][]string{
+		{"5", "10", "bolt"},
+		{"8", "2", "nut"},
+		{"9", "4", "cog"},
+		{"2", "11", "axle"},
+	}
+
+	res, err := CompareCsv(header1, rows1, header2, rows2, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Header, header1) {
+		t.Errorf("Header = %v, want %v", res.Header, header1)
+	}
+
+	want := []struct {
+		key    string
+		change int
+		cells  []CsvCellChange
+	}{
+		{"2", CSV_ROW_MODIFIED, []CsvCellChange{{2, "7", "8"}}},
+		{"3", CSV_ROW_REMOVED, nil},
+		{"4", CSV_ROW_SAME, nil},
+		{"10", CSV_ROW_SAME, nil},
+		{"11", CSV_ROW_ADDED, nil},
+	}
+	if len(res.Rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(res.Rows), len(want))
+	}
+	for i, w := range want {
+		r := res.Rows[i]
+		if r.Key[0] != w.key || r.Change != w.change || !reflect.DeepEqual(r.Cells, w.cells) {
+			t.Errorf("row %d = %v %d %v, want %s %d %v", i, r.Key, r.Change, r.Cells, w.key, w.change, w.cells)
+		}
+	}
+	if res.Added != 1 || res.Removed != 1 || res.Modified != 1 || res.Same != 2 || res.Equal() {
+		t.Errorf("counts = %d %d %d %d", res.Added, res.Removed, res.Modified, res.Same)
+	}
+
+	if _, err := CompareCsv(header1, rows1, header2, rows2, []string{"sku"}, nil); err == nil {
+		t.Errorf("CompareCsv() with a missing key column, want an error")
+	}
+}
+
+func TestCompareCsvColumns(t *testing.T) {
+
+	// a column added, and duplicate keys paired in file order
+	res, err := CompareCsv([]string{"k", "v"}, [][]string{{"a", "1"}, {"a", "2"}},
+		[]string{"k", "v", "w"}, [][]string{{"a", "1", ""}, {"a", "3", "x"}}, []string{"k"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Header, []string{"k", "v", "w"}) || !reflect.DeepEqual(res.Columns1, []int{0, 1, -1}) {
+		t.Errorf("Header = %v, Columns1 = %v", res.Header, res.Columns1)
+	}
+	if res.Same != 1 || res.Modified != 1 || res.Equal() {
+		t.Errorf("Same = %d, Modified = %d", res.Same, res.Modified)
+	}
+	if cells := res.Rows[1].Cells; len(cells) != 2 || cells[0].New != "3" || cells[1].New != "x" {
+		t.Errorf("Cells = %v", cells)
+	}
+}
+
+func TestCompareCsvIgnore(t *testing.T) {
+
+	header1 := []string{"id", "name", "load_ts", "batch_id"}
+	rows1 := [][]string{{"1", "bolt", "10:00", "7"}, {"2", "nut", "10:00", "7"}}
+	header2 := []string{"id", "name", "load_ts", "batch_id", "note"}
+	rows2 := [][]string{{"1", "bolt", "11:00", "8", ""}, {"2", "nuts", "11:00", "8", "x"}}
+
+	tests := []struct {
+		opts     CsvCompareOptions
+		ignored  []bool
+		modified int
+		cells    int
+	}{
+		{CsvCompareOptions{Ignore: []string{"load_ts", "batch_id", "id"}}, []bool{false, false, true, true, false}, 1, 2},
+		{CsvCompareOptions{Only: []string{"name"}}, []bool{false, false, true, true, true}, 1, 1},
+		{CsvCompareOptions{Ignore: []string{"load_ts", "batch_id", "name", "note"}}, []bool{false, true, true, true, true}, 0, 0},
+	}
+
+	for i, test := range tests {
+		res, err := CompareCsv(header1, rows1, header2, rows2, []string{"id"}, &test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res.Ignored, test.ignored) {
+			t.Errorf("%d: Ignored = %v, want %v", i, res.Ignored, test.ignored)
+		}
+		if res.Modified != test.modified || len(res.Rows[1].Cells) != test.cells {
+			t.Errorf("%d: Modified = %d, Cells = %v", i, res.Modified, res.Rows[1].Cells)
+		}
+		if res.Equal() != (test.modified == 0) {
+			t.Errorf("%d: Equal() = %v", i, res.Equal())
+		}
+	}
+}
+
+func TestCompareCsvKeys(t *testing.T) {
+
+	tests := []struct {
+		key1, key2 []string
+		want       int
+	}{
+		{[]string{"2"}, []string{"10"}, -1},
+		{[]string{"b"}, []string{"a"}, 1},
+		{[]string{"1", "b"}, []string{"1", "c"}, -1},
+		{[]string{"1.5", "x"}, []string{"1.5", "x"}, 0},
+		{[]string{"9"}, []string{"a"}, -1},
+	}
+
+	for _, test := range tests {
+		got := CompareCsvKeys(test.key1, test.key2)
+		if got < 0 {
+			got = -1
+		} else if got > 0 {
+			got = 1
+		}
+		if got != test.want {
+			t.Errorf("CompareCsvKeys(%v, %v) = %d, want %d", test.key1, test.key2, got, test.want)
+		}
+	}
+}
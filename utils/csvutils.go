@@ -2,101 +2,106 @@ package utils
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/csv"
-	"fmt"
 	"github.com/csimplestring/go-csv/detector"
+	"io"
 	"os"
-	"strings"
+	"unicode/utf8"
 )
 
-//ColumnReorder is to reorder the CSV columns
-func ColumnReorder(filePath string, columns []int,delimiter string) {
-
-	buf := bytes.Buffer{}
-	//defer buf.Reset()
+//ColumnReorder is to reorder the CSV columns, written to filePath + ".colreordered".
+//A column index of -1 gives an empty field. The delimiter is detected if empty, fields are quoted as needed.
+func ColumnReorder(filePath string, columns []int, delimiter string) error {
 
 	file, err := os.Open(filePath)
 	if err != nil {
-		_ = fmt.Errorf("error in reading file %s", err)
+		return err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	var newColumn []string
+	reader, err := newReader(file, delimiter)
+	if err != nil {
+		return err
+	}
 
-	/*writer, wFile := getWriter(filePath)
-	defer wFile.Close()*/
+	wFile, err := os.Create(filePath + ".colreordered")
+	if err != nil {
+		return err
+	}
+	defer wFile.Close()
 
-	for line, err := reader.Read(); err == nil; line, err = reader.Read() {
-		for _, v := range columns {
-			newColumn = append(newColumn, line[v])
-		}
+	outFile := bufio.NewWriter(wFile)
+	writer := csv.NewWriter(outFile)
+	writer.Comma = reader.Comma
+	var newColumn []string
 
-		if _, err = buf.WriteString(strings.Join(newColumn, delimiter) + "\n"); err != nil {
-			fmt.Println("Error:", err)
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return err
+		}
+		for _, v := range columns {
+			if v >= 0 && v < len(line) {
+				newColumn = append(newColumn, line[v])
+			} else {
+				newColumn = append(newColumn, "")
+			}
+		}
 
-		/*if err = writer.Write(newColumn); err != nil {
-			fmt.Println("Error:", err)
-			break
+		if err = writer.Write(newColumn); err != nil {
+			return err
 		}
-		writer.Flush()*/
 
 		newColumn = newColumn[:0]
 	}
-
-	writeToFile(filePath+".colreordered", buf.Bytes())
-}
-
-func writeToFile(filePath string, buf []byte) {
-	wFile, err := os.Create(filePath)
-	if err != nil {
-		fmt.Println("writeToFile Error: ", err)
-		return
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
 	}
-	defer wFile.Close()
-
-	outFile := bufio.NewWriter(wFile)
-	outFile.Write(buf)
-	outFile.Flush()
+	if err := outFile.Flush(); err != nil {
+		return err
+	}
+	return wFile.Close()
 }
 
-/*func getWriter(filePath string) (*csv.Writer, *os.File) {
-	// Creating csv writer
-	wFile, err := os.Create(filePath + ".colreordered")
-	if err != nil {
-		fmt.Println("Error:", err)
-		return nil, nil
+//newReader : a CSV reader with the given delimiter, detected if empty, and records of any length
+func newReader(file *os.File, delimiter string) (*csv.Reader, error) {
+	if delimiter == "" {
+		delimiter = DetectCsvDelimiterReader(file)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
 	}
-
-	writer := csv.NewWriter(wFile)
-	return writer, wFile
-}*/
+	reader := csv.NewReader(file)
+	if comma, _ := utf8.DecodeRuneInString(delimiter); comma != utf8.RuneError {
+		reader.Comma = comma
+	}
+	reader.FieldsPerRecord = -1
+	return reader, nil
+}
 
 //GetColumnCount : return the CSV column count
-func GetColumnCount(filePath string) int {
-	line := GetHeader(filePath)
-	return len(line)
+func GetColumnCount(filePath string, delimiter string) (int, error) {
+	line, err := GetHeader(filePath, delimiter)
+	return len(line), err
 }
 
-//GetHeader : return the CSV header
-func GetHeader(filePath string) []string {
+//GetHeader : return the CSV header, the delimiter is detected if empty
+func GetHeader(filePath string, delimiter string) ([]string, error) {
 	file, err := os.Open(filePath)
-	defer file.Close()
-
 	if err != nil {
-		_ = fmt.Errorf("error in reading file %s", err)
+		return nil, err
 	}
+	defer file.Close()
 
-	reader := csv.NewReader(file)
-	line, err := reader.Read()
-
+	reader, err := newReader(file, delimiter)
 	if err != nil {
-		_ = fmt.Errorf("error in reading CSV file %s", err)
+		return nil, err
 	}
-	return line
+	return reader.Read()
 }
 
 //HeaderPositionEqual :test whether the given two string slices are equal
@@ -112,16 +117,24 @@ func HeaderPositionEqual(a, b []int) bool {
 	return true
 }
 
-//DetectCsvDelimiter - detect and get csv delimiter
-func DetectCsvDelimiter(filePath string) string{
-	detector := detector.New()
-
-	file, err := os.OpenFile(filePath, os.O_RDONLY, os.ModePerm)
+//DetectCsvDelimiter - detect and get csv delimiter, "," if none is found
+func DetectCsvDelimiter(filePath string) (string, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
-		os.Exit(1)
+		return "", err
 	}
 	defer file.Close()
 
-	delimiters := detector.DetectDelimiter(file, '"')
+	return DetectCsvDelimiterReader(file), nil
+}
+
+//DetectCsvDelimiterReader - detect and get csv delimiter, "," if none is found
+func DetectCsvDelimiterReader(r io.Reader) string {
+	detector := detector.New()
+
+	delimiters := detector.DetectDelimiter(r, '"')
+	if len(delimiters) == 0 || delimiters[0] == "" {
+		return ","
+	}
 	return delimiters[0]
-}
\ No newline at end of file
+}
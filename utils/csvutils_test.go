@@ -2,32 +2,141 @@ package utils
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
+// quoted fields, with delimiters, line breaks and quotes in them
+const testCsvData = "id;name;note\n1;\"gear; big\";\"two\nlines\"\n2;nut;\"say \"\"hi\"\"\"\n"
+
+func writeTestCsv(t *testing.T, data string) string {
+	dir, err := ioutil.TempDir("", "csvutils")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(dir, "test.csv")
+	if err := ioutil.WriteFile(filePath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
 func TestColumnReorder(t *testing.T) {
-	filePath := filepath.Join("data", "base-small.csv")
+	filePath := filepath.Join("..", "data", "base-small.csv")
 
 	t.Run("TestColumnReorder", func(t *testing.T) {
-		columnCount := GetColumnCount(filePath)
+		filePath := writeTestCsv(t, mustReadFile(t, filePath))
+		defer os.RemoveAll(filepath.Dir(filePath))
+
+		columnCount, err := GetColumnCount(filePath, ",")
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		var reorderData []int
 		for i := 0; i < columnCount; i++ {
 			reorderData = append(reorderData, i)
 		}
-		utils.RandShuffle(reorderData)
+		RandShuffle(reorderData)
 		//[]int{0, 2, 1, 4, 3, 5, 6, 7, 8, 9, 10}
-		ColumnReorder(filePath, reorderData)
+		if err := ColumnReorder(filePath, reorderData, ","); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := GetColumnCount(filePath+".colreordered", ","); got != columnCount || err != nil {
+			t.Errorf("GetColumnCount() = %v %v, want %v", got, err, columnCount)
+		}
+	})
+
+	t.Run("Quoted fields", func(t *testing.T) {
+		filePath := writeTestCsv(t, testCsvData)
+		defer os.RemoveAll(filepath.Dir(filePath))
+
+		want := "note;id;;name\n\"two\nlines\";1;;\"gear; big\"\n\"say \"\"hi\"\"\";2;;nut\n"
+		// given, and detected
+		for _, delimiter := range []string{";", ""} {
+			if err := ColumnReorder(filePath, []int{2, 0, -1, 1}, delimiter); err != nil {
+				t.Fatal(err)
+			}
+			if got := mustReadFile(t, filePath+".colreordered"); got != want {
+				t.Errorf("ColumnReorder(%q) =\n%s\nwant\n%s", delimiter, got, want)
+			}
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if err := ColumnReorder(filepath.Join("..", "data", "missing.csv"), []int{0}, ","); err == nil {
+			t.Errorf("ColumnReorder() of a missing file, want an error")
+		}
+
+		filePath := writeTestCsv(t, "id,name\n1,\"not closed\n")
+		defer os.RemoveAll(filepath.Dir(filePath))
+		if err := ColumnReorder(filePath, []int{1, 0}, ","); err == nil {
+			t.Errorf("ColumnReorder() of a bad quote, want an error")
+		}
 	})
 }
 
+func TestGetHeader(t *testing.T) {
+	filePath := writeTestCsv(t, testCsvData)
+	defer os.RemoveAll(filepath.Dir(filePath))
+
+	for _, delimiter := range []string{";", ""} {
+		if got, err := GetHeader(filePath, delimiter); err != nil || !reflect.DeepEqual(got, []string{"id", "name", "note"}) {
+			t.Errorf("GetHeader(%q) = %v %v", delimiter, got, err)
+		}
+	}
+	if got, err := DetectCsvDelimiter(filePath); got != ";" || err != nil {
+		t.Errorf("DetectCsvDelimiter() = %q %v, want \";\"", got, err)
+	}
+	if _, err := DetectCsvDelimiter(filePath + ".missing"); err == nil {
+		t.Errorf("DetectCsvDelimiter() of a missing file, want an error")
+	}
+	if _, err := GetHeader(filePath+".missing", ";"); err == nil {
+		t.Errorf("GetHeader() of a missing file, want an error")
+	}
+}
+
 func TestGetColumnCount(t *testing.T) {
 	t.Run("Get CSV Column Count", func(t *testing.T) {
-		if got := GetColumnCount(filepath.Join("data", "base-small.csv")); got == 0 {
-			t.Errorf("GetColumnCount() = %v", got)
+		if got, err := GetColumnCount(filepath.Join("..", "data", "base-small.csv"), ","); got == 0 || err != nil {
+			t.Errorf("GetColumnCount() = %v %v", got, err)
 		} else {
 			fmt.Printf("Given CSV Column Count : %d", got)
 		}
 	})
 }
+
+func TestHeaderPositionEqual(t *testing.T) {
+	if !HeaderPositionEqual([]int{0, 2, 1}, []int{0, 2, 1}) || HeaderPositionEqual([]int{0, 2, 1}, []int{0, 1, 2}) {
+		t.Errorf("HeaderPositionEqual()")
+	}
+}
+
+func TestDetectCsvDelimiterReader(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"semicolon", testCsvData, ";"},
+		{"comma", "id,name\n1,\"a, b\"\n2,c\n", ","},
+		{"none", "id\n1\n", ","},
+	}
+	for _, test := range tests {
+		if got := DetectCsvDelimiterReader(strings.NewReader(test.data)); got != test.want {
+			t.Errorf("%s: DetectCsvDelimiterReader() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func mustReadFile(t *testing.T, filePath string) string {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"errors"
+)
+
+// Header of the files written by the unix compress command: magic number, then max code size and block mode
+const (
+	LZW_MAGIC1     = 0x1f
+	LZW_MAGIC2     = 0x9d
+	LZW_BITS_MASK  = 0x1f
+	LZW_BLOCK_MODE = 0x80
+)
+
+const (
+	lzw_init_bits = 9
+	lzw_clear     = 256 // reset the code table, block mode only
+)
+
+var ErrLZWFormat = errors.New("lzw: not in compress (.Z) format")
+var ErrLZWCorrupt = errors.New("lzw: corrupt input")
+
+//
+// Uncompress a .Z file, written by the unix compress command.
+// This is not the same as compress/lzw, which reads the GIF and TIFF variants:
+// the code size goes from 9 up to 16 bits, and the codes are written in groups of 8,
+// with the unused part of a group skipped when the code size changes.
+//
+func DecodeLZW(data []byte) ([]byte, error) {
+
+	if len(data) < 3 || data[0] != LZW_MAGIC1 || data[1] != LZW_MAGIC2 {
+		return nil, ErrLZWFormat
+	}
+	maxbits := int(data[2] & LZW_BITS_MASK)
+	block_mode := data[2]&LZW_BLOCK_MODE != 0
+	if maxbits < lzw_init_bits || maxbits > 16 {
+		return nil, ErrLZWFormat
+	}
+	in := data[3:]
+
+	maxmaxcode := 1 << uint(maxbits)
+	prefix := make([]uint16, maxmaxcode)
+	suffix := make([]byte, maxmaxcode)
+	for i := 0; i < 256; i++ {
+		suffix[i] = byte(i)
+	}
+
+	n_bits := lzw_init_bits
+	maxcode := 1<<uint(n_bits) - 1
+	free_ent := 256
+	if block_mode {
+		free_ent = lzw_clear + 1
+	}
+
+	// skip to the end of the current group of 8 codes, counted from start
+	align := func(pos, start int) int {
+		group := n_bits * 8
+		if n := (pos - start) % group; n > 0 {
+			pos += group - n
+		}
+		return pos
+	}
+
+	out := make([]byte, 0, len(data)*3)
+	stack := make([]byte, 0, 256)
+	oldcode := -1
+	var finchar byte
+	pos, start := 0, 0 // bit position of the next code, and of the first code with the current size
+
+	for {
+		if free_ent > maxcode {
+			pos = align(pos, start)
+			start = pos
+			n_bits++
+			if n_bits == maxbits {
+				maxcode = maxmaxcode
+			} else {
+				maxcode = 1<<uint(n_bits) - 1
+			}
+		}
+
+		if pos+n_bits > len(in)*8 {
+			break
+		}
+		var v uint32
+		for i := pos >> 3; i < len(in) && i <= (pos+n_bits-1)>>3; i++ {
+			v |= uint32(in[i]) << uint(8*(i-pos>>3))
+		}
+		code := int(v>>uint(pos&7)) & (1<<uint(n_bits) - 1)
+		pos += n_bits
+
+		if oldcode == -1 {
+			if code >= 256 {
+				return nil, ErrLZWCorrupt
+			}
+			finchar = byte(code)
+			oldcode = code
+			out = append(out, finchar)
+			continue
+		}
+
+		if code == lzw_clear && block_mode {
+			// table entry 256 is added with the next code, and never used
+			pos = align(pos, start)
+			start = pos
+			free_ent = lzw_clear
+			n_bits = lzw_init_bits
+			maxcode = 1<<uint(n_bits) - 1
+			continue
+		}
+
+		incode := code
+		stack = stack[:0]
+		if code >= free_ent {
+			// the code being defined: previous string + its first char
+			if code > free_ent {
+				return nil, ErrLZWCorrupt
+			}
+			stack = append(stack, finchar)
+			code = oldcode
+		}
+		for code >= 256 {
+			stack = append(stack, suffix[code])
+			code = int(prefix[code])
+		}
+		finchar = suffix[code]
+		stack = append(stack, finchar)
+
+		for i := len(stack) - 1; i >= 0; i-- {
+			out = append(out, stack[i])
+		}
+
+		if free_ent < maxmaxcode {
+			prefix[free_ent] = uint16(oldcode)
+			suffix[free_ent] = finchar
+			free_ent++
+		}
+		oldcode = incode
+	}
+
+	return out, nil
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestDecodeLZW(t *testing.T) {
+
+	tests := []struct {
+		data []byte
+		want string
+		err  error
+	}{
+		// compress <<< TOBEORNOTTOBEORTOBEORNOT#
+		{[]byte{0x1f, 0x9d, 0x90, 0x54, 0x9e, 0x08, 0x29, 0xf2, 0x44, 0x8a, 0x93, 0x27,
+			0x54, 0x02, 0x0e, 0x2c, 0xa8, 0x90, 0xa0, 0x41, 0x84, 0x23, 0x00}, "TOBEORNOTTOBEORTOBEORNOT#", nil},
+		// a code that is being defined, ie. "aba" after "ab"
+		{[]byte{0x1f, 0x9d, 0x90, 0x61, 0xc4, 0x04, 0x1c, 0x28, 0xb0, 0x20, 0xc1, 0x83,
+			0x06, 0x13, 0x06, 0x54, 0x00}, "abababababababababababababababab\n", nil},
+		{[]byte{0x1f, 0x9d, 0x90}, "", nil},
+		{[]byte{0x1f, 0x8b, 0x08, 0x00}, "", ErrLZWFormat},
+		{[]byte{0x1f, 0x9d, 0x91}, "", ErrLZWFormat},
+		{[]byte{0x1f, 0x9d, 0x90, 0x54, 0xfe, 0xff}, "", ErrLZWCorrupt},
+	}
+
+	for i, test := range tests {
+		got, err := DecodeLZW(test.data)
+		if err != test.err || string(got) != test.want {
+			t.Errorf("%d: DecodeLZW() = %q, %v, want %q, %v", i, got, err, test.want, test.err)
+		}
+	}
+}